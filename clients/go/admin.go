@@ -0,0 +1,231 @@
+package nubdb
+
+import (
+"fmt"
+"strconv"
+"strings"
+"time"
+)
+
+// ClientInfo describes one connection reported by CLIENT LIST. Fields holds
+// every key=value pair of the record, including ones not mapped to a field.
+type ClientInfo struct {
+ID     int64
+Addr   string
+Name   string
+Age    time.Duration
+Idle   time.Duration
+Cmd    string
+Fields map[string]string
+}
+
+// ClientList returns the connections currently open on the server
+func (c *Client) ClientList(opts ...CallOption) ([]ClientInfo, error) {
+lines, err := c.sendCommandLines("CLIENT LIST", opts...)
+if err != nil {
+return nil, err
+}
+
+clients := make([]ClientInfo, 0, len(lines))
+for _, line := range lines {
+if strings.TrimSpace(line) == "" {
+continue
+}
+clients = append(clients, parseClientInfo(line))
+}
+
+return clients, nil
+}
+
+// parseClientInfo parses a "id=1 addr=127.0.0.1:5000 age=3 ..." record,
+// ignoring tokens that are not key=value pairs or fail to parse
+func parseClientInfo(line string) ClientInfo {
+info := ClientInfo{Fields: make(map[string]string)}
+for _, field := range strings.Fields(line) {
+key, value, ok := strings.Cut(field, "=")
+if !ok {
+continue
+}
+info.Fields[key] = value
+
+switch key {
+case "id":
+info.ID, _ = strconv.ParseInt(value, 10, 64)
+case "addr":
+info.Addr = value
+case "name":
+info.Name = value
+case "age":
+info.Age = parseSeconds(value)
+case "idle":
+info.Idle = parseSeconds(value)
+case "cmd":
+info.Cmd = value
+}
+}
+
+return info
+}
+
+func parseSeconds(value string) time.Duration {
+seconds, _ := strconv.ParseInt(value, 10, 64)
+return time.Duration(seconds) * time.Second
+}
+
+// HealthReport summarizes the state of the server as seen by the client
+type HealthReport struct {
+// Reachable is false if the probe could not complete a round trip
+Reachable bool
+// Degraded is set when the server answered but took longer than
+// Config.SlowLatency
+Degraded bool
+Latency  time.Duration
+// Version is the server version from INFO, or empty if the server does
+// not report one
+Version string
+Keys    int64
+// Err is the server's error reply to SIZE, or the failure to parse its
+// reply, when the server is reachable but could not report its size
+Err error
+}
+
+// Health probes the server with SIZE and INFO in a single round trip. SIZE
+// stands in for PING, which the server does not implement, and the probe is
+// bounded like every other call with WithTimeout or WithDeadline rather
+// than a context. A slow answer is reported through HealthReport.Degraded
+// and a bad SIZE reply through HealthReport.Err; the error is only set,
+// together with Reachable false, when the round trip fails.
+func (c *Client) Health(opts ...CallOption) (HealthReport, error) {
+var report HealthReport
+
+start := time.Now()
+responses, err := c.sendCommands([]string{"SIZE", "INFO"}, opts...)
+if err != nil {
+return report, err
+}
+report.Latency = time.Since(start)
+report.Reachable = true
+
+slow := c.config.SlowLatency
+if slow == 0 {
+slow = DefaultSlowLatency
+}
+report.Degraded = report.Latency > slow
+
+if report.Err = parseServerError(responses[0]); report.Err == nil {
+report.Keys, report.Err = parseSizeReply(responses[0])
+}
+
+// Servers without INFO answer with an error, which only means the
+// version is unknown
+if parseServerError(responses[1]) == nil {
+report.Version = parseInfoVersion(responses[1])
+}
+
+return report, nil
+}
+
+// parseInfoVersion extracts the version from an INFO reply made of
+// "key:value" fields, accepting "version" or any "*_version" key
+func parseInfoVersion(response string) string {
+for _, field := range strings.Fields(response) {
+key, value, ok := strings.Cut(field, ":")
+if ok && (key == "version" || strings.HasSuffix(key, "_version")) {
+return value
+}
+}
+return ""
+}
+
+// Wait blocks until the writes sent so far on this connection have reached
+// numReplicas replicas or timeout has passed, and returns how many replicas
+// acknowledged them. Reaching the timeout with fewer acknowledgements is not
+// an error; compare the result with numReplicas. A zero timeout waits
+// without limit on the server, so pair it with WithTimeout or WithDeadline.
+func (c *Client) Wait(numReplicas int, timeout time.Duration, opts ...CallOption) (int, error) {
+response, err := c.sendCommand(fmt.Sprintf("WAIT %d %d", numReplicas, timeout.Milliseconds()), opts...)
+if err != nil {
+return 0, err
+}
+
+acked, err := parseIntReply(response)
+if err != nil || acked < 0 {
+return 0, fmt.Errorf("invalid response: %s", response)
+}
+return int(acked), nil
+}
+
+// ConfigGet returns the server configuration parameters matching pattern,
+// which may contain glob wildcards such as "max*", mapped to their values.
+// The reply is a "*<n>" list of alternating names and values; a pattern
+// matching nothing yields an empty map. A server that answers with a bare
+// value instead has it returned under pattern itself.
+func (c *Client) ConfigGet(pattern string, opts ...CallOption) (map[string]string, error) {
+cmd, err := encodeArgs("ConfigGet", []interface{}{"CONFIG GET", pattern})
+if err != nil {
+return nil, err
+}
+
+lines, err := c.sendCommandLines(cmd, opts...)
+if err != nil {
+return nil, err
+}
+params := make(map[string]string, len(lines)/2)
+if len(lines) == 1 {
+if !isNotFound(lines[0]) {
+params[pattern] = unquoteArg(lines[0])
+}
+return params, nil
+}
+if len(lines)%2 != 0 {
+return nil, fmt.Errorf("invalid response: %d lines, want name and value pairs", len(lines))
+}
+for i := 0; i < len(lines); i += 2 {
+params[unquoteArg(lines[i])] = unquoteArg(lines[i+1])
+}
+return params, nil
+}
+
+// ConfigSet sets the server configuration parameter param to value
+func (c *Client) ConfigSet(param, value string, opts ...CallOption) error {
+cmd, err := encodeArgs("ConfigSet", []interface{}{"CONFIG SET", param, value})
+if err != nil {
+return err
+}
+
+response, err := c.sendCommand(cmd, opts...)
+if err != nil {
+return err
+}
+if response != "OK" {
+return fmt.Errorf("unexpected response: %s", response)
+}
+return nil
+}
+
+// ServerTime returns the server's clock, read with TIME, for computing
+// absolute times such as expiries against the server's clock rather than
+// the local one. The reply is the Unix time in seconds and the
+// microseconds within that second, as a "*2" list or on one line.
+func (c *Client) ServerTime(opts ...CallOption) (time.Time, error) {
+lines, err := c.sendCommandLines("TIME", opts...)
+if err != nil {
+return time.Time{}, err
+}
+if len(lines) == 1 {
+lines = strings.Fields(lines[0])
+}
+if len(lines) != 2 {
+return time.Time{}, fmt.Errorf("invalid TIME response: %q", lines)
+}
+
+seconds, err := strconv.ParseInt(unquoteArg(lines[0]), 10, 64)
+if err != nil {
+return time.Time{}, fmt.Errorf("invalid TIME response: %q", lines)
+}
+micros, err := strconv.ParseInt(unquoteArg(lines[1]), 10, 64)
+if err != nil || micros < 0 || micros >= 1e6 {
+return time.Time{}, fmt.Errorf("invalid TIME response: %q", lines)
+}
+return time.Unix(seconds, micros*1e3), nil
+}
@@ -0,0 +1,233 @@
+package nubdb
+
+import (
+"errors"
+"path"
+"reflect"
+"testing"
+"time"
+)
+
+func TestClientList(t *testing.T) {
+client := connectFake(t, func(line string) string {
+if line != "CLIENT LIST" {
+return "ERROR: Unknown command"
+}
+return "*2\n" +
+"id=3 addr=127.0.0.1:51000 name=worker age=120 idle=5 cmd=get flags=N\n" +
+"id=4 addr=10.0.0.7:42000 age=7 idle=0 cmd=client|list future=yes oddtoken"
+})
+
+clients, err := client.ClientList()
+if err != nil {
+t.Fatalf("ClientList: %v", err)
+}
+if len(clients) != 2 {
+t.Fatalf("got %d clients, want 2", len(clients))
+}
+
+first := clients[0]
+if first.ID != 3 || first.Addr != "127.0.0.1:51000" || first.Name != "worker" ||
+first.Age != 120*time.Second || first.Idle != 5*time.Second || first.Cmd != "get" {
+t.Errorf("first client = %+v", first)
+}
+if first.Fields["flags"] != "N" {
+t.Errorf("unknown field not preserved: %v", first.Fields)
+}
+
+second := clients[1]
+if second.ID != 4 || second.Cmd != "client|list" || second.Fields["future"] != "yes" {
+t.Errorf("second client = %+v", second)
+}
+
+// The connection must still be in sync after the multi-line reply
+if _, err := client.ClientList(); err != nil {
+t.Fatalf("second ClientList: %v", err)
+}
+}
+
+func TestHealth(t *testing.T) {
+client := connectFake(t, func(line string) string {
+switch line {
+case "SIZE":
+return "42 keys"
+case "INFO":
+return "nubdb_version:1.4.2 uptime_in_seconds:30"
+}
+return "ERROR: Unknown command"
+})
+
+report, err := client.Health()
+if err != nil {
+t.Fatalf("Health: %v", err)
+}
+if !report.Reachable || report.Degraded || report.Keys != 42 || report.Version != "1.4.2" {
+t.Errorf("report = %+v", report)
+}
+if report.Latency <= 0 {
+t.Errorf("latency not measured: %v", report.Latency)
+}
+}
+
+func TestHealthDegradedWithoutInfo(t *testing.T) {
+config := startFakeServer(t, func(line string) string {
+if line == "SIZE" {
+time.Sleep(30 * time.Millisecond)
+return "7"
+}
+return "ERROR: Unknown command"
+})
+config.SlowLatency = 10 * time.Millisecond
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+report, err := client.Health()
+if err != nil {
+t.Fatalf("slow server should not be an error: %v", err)
+}
+if !report.Reachable || !report.Degraded || report.Keys != 7 || report.Version != "" {
+t.Errorf("report = %+v", report)
+}
+}
+
+func TestHealthSizeError(t *testing.T) {
+client := connectFake(t, func(line string) string {
+if line == "SIZE" {
+return "ERROR: LOADING dataset in memory"
+}
+return "nubdb_version:1.4.2"
+})
+
+report, err := client.Health()
+if err != nil {
+t.Fatalf("a SIZE error reply should not fail Health: %v", err)
+}
+var serr *ServerError
+if !report.Reachable || !errors.As(report.Err, &serr) || report.Version != "1.4.2" {
+t.Errorf("report = %+v, want reachable with the SIZE error recorded", report)
+}
+}
+
+func TestHealthUnreachable(t *testing.T) {
+config := startFakeServer(t, func(line string) string {
+return "ERROR: Unknown command"
+})
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+client.conn.Close()
+
+report, err := client.Health(WithTimeout(time.Second))
+if err == nil {
+t.Fatal("Health on a dead connection should fail")
+}
+if report.Reachable {
+t.Errorf("report = %+v, want unreachable", report)
+}
+}
+
+func TestWait(t *testing.T) {
+var sent string
+client := connectFake(t, func(line string) string {
+sent = line
+if line == "WAIT 3 250" {
+return "1"
+}
+return "2"
+})
+
+acked, err := client.Wait(2, time.Second)
+if err != nil || acked != 2 || sent != "WAIT 2 1000" {
+t.Errorf("Wait(2, 1s) = %d, %v; sent %q", acked, err, sent)
+}
+
+// Timing out with fewer replicas than asked for is not an error
+acked, err = client.Wait(3, 250*time.Millisecond)
+if err != nil || acked != 1 {
+t.Errorf("Wait(3, 250ms) = %d, %v; want 1, nil", acked, err)
+}
+}
+
+func TestConfigGetSet(t *testing.T) {
+config := map[string]string{"maxmemory": "100mb", "maxclients": "10000"}
+var sent []string
+client := connectFake(t, func(line string) string {
+sent = append(sent, line)
+fields := splitArgs(line)
+switch {
+case len(fields) == 3 && fields[0] == "CONFIG" && fields[1] == "GET" && fields[2] == "legacy":
+return `"old style"`
+case len(fields) == 3 && fields[0] == "CONFIG" && fields[1] == "GET":
+var pairs []string
+for param, value := range config {
+if ok, _ := path.Match(fields[2], param); ok {
+pairs = append(pairs, param, quoteArg(value))
+}
+}
+return multiLine(pairs...)
+case len(fields) == 4 && fields[0] == "CONFIG" && fields[1] == "SET":
+config[fields[2]] = fields[3]
+return "OK"
+}
+return "ERROR: syntax error"
+})
+
+if err := client.ConfigSet("save", "900 1"); err != nil {
+t.Fatalf("ConfigSet: %v", err)
+}
+if sent[0] != `CONFIG SET save "900 1"` {
+t.Errorf("ConfigSet sent %q", sent[0])
+}
+
+tests := map[string]map[string]string{
+"save":        {"save": "900 1"},
+"max*":        {"maxmemory": "100mb", "maxclients": "10000"},
+"*":           {"save": "900 1", "maxmemory": "100mb", "maxclients": "10000"},
+"legacy":      {"legacy": "old style"},
+"nonexistent": {},
+}
+for pattern, want := range tests {
+if got, err := client.ConfigGet(pattern); err != nil || !reflect.DeepEqual(got, want) {
+t.Errorf("ConfigGet(%s) = %v, %v, want %v", pattern, got, err, want)
+}
+}
+}
+
+func TestConfigGetOddReply(t *testing.T) {
+client := connectFake(t, func(line string) string {
+return multiLine("maxmemory", "100mb", "save")
+})
+if _, err := client.ConfigGet("*"); err == nil {
+t.Error("ConfigGet of an odd number of lines should fail")
+}
+}
+
+func TestServerTime(t *testing.T) {
+reply := multiLine("1714566600", "250000")
+client := connectFake(t, func(line string) string {
+if line != "TIME" {
+return "ERROR: unknown command"
+}
+return reply
+})
+
+want := time.Date(2024, 5, 1, 12, 30, 0, 250_000_000, time.UTC)
+for _, r := range []string{multiLine("1714566600", "250000"), multiLine(`"1714566600"`, `"250000"`), "1714566600 250000"} {
+reply = r
+if got, err := client.ServerTime(); err != nil || !got.Equal(want) {
+t.Errorf("ServerTime with reply %q = %s, %v, want %s", r, got, err, want)
+}
+}
+
+for _, r := range []string{multiLine("1714566600"), "soon", multiLine("1714566600", "1000000"), multiLine("x", "0")} {
+reply = r
+if got, err := client.ServerTime(); err == nil {
+t.Errorf("ServerTime with reply %q = %s, want an error", r, got)
+}
+}
+}
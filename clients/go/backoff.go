@@ -0,0 +1,65 @@
+package nubdb
+
+import (
+"fmt"
+"os"
+"time"
+)
+
+// DefaultReconnectBackoffMax caps reconnect waits when ReconnectBackoff.Max
+// is unset
+const DefaultReconnectBackoffMax = 10 * time.Second
+
+// ReconnectBackoff configures waiting before automatic reconnects, so that
+// clients which lost their connections together, as when a server restarts,
+// do not all redial it at the same moment. The client waits Initial before
+// the first reconnect after a failure, and twice as long after each
+// reconnect in a row that fails, up to Max. Jitter is the fraction of each
+// wait that is random: with 0.5 the client waits between half and all of it.
+// It applies to the reconnects of Config.AutoReset and Config.RetryReads,
+// not to explicit calls to Reset or Reconnect. A call whose deadline (see
+// WithDeadline) would pass during the wait fails at once with an error
+// matching os.ErrDeadlineExceeded.
+type ReconnectBackoff struct {
+Initial time.Duration // zero disables the backoff
+Max     time.Duration // zero means DefaultReconnectBackoffMax
+Jitter  float64       // from 0 to 1
+}
+
+// delay returns the wait before a reconnect when the last n reconnects
+// failed
+func (b ReconnectBackoff) delay(n int) time.Duration {
+limit := b.Max
+if limit <= 0 {
+limit = DefaultReconnectBackoffMax
+}
+wait := b.Initial
+for i := 0; i < n && wait < limit; i++ {
+wait *= 2
+}
+wait = min(wait, limit)
+return wait - time.Duration(float64(wait)*b.Jitter*jitterRand())
+}
+
+// reconnectSleep is a variable so tests can observe reconnect waits
+var reconnectSleep = time.Sleep
+
+// backoffRedial is redial for automatic reconnects, waiting first as
+// Config.ReconnectBackoff asks. It gives up without dialing if the wait
+// would run past a non-zero deadline. The caller must hold c.mu.
+func (c *Client) backoffRedial(start int, deadline time.Time) error {
+if c.config.ReconnectBackoff.Initial > 0 {
+wait := c.config.ReconnectBackoff.delay(c.reconnectFails)
+if !deadline.IsZero() && time.Until(deadline) < wait {
+return fmt.Errorf("call deadline exceeded: reconnect backoff of %s: %w", wait.Round(time.Millisecond), os.ErrDeadlineExceeded)
+}
+reconnectSleep(wait)
+}
+
+if err := c.redial(start); err != nil {
+c.reconnectFails++
+return err
+}
+c.reconnectFails = 0
+return nil
+}
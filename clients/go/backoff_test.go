@@ -0,0 +1,111 @@
+package nubdb
+
+import (
+"context"
+"errors"
+"net"
+"os"
+"sync/atomic"
+"testing"
+"time"
+)
+
+func TestReconnectBackoffDelay(t *testing.T) {
+defer func(orig func() float64) { jitterRand = orig }(jitterRand)
+
+backoff := ReconnectBackoff{Initial: 100 * time.Millisecond, Max: time.Second, Jitter: 0.5}
+for n, full := range []time.Duration{
+100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond,
+800 * time.Millisecond, time.Second, time.Second,
+} {
+jitterRand = func() float64 { return 0 }
+if got := backoff.delay(n); got != full {
+t.Errorf("delay(%d) without jitter = %s, want %s", n, got, full)
+}
+jitterRand = func() float64 { return 0.999999 }
+if got := backoff.delay(n); got <= full/2 || got > full/2+time.Millisecond {
+t.Errorf("delay(%d) at most jitter = %s, want just over %s", n, got, full/2)
+}
+}
+
+jitterRand = func() float64 { return 0.5 }
+if got := (ReconnectBackoff{Initial: time.Second}).delay(1000); got != DefaultReconnectBackoffMax {
+t.Errorf("delay after many failures = %s, want the default cap %s", got, DefaultReconnectBackoffMax)
+}
+}
+
+func TestReconnectBackoff(t *testing.T) {
+defer func(orig func() float64) { jitterRand = orig }(jitterRand)
+defer func(orig func(time.Duration)) { reconnectSleep = orig }(reconnectSleep)
+jitterRand = func() float64 { return 0.5 }
+var waits []time.Duration
+reconnectSleep = func(d time.Duration) { waits = append(waits, d) }
+
+store := newMemStore()
+store.data["k"] = "v"
+config, drops := startDroppingServer(t, 0, store.handle)
+config.AutoReset = true
+config.ReconnectBackoff = ReconnectBackoff{Initial: 10 * time.Millisecond, Max: 25 * time.Millisecond, Jitter: 0.5}
+var refusals atomic.Int64
+config.DialFunc = func(ctx context.Context, network, addr string) (net.Conn, error) {
+if refusals.Add(-1) >= 0 {
+return nil, errors.New("connection refused")
+}
+var d net.Dialer
+return d.DialContext(ctx, network, addr)
+}
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+if len(waits) != 0 {
+t.Fatalf("Connect waited %v", waits)
+}
+
+drops.Store(1)
+refusals.Store(2)
+client.Get("k")
+for i := 0; i < 2; i++ {
+if _, err := client.Get("k"); err == nil {
+t.Fatalf("Get %d succeeded while the server refused connections", i)
+}
+}
+if value, err := client.Get("k"); err != nil || value != "v" {
+t.Fatalf("Get after the server came back = %q, %v", value, err)
+}
+
+// A successful reconnect starts the backoff over
+drops.Store(1)
+client.Get("k")
+client.Get("k")
+
+want := []time.Duration{7500 * time.Microsecond, 15 * time.Millisecond, 18750 * time.Microsecond, 7500 * time.Microsecond}
+if len(waits) != len(want) {
+t.Fatalf("waited %v, want %v", waits, want)
+}
+for i := range want {
+if waits[i] != want[i] {
+t.Errorf("wait %d = %s, want %s", i, waits[i], want[i])
+}
+}
+
+// Explicit reconnects do not wait
+client.Reconnect()
+if len(waits) != len(want) {
+t.Errorf("Reconnect waited %s", waits[len(waits)-1])
+}
+
+// A call does not wait past its own deadline
+drops.Store(1)
+client.Get("k")
+if _, err := client.Get("k", WithTimeout(time.Millisecond)); !errors.Is(err, os.ErrDeadlineExceeded) {
+t.Errorf("Get with a timeout shorter than the backoff = %v, want os.ErrDeadlineExceeded", err)
+}
+if len(waits) != len(want) {
+t.Errorf("Get with a short timeout waited %s", waits[len(waits)-1])
+}
+if value, err := client.Get("k"); err != nil || value != "v" {
+t.Errorf("Get after the timed out reconnect = %q, %v", value, err)
+}
+}
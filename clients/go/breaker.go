@@ -0,0 +1,72 @@
+package nubdb
+
+import (
+"errors"
+"fmt"
+"time"
+)
+
+// ErrCircuitOpen is returned without contacting the server while the
+// circuit breaker set up by Config.CircuitBreaker is open
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// CircuitBreaker configures failing fast while the server is unreachable.
+// After Failures consecutive commands fail on the connection (I/O errors,
+// timeouts and failed redials; server error replies do not count), the
+// breaker opens and commands fail at once with ErrCircuitOpen for CoolDown.
+// The first command after that is let through as a probe: if it succeeds
+// the breaker closes, otherwise it opens for another CoolDown.
+type CircuitBreaker struct {
+Failures int // zero disables the breaker
+CoolDown time.Duration
+}
+
+// breakerState tracks Config.CircuitBreaker. It is guarded by c.mu.
+type breakerState struct {
+failures  int
+openUntil time.Time // zero while closed
+inCall    bool      // a command passed the breaker and has not finished
+failed    bool      // the command in progress failed
+}
+
+// breakerAllow fails with ErrCircuitOpen while the breaker is open and
+// otherwise marks a command as in progress. The caller must hold c.mu.
+func (c *Client) breakerAllow() error {
+if c.config.CircuitBreaker.Failures <= 0 {
+return nil
+}
+
+b := &c.breaker
+if wait := time.Until(b.openUntil); !b.openUntil.IsZero() && wait > 0 {
+return fmt.Errorf("%w: retry in %s", ErrCircuitOpen, wait.Round(time.Millisecond))
+}
+b.inCall, b.failed = true, false
+return nil
+}
+
+// breakerFail records that the command in progress failed on the
+// connection. The caller must hold c.mu.
+func (c *Client) breakerFail() {
+c.breaker.failed = true
+}
+
+// breakerDone settles the breaker once a command finishes. A failure opens
+// it after Failures in a row, or at once after a probe; a success closes
+// it. The caller must hold c.mu.
+func (c *Client) breakerDone() {
+b := &c.breaker
+if !b.inCall {
+return
+}
+b.inCall = false
+
+if !b.failed {
+b.failures = 0
+b.openUntil = time.Time{}
+return
+}
+b.failures++
+if b.failures >= c.config.CircuitBreaker.Failures || !b.openUntil.IsZero() {
+b.openUntil = time.Now().Add(c.config.CircuitBreaker.CoolDown)
+}
+}
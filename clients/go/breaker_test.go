@@ -0,0 +1,64 @@
+package nubdb
+
+import (
+"errors"
+"testing"
+"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+store := newMemStore()
+store.data["k"] = "v"
+config, drops := startDroppingServer(t, 0, store.handle)
+config.AutoReset = true
+config.CircuitBreaker = CircuitBreaker{Failures: 2, CoolDown: 100 * time.Millisecond}
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+// Error replies from the server are not failures
+if _, err := client.DoArgs("SET"); err == nil {
+t.Fatal("SET without arguments should fail")
+}
+
+drops.Store(3)
+for i := 0; i < 2; i++ {
+if _, err := client.Get("k"); err == nil || errors.Is(err, ErrCircuitOpen) {
+t.Fatalf("Get %d = %v, want a connection failure", i, err)
+}
+}
+start := time.Now()
+if _, err := client.Get("k"); !errors.Is(err, ErrCircuitOpen) {
+t.Fatalf("Get after 2 failures = %v, want ErrCircuitOpen", err)
+}
+if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+t.Errorf("open breaker took %s to fail", elapsed)
+}
+if drops.Load() != 1 {
+t.Errorf("open breaker sent a command, %d drops left", drops.Load())
+}
+
+// A failed probe opens the breaker again at once
+time.Sleep(120 * time.Millisecond)
+if _, err := client.Get("k"); err == nil || errors.Is(err, ErrCircuitOpen) {
+t.Fatalf("probe = %v, want a connection failure", err)
+}
+if _, err := client.Get("k"); !errors.Is(err, ErrCircuitOpen) {
+t.Fatalf("Get after a failed probe = %v, want ErrCircuitOpen", err)
+}
+
+// A successful probe closes it
+time.Sleep(120 * time.Millisecond)
+if value, err := client.Get("k"); err != nil || value != "v" {
+t.Fatalf("probe = %q, %v, want a success", value, err)
+}
+drops.Store(1)
+if _, err := client.Get("k"); err == nil || errors.Is(err, ErrCircuitOpen) {
+t.Fatalf("Get = %v, want a connection failure", err)
+}
+if value, err := client.Get("k"); err != nil || value != "v" {
+t.Fatalf("Get after one failure = %q, %v, want the breaker closed", value, err)
+}
+}
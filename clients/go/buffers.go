@@ -0,0 +1,45 @@
+package nubdb
+
+import "bufio"
+
+// MaxAdaptiveBufferSize caps the buffers grown by Config.AdaptiveBuffers, so
+// one huge value cannot pin a huge buffer for the life of the connection
+const MaxAdaptiveBufferSize = 1 << 20
+
+// minAdaptiveBufferSize is bufio's default size, where adaptive buffers start
+const minAdaptiveBufferSize = 4096
+
+// observeSizes records the largest reply line and command batch seen so
+// far for Config.AdaptiveBuffers. The caller must hold c.mu.
+func (c *Client) observeSizes(read, written int) {
+c.maxRead = max(c.maxRead, read)
+c.maxWritten = max(c.maxWritten, written)
+}
+
+// tuneBuffers grows the reader and writer to fit the largest messages seen,
+// when Config.AdaptiveBuffers is set. A buffer is only replaced while it
+// holds no data, and never shrinks. The caller must hold c.mu.
+func (c *Client) tuneBuffers() {
+if !c.config.AdaptiveBuffers {
+return
+}
+
+if size := adaptiveBufferSize(c.maxRead); size > c.reader.Size() && c.reader.Buffered() == 0 {
+r, _ := c.wire()
+c.reader = bufio.NewReaderSize(r, size)
+}
+if size := adaptiveBufferSize(c.maxWritten); size > c.writer.Size() && c.writer.Buffered() == 0 {
+_, w := c.wire()
+c.writer = bufio.NewWriterSize(w, size)
+}
+}
+
+// adaptiveBufferSize returns the power of two buffer size that fits n bytes,
+// between minAdaptiveBufferSize and MaxAdaptiveBufferSize
+func adaptiveBufferSize(n int) int {
+size := minAdaptiveBufferSize
+for size < n && size < MaxAdaptiveBufferSize {
+size *= 2
+}
+return size
+}
@@ -0,0 +1,65 @@
+package nubdb
+
+import (
+"strings"
+"testing"
+)
+
+func TestAdaptiveBuffers(t *testing.T) {
+store := newMemStore()
+store.data["big"] = strings.Repeat("x", 100*1024)
+store.data["huge"] = strings.Repeat("y", 3*MaxAdaptiveBufferSize)
+
+connect := func(adaptive bool) *Client {
+config := startFakeServer(t, store.handle)
+config.AdaptiveBuffers = adaptive
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+t.Cleanup(func() { client.Close() })
+return client
+}
+plain, adaptive := connect(false), connect(true)
+
+sizes := map[string]int{"big": 100 * 1024, "huge": 3 * MaxAdaptiveBufferSize, "k": 10 * 1024}
+get := func(client *Client, key string) func() {
+return func() {
+if value, err := client.Get(key); err != nil || len(value) != sizes[key] {
+t.Fatalf("Get(%s) = %d bytes, %v", key, len(value), err)
+}
+}
+}
+
+// Warm up: the first large reply is read with the default buffer
+get(adaptive, "big")()
+get(adaptive, "big")()
+if size := adaptive.reader.Size(); size != 128*1024 {
+t.Errorf("reader grew to %d bytes, want 128 KiB", size)
+}
+
+plainAllocs := testing.AllocsPerRun(20, get(plain, "big"))
+adaptiveAllocs := testing.AllocsPerRun(20, get(adaptive, "big"))
+if adaptiveAllocs >= plainAllocs {
+t.Errorf("%.0f allocations per Get with adaptive buffers, %.0f without; want fewer", adaptiveAllocs, plainAllocs)
+}
+
+// One huge value grows the buffer only to the cap
+get(adaptive, "huge")()
+get(adaptive, "big")()
+if size := adaptive.reader.Size(); size != MaxAdaptiveBufferSize {
+t.Errorf("reader grew to %d bytes, want the %d byte cap", size, MaxAdaptiveBufferSize)
+}
+
+// Large commands grow the writer too
+if err := adaptive.Set("k", strings.Repeat("z", sizes["k"]), 0); err != nil {
+t.Fatalf("Set: %v", err)
+}
+get(adaptive, "k")()
+if size := adaptive.writer.Size(); size != 16*1024 {
+t.Errorf("writer grew to %d bytes, want 16 KiB", size)
+}
+if size := plain.reader.Size(); size != minAdaptiveBufferSize {
+t.Errorf("reader without AdaptiveBuffers resized to %d bytes", size)
+}
+}
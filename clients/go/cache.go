@@ -0,0 +1,199 @@
+package nubdb
+
+import (
+"container/list"
+"strings"
+"sync"
+"time"
+)
+
+// valueCache is a bounded LRU cache of GET results. Values stay until
+// evicted or invalidated; misses, when negTTL is set, also expire after
+// negTTL. gen lets a lookup that raced with an invalidation avoid caching
+// the stale result it read.
+type valueCache struct {
+mu      sync.Mutex
+size    int
+values  bool          // cache values, not only misses
+negTTL  time.Duration // zero caches no misses
+entries map[string]*list.Element
+order   *list.List // front is most recently used
+gen     uint64
+hits    int64
+misses  int64
+}
+
+type cacheEntry struct {
+key     string
+value   string
+missing bool
+expires time.Time // for missing entries
+}
+
+// DefaultNegativeCacheSize bounds the cache of misses when
+// Config.NegativeCacheTTL is set without Config.CacheSize
+const DefaultNegativeCacheSize = 1024
+
+func newValueCache(size int, values bool, negTTL time.Duration) *valueCache {
+return &valueCache{
+size:    size,
+values:  values,
+negTTL:  negTTL,
+entries: make(map[string]*list.Element),
+order:   list.New(),
+}
+}
+
+// get returns the cached result for key: its value and whether it exists,
+// or ok false if nothing is cached
+func (vc *valueCache) get(key string) (value string, found, ok bool) {
+vc.mu.Lock()
+defer vc.mu.Unlock()
+
+elem, ok := vc.entries[key]
+if !ok {
+vc.misses++
+return "", false, false
+}
+entry := elem.Value.(*cacheEntry)
+if entry.missing && !time.Now().Before(entry.expires) {
+vc.order.Remove(elem)
+delete(vc.entries, key)
+vc.misses++
+return "", false, false
+}
+vc.order.MoveToFront(elem)
+vc.hits++
+return entry.value, !entry.missing, true
+}
+
+// generation returns the invalidation counter to pass to put
+func (vc *valueCache) generation() uint64 {
+vc.mu.Lock()
+defer vc.mu.Unlock()
+return vc.gen
+}
+
+// put caches the result of a lookup unless anything was invalidated since
+// gen was read, or the cache does not keep that kind of result
+func (vc *valueCache) put(key, value string, found bool, gen uint64) {
+vc.mu.Lock()
+defer vc.mu.Unlock()
+
+if gen != vc.gen || (found && !vc.values) || (!found && vc.negTTL <= 0) {
+return
+}
+entry := &cacheEntry{key: key, value: value, missing: !found}
+if !found {
+entry.expires = time.Now().Add(vc.negTTL)
+}
+
+if elem, ok := vc.entries[key]; ok {
+elem.Value = entry
+vc.order.MoveToFront(elem)
+return
+}
+vc.entries[key] = vc.order.PushFront(entry)
+if vc.order.Len() > vc.size {
+oldest := vc.order.Back()
+vc.order.Remove(oldest)
+delete(vc.entries, oldest.Value.(*cacheEntry).key)
+}
+}
+
+func (vc *valueCache) invalidate(key string) {
+vc.mu.Lock()
+defer vc.mu.Unlock()
+
+vc.gen++
+if elem, ok := vc.entries[key]; ok {
+vc.order.Remove(elem)
+delete(vc.entries, key)
+}
+}
+
+func (vc *valueCache) purge() {
+vc.mu.Lock()
+defer vc.mu.Unlock()
+
+vc.gen++
+vc.entries = make(map[string]*list.Element)
+vc.order.Init()
+}
+
+// cacheReadVerbs are commands that never modify the keyspace
+var cacheReadVerbs = map[string]bool{
+"GET": true, "EXISTS": true, "SIZE": true, "TTL": true, "PTTL": true,
+"GETRANGE": true, "MEMORY": true, "SCAN": true, "CLIENT": true,
+"INFO": true, "SCRIPT": true, "QUIT": true, "TYPE": true,
+"SMEMBERS": true, "SISMEMBER": true, "LRANGE": true, "LLEN": true,
+"HGET": true, "HGETALL": true, "HEXISTS": true, "TIME": true,
+"DBSIZE": true,
+}
+
+// cacheKeyedVerbs are writes whose first argument is the only key they modify
+var cacheKeyedVerbs = map[string]bool{
+"SET": true, "DELETE": true, "DEL": true, "INCR": true, "DECR": true,
+"INCRBY": true, "SETRANGE": true, "APPEND": true, "EXPIRE": true,
+"CAS": true, "SETNX": true, "SADD": true, "SREM": true,
+"LPUSH": true, "RPUSH": true, "LPOP": true, "RPOP": true,
+"HSET": true, "HDEL": true,
+}
+
+// observe invalidates whatever cmd, about to be sent on this client, may
+// change. Writes the cache cannot attribute to a single key, such as
+// CLEAR, EVAL or raw DoArgs commands, drop the whole cache.
+func (vc *valueCache) observe(cmd string) {
+fields := strings.Fields(cmd)
+if len(fields) == 0 {
+return
+}
+
+verb := strings.ToUpper(fields[0])
+switch {
+case cacheReadVerbs[verb]:
+case cacheKeyedVerbs[verb] && len(fields) > 1 && !strings.HasPrefix(fields[1], `"`):
+vc.invalidate(fields[1])
+default:
+vc.purge()
+}
+}
+
+// CacheCounts describes the client-side cache. A Get answered from the
+// cache, including a remembered miss, is a hit; one that went to the server
+// is a miss.
+type CacheCounts struct {
+Hits    int64
+Misses  int64
+Entries int
+}
+
+// CacheStats returns the hits and misses of the client-side cache since the
+// client connected and the number of keys it holds, or zero counts if
+// neither Config.CacheSize nor Config.NegativeCacheTTL is set. Gets
+// coalesced by Config.CoalesceGets count once.
+func (c *Client) CacheStats() CacheCounts {
+if c.cache == nil {
+return CacheCounts{}
+}
+
+c.cache.mu.Lock()
+defer c.cache.mu.Unlock()
+return CacheCounts{Hits: c.cache.hits, Misses: c.cache.misses, Entries: c.cache.order.Len()}
+}
+
+// Invalidate drops key from the client-side cache enabled by
+// Config.CacheSize, so the next Get reads it from the server. Use it when
+// another client may have changed the key.
+func (c *Client) Invalidate(key string) {
+if c.cache != nil {
+c.cache.invalidate(key)
+}
+}
+
+// InvalidateAll empties the client-side cache
+func (c *Client) InvalidateAll() {
+if c.cache != nil {
+c.cache.purge()
+}
+}
@@ -0,0 +1,187 @@
+package nubdb
+
+import (
+"strings"
+"sync/atomic"
+"testing"
+"time"
+)
+
+func connectCaching(t *testing.T, size int) (*Client, *memStore, *atomic.Int64) {
+t.Helper()
+
+store := newMemStore()
+var gets atomic.Int64
+config := startFakeServer(t, func(line string) string {
+if strings.HasPrefix(line, "GET ") {
+gets.Add(1)
+}
+return store.handle(line)
+})
+config.CacheSize = size
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+t.Cleanup(func() { client.Close() })
+return client, store, &gets
+}
+
+func TestCachedGet(t *testing.T) {
+client, store, gets := connectCaching(t, 16)
+
+client.Set("config", "v1", 0)
+for i := 0; i < 3; i++ {
+if value, _ := client.Get("config"); value != "v1" {
+t.Fatalf("Get = %q, want v1", value)
+}
+}
+if n := gets.Load(); n != 1 {
+t.Errorf("%d GETs sent, want 1", n)
+}
+
+// A change by another client is not seen until invalidated
+store.mu.Lock()
+store.data["config"] = "v2"
+store.mu.Unlock()
+if value, _ := client.Get("config"); value != "v1" {
+t.Errorf("Get = %q, want cached v1", value)
+}
+client.Invalidate("config")
+if value, _ := client.Get("config"); value != "v2" {
+t.Errorf("Get after Invalidate = %q, want v2", value)
+}
+if n := gets.Load(); n != 2 {
+t.Errorf("%d GETs sent, want 2", n)
+}
+}
+
+func TestCacheInvalidatedByWrites(t *testing.T) {
+client, _, gets := connectCaching(t, 16)
+
+client.Set("a", "1", 0)
+client.Get("a")
+client.Set("a", "2", 0)
+if value, _ := client.Get("a"); value != "2" {
+t.Errorf("Get after Set = %q, want 2", value)
+}
+
+client.Incr("a")
+if value, _ := client.Get("a"); value != "3" {
+t.Errorf("Get after Incr = %q, want 3", value)
+}
+
+client.Delete("a")
+if value, _ := client.Get("a"); value != "" {
+t.Errorf("Get after Delete = %q, want empty", value)
+}
+
+client.Set("b", "1", 0)
+client.Get("b")
+client.Clear()
+if value, _ := client.Get("b"); value != "" {
+t.Errorf("Get after Clear = %q, want empty", value)
+}
+
+if n := gets.Load(); n != 6 {
+t.Errorf("%d GETs sent, want 6 (every read followed a write)", n)
+}
+}
+
+func TestCacheBounded(t *testing.T) {
+client, _, gets := connectCaching(t, 2)
+
+for _, key := range []string{"a", "b", "c"} {
+client.Set(key, key, 0)
+client.Get(key)
+}
+gets.Store(0)
+
+// "a" was evicted, "b" and "c" are still cached
+client.Get("c")
+client.Get("b")
+client.Get("a")
+if n := gets.Load(); n != 1 {
+t.Errorf("%d GETs sent, want 1 for the evicted key", n)
+}
+}
+
+func TestNegativeCache(t *testing.T) {
+store := newMemStore()
+var gets atomic.Int64
+config := startFakeServer(t, func(line string) string {
+if strings.HasPrefix(line, "GET ") {
+gets.Add(1)
+}
+return store.handle(line)
+})
+config.NegativeCacheTTL = 50 * time.Millisecond
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+for i := 0; i < 3; i++ {
+if _, found, err := client.lookup("missing", nil); err != nil || found {
+t.Fatalf("lookup of a missing key: found = %v, %v", found, err)
+}
+}
+if n := gets.Load(); n != 1 {
+t.Errorf("%d GETs for a repeatedly missing key, want 1", n)
+}
+
+// Values are not cached without CacheSize
+client.Set("present", "v", 0)
+client.Get("present")
+client.Get("present")
+if n := gets.Load(); n != 3 {
+t.Errorf("%d GETs, want 3: values must not be cached", n)
+}
+
+// A Set forgets the miss at once
+client.Set("missing", "now here", 0)
+if value, _ := client.Get("missing"); value != "now here" {
+t.Errorf("Get after Set = %q", value)
+}
+
+// A miss expires after NegativeCacheTTL
+client.Get("gone")
+store.mu.Lock()
+store.data["gone"] = "appeared elsewhere"
+store.mu.Unlock()
+if value, _ := client.Get("gone"); value != "" {
+t.Errorf("Get within the negative TTL = %q, want the cached miss", value)
+}
+time.Sleep(60 * time.Millisecond)
+if value, _ := client.Get("gone"); value != "appeared elsewhere" {
+t.Errorf("Get after the negative TTL = %q", value)
+}
+}
+
+func TestCacheStats(t *testing.T) {
+client, _, gets := connectCaching(t, 16)
+
+client.SetWith("a", "v")
+for _, key := range []string{"a", "a", "a", "missing", "missing"} {
+client.Get(key)
+}
+client.SetWith("a", "v2")
+client.Get("a")
+
+// Misses are not cached without NegativeCacheTTL, so both Gets of
+// "missing" go to the server
+want := CacheCounts{Hits: 2, Misses: 4, Entries: 1}
+if stats := client.CacheStats(); stats != want {
+t.Errorf("CacheStats = %+v, want %+v", stats, want)
+}
+if n := gets.Load(); n != 4 {
+t.Errorf("%d GETs sent, want one per miss", n)
+}
+
+uncached := connectFake(t, newMemStore().handle)
+uncached.Get("a")
+if stats := uncached.CacheStats(); stats != (CacheCounts{}) {
+t.Errorf("CacheStats without a cache = %+v", stats)
+}
+}
@@ -0,0 +1,145 @@
+// Command nubdb-cli runs single NubDB commands from the shell, for smoke
+// testing a server and ad-hoc debugging.
+//
+// Usage:
+//
+//   nubdb-cli [flags] get KEY
+//   nubdb-cli [flags] set KEY [VALUE]
+//   nubdb-cli [flags] delete KEY
+//   nubdb-cli [flags] scan [PATTERN]
+//
+// set reads the value from standard input when VALUE is omitted or "-",
+// keeping it byte for byte apart from one trailing newline.
+package main
+
+import (
+"errors"
+"flag"
+"fmt"
+"io"
+"os"
+"strings"
+"time"
+
+nubdb "github.com/nub-coders/nubdt/clients/go"
+)
+
+func main() {
+os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes the command line args and returns the exit status
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+config := nubdb.DefaultConfig()
+flags := flag.NewFlagSet("nubdb-cli", flag.ContinueOnError)
+flags.SetOutput(stderr)
+flags.StringVar(&config.Host, "host", config.Host, "server host")
+flags.IntVar(&config.Port, "port", config.Port, "server port")
+flags.DurationVar(&config.Timeout, "timeout", config.Timeout, "dial timeout")
+endpoints := flags.String("endpoints", "", "comma-separated host:port list, overriding -host and -port")
+callTimeout := flags.Duration("call-timeout", 10*time.Second, "time limit for the command")
+flags.Usage = func() {
+fmt.Fprintln(stderr, "usage: nubdb-cli [flags] get KEY | set KEY [VALUE] | delete KEY | scan [PATTERN]")
+flags.PrintDefaults()
+}
+if err := flags.Parse(args); err != nil {
+return 2
+}
+if *endpoints != "" {
+config.Endpoints = strings.Split(*endpoints, ",")
+}
+
+cmd := flags.Args()
+if len(cmd) == 0 || !validArgs(cmd) {
+flags.Usage()
+return 2
+}
+
+client, err := nubdb.Connect(config)
+if err != nil {
+fmt.Fprintln(stderr, err)
+return 1
+}
+defer client.Close()
+
+if err := execute(client, cmd, stdin, stdout, nubdb.WithTimeout(*callTimeout)); err != nil {
+fmt.Fprintln(stderr, err)
+if errors.Is(err, nubdb.ErrKeyNotFound) {
+return 3
+}
+return 1
+}
+return 0
+}
+
+// validArgs checks the number of arguments of each command
+func validArgs(cmd []string) bool {
+switch strings.ToLower(cmd[0]) {
+case "get", "delete", "del":
+return len(cmd) == 2
+case "set":
+return len(cmd) == 2 || len(cmd) == 3
+case "scan":
+return len(cmd) <= 2
+}
+return false
+}
+
+func execute(client *nubdb.Client, cmd []string, stdin io.Reader, stdout io.Writer, opt nubdb.CallOption) error {
+switch strings.ToLower(cmd[0]) {
+case "get":
+value, err := client.Get(cmd[1], opt)
+if err != nil {
+return err
+}
+if value == "" {
+// Get does not tell an empty value from a missing key
+exists, err := client.Exists(cmd[1], opt)
+if err != nil {
+return err
+}
+if !exists {
+return fmt.Errorf("%s: %w", cmd[1], nubdb.ErrKeyNotFound)
+}
+}
+fmt.Fprintln(stdout, value)
+
+case "set":
+var value string
+if len(cmd) == 3 && cmd[2] != "-" {
+value = cmd[2]
+} else {
+data, err := io.ReadAll(stdin)
+if err != nil {
+return fmt.Errorf("reading value: %w", err)
+}
+value = strings.TrimSuffix(string(data), "\n")
+}
+// The protocol is line based, so a line break would end the command
+if strings.ContainsAny(value, "\r\n") {
+return errors.New("value contains a line break, which the protocol cannot carry")
+}
+if _, err := client.SetWith(cmd[1], value, opt); err != nil {
+return err
+}
+fmt.Fprintln(stdout, "OK")
+
+case "delete", "del":
+if err := client.Delete(cmd[1], opt); err != nil {
+return err
+}
+fmt.Fprintln(stdout, "OK")
+
+case "scan":
+var opts nubdb.ScanOptions
+if len(cmd) == 2 {
+opts.Match = cmd[1]
+}
+return client.ScanAll(opts, func(key string) error {
+_, err := fmt.Fprintln(stdout, key)
+return err
+}, opt)
+}
+
+return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+"bufio"
+"bytes"
+"net"
+"strconv"
+"strings"
+"sync"
+"testing"
+)
+
+// startFakeServer serves GET, SET, DELETE, EXISTS and SCAN from a map,
+// returning the -host and -port flags to reach it
+func startFakeServer(t *testing.T, data map[string]string) []string {
+t.Helper()
+
+ln, err := net.Listen("tcp", "127.0.0.1:0")
+if err != nil {
+t.Fatalf("listen: %v", err)
+}
+t.Cleanup(func() { ln.Close() })
+
+var mu sync.Mutex
+handle := func(line string) string {
+mu.Lock()
+defer mu.Unlock()
+
+verb, rest, _ := strings.Cut(line, " ")
+key, arg, _ := strings.Cut(rest, " ")
+switch verb {
+case "GET":
+if value, ok := data[key]; ok {
+return `"` + value + `"`
+}
+return "(nil)"
+case "SET":
+data[key] = strings.TrimSuffix(strings.TrimPrefix(arg, `"`), `"`)
+return "OK"
+case "DELETE":
+delete(data, key)
+return "OK"
+case "EXISTS":
+if _, ok := data[key]; ok {
+return "1"
+}
+return "0"
+case "SCAN":
+var keys []string
+for k := range data {
+keys = append(keys, k)
+}
+return "*" + strconv.Itoa(len(keys)+1) + "\n0\n" + strings.Join(keys, "\n")
+}
+return "ERROR: Unknown command"
+}
+
+go func() {
+for {
+conn, err := ln.Accept()
+if err != nil {
+return
+}
+go func() {
+defer conn.Close()
+reader := bufio.NewReader(conn)
+for {
+line, err := reader.ReadString('\n')
+if err != nil || line == "QUIT\n" {
+return
+}
+conn.Write([]byte(handle(strings.TrimSuffix(line, "\n")) + "\n"))
+}
+}()
+}
+}()
+
+port := ln.Addr().(*net.TCPAddr).Port
+return []string{"-host", "127.0.0.1", "-port", strconv.Itoa(port)}
+}
+
+func TestCLI(t *testing.T) {
+data := map[string]string{"greeting": "hello"}
+flags := startFakeServer(t, data)
+
+cli := func(stdin string, args ...string) (string, int) {
+var stdout, stderr bytes.Buffer
+code := run(append(flags, args...), strings.NewReader(stdin), &stdout, &stderr)
+return stdout.String() + stderr.String(), code
+}
+
+if out, code := cli("", "get", "greeting"); code != 0 || out != "hello\n" {
+t.Errorf("get = %q, %d", out, code)
+}
+if out, code := cli("", "get", "missing"); code != 3 {
+t.Errorf("get of a missing key = %q, %d; want exit 3", out, code)
+}
+
+if _, code := cli("", "set", "name", "nub"); code != 0 || data["name"] != "nub" {
+t.Errorf("set from argument: exit %d, stored %q", code, data["name"])
+}
+if _, code := cli("tabs\tand \"quotes\"\n", "set", "raw"); code != 0 || data["raw"] != "tabs\tand \"quotes\"" {
+t.Errorf("set from stdin: exit %d, stored %q", code, data["raw"])
+}
+if _, code := cli("two\nlines\n", "set", "raw", "-"); code != 1 {
+t.Errorf("set of a multi-line value: exit %d, want 1", code)
+}
+
+if _, code := cli("", "delete", "name"); code != 0 {
+t.Errorf("delete: exit %d", code)
+}
+if _, ok := data["name"]; ok {
+t.Error("delete did not remove the key")
+}
+
+out, code := cli("", "scan")
+if code != 0 || !strings.Contains(out, "greeting\n") || !strings.Contains(out, "raw\n") {
+t.Errorf("scan = %q, %d", out, code)
+}
+
+if _, code := cli("", "get"); code != 2 {
+t.Errorf("get without key: exit %d, want 2", code)
+}
+if _, code := cli("", "frobnicate", "x"); code != 2 {
+t.Errorf("unknown command: exit %d, want 2", code)
+}
+}
@@ -0,0 +1,84 @@
+package nubdb
+
+import (
+"compress/flate"
+"fmt"
+"io"
+"time"
+)
+
+// negotiateCompression asks the server to compress the connection when
+// Config.Compression is set, and on its OK switches the buffers over to
+// DEFLATE streams. A server error keeps the connection in plain text. The
+// caller must hold c.mu or be the only user of c.
+func (c *Client) negotiateCompression() error {
+if !c.config.Compression {
+return nil
+}
+
+if c.config.Timeout > 0 {
+c.conn.SetDeadline(time.Now().Add(c.config.Timeout))
+defer c.conn.SetDeadline(time.Time{})
+}
+
+if err := c.writeCommand("COMPRESS DEFLATE"); err != nil {
+return err
+}
+response, err := c.readReply()
+if err != nil {
+return err
+}
+if parseServerError(response) != nil {
+return nil
+}
+if response != "OK" {
+return fmt.Errorf("unexpected response to COMPRESS: %s", response)
+}
+// Anything already buffered was sent compressed and read as plain text
+if c.reader.Buffered() > 0 {
+return fmt.Errorf("%w: data after the COMPRESS reply", ErrProtocolMismatch)
+}
+
+deflater, err := flate.NewWriter(c.conn, flate.DefaultCompression)
+if err != nil {
+return err
+}
+c.inflater = flate.NewReader(c.conn)
+c.deflater = syncFlushWriter{deflater}
+c.reader.Reset(c.inflater)
+c.writer.Reset(c.deflater)
+return nil
+}
+
+// wire returns what the buffers read from and write to: conn, or the
+// DEFLATE streams over it on a compressed connection. The caller must hold
+// c.mu.
+func (c *Client) wire() (io.Reader, io.Writer) {
+if c.inflater != nil {
+return c.inflater, c.deflater
+}
+return c.conn, c.conn
+}
+
+// Compressed reports whether the current connection is compressed, as
+// negotiated under Config.Compression
+func (c *Client) Compressed() bool {
+c.internalLock()
+defer c.internalUnlock()
+return c.inflater != nil
+}
+
+// syncFlushWriter flushes the DEFLATE stream after every write, so each
+// batch flushed by the bufio.Writer above reaches the server whole rather
+// than waiting in the compressor for more input
+type syncFlushWriter struct {
+*flate.Writer
+}
+
+func (w syncFlushWriter) Write(p []byte) (int, error) {
+n, err := w.Writer.Write(p)
+if err != nil {
+return n, err
+}
+return n, w.Flush()
+}
@@ -0,0 +1,183 @@
+package nubdb
+
+import (
+"bufio"
+"compress/flate"
+"errors"
+"io"
+"net"
+"strings"
+"sync/atomic"
+"testing"
+)
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+io.Reader
+n *atomic.Int64
+}
+
+func (r countingReader) Read(p []byte) (int, error) {
+n, err := r.Reader.Read(p)
+r.n.Add(int64(n))
+return n, err
+}
+
+// startCompressingServer starts a fake server that agrees to COMPRESS
+// DEFLATE and serves handle over the compressed streams from then on. It
+// returns the number of raw bytes read off the wire.
+func startCompressingServer(t *testing.T, handle func(line string) string) (*Config, *atomic.Int64) {
+t.Helper()
+
+ln, err := net.Listen("tcp", "127.0.0.1:0")
+if err != nil {
+t.Fatalf("listen: %v", err)
+}
+t.Cleanup(func() { ln.Close() })
+
+var raw atomic.Int64
+go func() {
+for {
+conn, err := ln.Accept()
+if err != nil {
+return
+}
+go func() {
+defer conn.Close()
+var w io.Writer = conn
+reader := bufio.NewReader(countingReader{conn, &raw})
+for {
+line, err := reader.ReadString('\n')
+if err != nil {
+return
+}
+line = strings.TrimSuffix(line, "\n")
+switch line {
+case "QUIT":
+return
+case "COMPRESS DEFLATE":
+io.WriteString(w, "OK\n")
+deflater, _ := flate.NewWriter(conn, flate.BestSpeed)
+w = syncFlushWriter{deflater}
+reader = bufio.NewReader(flate.NewReader(reader))
+continue
+}
+io.WriteString(w, handle(line)+"\n")
+}
+}()
+}
+}()
+
+config := DefaultConfig()
+config.Host = "127.0.0.1"
+config.Port = ln.Addr().(*net.TCPAddr).Port
+return config, &raw
+}
+
+func TestCompression(t *testing.T) {
+config, raw := startCompressingServer(t, newMemStore().handle)
+config.Compression = true
+config.AdaptiveBuffers = true
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+if !client.Compressed() {
+t.Fatal("Compressed = false after the server agreed")
+}
+
+value := strings.Repeat("compressible ", 1000)
+start := raw.Load()
+for i := 0; i < 2; i++ {
+if err := client.Set("k", value, 0); err != nil {
+t.Fatalf("Set: %v", err)
+}
+if got, err := client.Get("k"); err != nil || got != value {
+t.Fatalf("Get = %d bytes, %v", len(got), err)
+}
+if n, err := client.Incr("n"); err != nil || n != int64(i+1) {
+t.Fatalf("Incr = %d, %v", n, err)
+}
+}
+if sent := raw.Load() - start; sent > int64(len(value))/4 {
+t.Errorf("sent %d bytes on the wire for two %d byte values", sent, len(value))
+}
+
+// A new connection is compressed again
+if err := client.Reset(); err != nil {
+t.Fatalf("Reset: %v", err)
+}
+if !client.Compressed() {
+t.Error("Compressed = false after Reset")
+}
+if got, err := client.Get("k"); err != nil || got != value {
+t.Errorf("Get after Reset = %d bytes, %v", len(got), err)
+}
+}
+
+func TestCompressionRejected(t *testing.T) {
+config := startFakeServer(t, newMemStore().handle)
+config.Compression = true
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+if client.Compressed() {
+t.Error("Compressed = true after the server rejected COMPRESS")
+}
+if err := client.Set("k", "v", 0); err != nil {
+t.Fatalf("Set: %v", err)
+}
+if got, err := client.Get("k"); err != nil || got != "v" {
+t.Errorf("Get = %q, %v", got, err)
+}
+}
+
+func TestHandshakeWithAllowedCommands(t *testing.T) {
+for _, tt := range []struct {
+name string
+set  func(*Config)
+}{
+{"NegotiateProtocol", func(c *Config) { c.NegotiateProtocol = true }},
+{"Compression", func(c *Config) { c.Compression = true }},
+{"VerifyProtocol", func(c *Config) { c.VerifyProtocol = true }},
+{"all", func(c *Config) { c.NegotiateProtocol, c.Compression, c.VerifyProtocol = true, true, true }},
+} {
+store := newMemStore()
+config, _ := startCompressingServer(t, func(line string) string {
+if line == "HELLO 2" {
+return "1"
+}
+return store.handle(line)
+})
+config.AllowedCommands = []string{"GET", "SET"}
+tt.set(config)
+
+client, err := Connect(config)
+if err != nil {
+t.Errorf("%s: connect with AllowedCommands: %v", tt.name, err)
+continue
+}
+defer client.Close()
+if err := client.Set("k", "v", 0); err != nil {
+t.Errorf("%s: Set: %v", tt.name, err)
+}
+if err := client.Reset(); err != nil {
+t.Errorf("%s: Reset: %v", tt.name, err)
+}
+if got, err := client.Get("k"); err != nil || got != "v" {
+t.Errorf("%s: Get after Reset = %q, %v", tt.name, got, err)
+}
+
+// The filters still apply to the same commands sent by the caller
+for _, args := range [][]interface{}{{"HELLO", 2}, {"COMPRESS", "DEFLATE"}, {"SIZE"}} {
+if _, err := client.DoArgs(args...); !errors.Is(err, ErrCommandNotAllowed) {
+t.Errorf("%s: DoArgs(%v) = %v, want ErrCommandNotAllowed", tt.name, args, err)
+}
+}
+}
+}
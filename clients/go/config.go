@@ -0,0 +1,50 @@
+package nubdb
+
+import (
+"fmt"
+"reflect"
+"strings"
+)
+
+// EffectiveConfig returns a copy of the configuration the client runs with,
+// with unset fields that have a default (ChunkSize, Terminator, SlowLatency)
+// resolved to the value in use. The copy's slices are its own, so changing
+// it does not affect the client.
+func (c *Client) EffectiveConfig() Config {
+config := c.config
+config.ChunkSize = c.chunkSize
+config.Terminator = c.terminator
+if config.SlowLatency == 0 {
+config.SlowLatency = DefaultSlowLatency
+}
+config.Endpoints = append([]string(nil), config.Endpoints...)
+config.AllowedCommands = append([]string(nil), config.AllowedCommands...)
+config.DisallowedCommands = append([]string(nil), config.DisallowedCommands...)
+return config
+}
+
+// String formats the settings that differ from their zero value, for logging
+// a client's startup state. Callbacks and DialFunc are shown only as set.
+// Config holds no credentials; a field added for one must be redacted here.
+func (config Config) String() string {
+var b strings.Builder
+v := reflect.ValueOf(config)
+for i := 0; i < v.NumField(); i++ {
+field, value := v.Type().Field(i), v.Field(i)
+if value.IsZero() {
+continue
+}
+if b.Len() > 0 {
+b.WriteByte(' ')
+}
+switch {
+case value.Kind() == reflect.Func:
+fmt.Fprintf(&b, "%s=set", field.Name)
+case value.Kind() == reflect.String:
+fmt.Fprintf(&b, "%s=%q", field.Name, value.Interface())
+default:
+fmt.Fprintf(&b, "%s=%v", field.Name, value.Interface())
+}
+}
+return "{" + b.String() + "}"
+}
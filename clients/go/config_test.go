@@ -0,0 +1,65 @@
+package nubdb
+
+import (
+"strings"
+"testing"
+"time"
+)
+
+func TestEffectiveConfig(t *testing.T) {
+config := startFakeServer(t, newMemStore().handle)
+config.ChunkSize = 0
+config.Terminator = ""
+
+defaults, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer defaults.Close()
+
+got := defaults.EffectiveConfig()
+if got.ChunkSize != DefaultChunkSize || got.Terminator != "\n" || got.SlowLatency != DefaultSlowLatency {
+t.Errorf("defaults resolved to ChunkSize %d, Terminator %q, SlowLatency %s",
+got.ChunkSize, got.Terminator, got.SlowLatency)
+}
+if got.Host != config.Host || got.Port != config.Port {
+t.Errorf("address %s:%d, want %s:%d", got.Host, got.Port, config.Host, config.Port)
+}
+
+overridden := *config
+overridden.ChunkSize = 1024
+overridden.Terminator = "\n"
+overridden.SlowLatency = time.Second
+overridden.DisallowedCommands = []string{"CLEAR"}
+client, err := Connect(&overridden)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+got = client.EffectiveConfig()
+if got.ChunkSize != 1024 || got.SlowLatency != time.Second {
+t.Errorf("overrides resolved to ChunkSize %d, SlowLatency %s", got.ChunkSize, got.SlowLatency)
+}
+got.DisallowedCommands[0] = "GET"
+if again := client.EffectiveConfig(); again.DisallowedCommands[0] != "CLEAR" {
+t.Errorf("changing the copy changed the client's config to %v", again.DisallowedCommands)
+}
+}
+
+func TestConfigString(t *testing.T) {
+config := DefaultConfig()
+config.OnConnect = func(string, error) {}
+
+s := config.String()
+for _, want := range []string{`Host="localhost"`, "Port=6379", "Timeout=5s", "OnConnect=set"} {
+if !strings.Contains(s, want) {
+t.Errorf("String() = %s, missing %s", s, want)
+}
+}
+for _, unset := range []string{"Endpoints", "DialFunc", "CacheSize"} {
+if strings.Contains(s, unset) {
+t.Errorf("String() = %s, shows unset %s", s, unset)
+}
+}
+}
@@ -0,0 +1,163 @@
+package nubdb
+
+import (
+"encoding/base64"
+"fmt"
+"strconv"
+"strings"
+)
+
+// DoArgs sends an arbitrary command built from args and returns the raw
+// response. A multi-line reply is returned whole, its "*<n>" header and
+// elements joined by newlines. The first argument is normally the command
+// verb. Arguments are encoded as follows:
+//
+//   - string: sent as is if it is a plain token, otherwise double-quoted
+//     with Go escaping (see strconv.Quote)
+//   - []byte: base64 (standard encoding), so arbitrary bytes are line safe
+//   - int, int8-64, uint, uint8-64: decimal
+//   - float32, float64: shortest decimal that round-trips ('g' format)
+//   - bool: "1" or "0"
+//
+// Any other type, including nil, is rejected before anything is sent. A
+// first argument of several words of letters, such as "CLIENT LIST", is a
+// multi-word verb and is sent unquoted with single spaces.
+func (c *Client) DoArgs(args ...interface{}) (string, error) {
+cmd, err := encodeArgs("DoArgs", args)
+if err != nil {
+return "", err
+}
+return c.sendCommand(cmd)
+}
+
+// encodeArgs builds a command line from DoArgs-style arguments, naming the
+// calling method in errors
+func encodeArgs(method string, args []interface{}) (string, error) {
+if len(args) == 0 {
+return "", fmt.Errorf("%s: no command", method)
+}
+
+encoded := make([]string, len(args))
+for i, arg := range args {
+if verb, ok := arg.(string); ok && i == 0 && isMultiWordVerb(verb) {
+encoded[i] = strings.Join(strings.Fields(verb), " ")
+continue
+}
+s, err := encodeArg(arg)
+if err != nil {
+return "", fmt.Errorf("%s: argument %d: %w", method, i, err)
+}
+encoded[i] = s
+}
+return strings.Join(encoded, " "), nil
+}
+
+// SendAll writes cmds, which must be complete command lines, in a single
+// flush and returns the response to the last one. Every response is read so
+// the connection stays in sync, and the first error reply among them is
+// returned instead, naming the command that caused it. A multi-line reply
+// is read whole and, if last, returned as DoArgs returns it. It suits short
+// sequences whose intermediate replies are only "OK", such as SELECT then
+// GET; use Pipeline to inspect each reply.
+func (c *Client) SendAll(cmds []string, opts ...CallOption) (string, error) {
+if len(cmds) == 0 {
+return "", fmt.Errorf("SendAll: no commands")
+}
+for i, cmd := range cmds {
+if cmd == "" || strings.ContainsAny(cmd, "\r\n") {
+return "", fmt.Errorf("SendAll: command %d is not a single line: %q", i, cmd)
+}
+}
+
+responses, err := c.sendCommands(cmds, opts...)
+if err != nil {
+return "", err
+}
+for i, response := range responses {
+if err := parseServerError(response); err != nil {
+return "", fmt.Errorf("SendAll: command %d (%s): %w", i, commandVerb(cmds[i]), err)
+}
+}
+return responses[len(responses)-1], nil
+}
+
+// isMultiWordVerb reports whether s is a verb and subcommand, such as
+// "CONFIG GET": two or more words made only of letters
+func isMultiWordVerb(s string) bool {
+words := strings.Fields(s)
+if len(words) < 2 {
+return false
+}
+for _, word := range words {
+if strings.ContainsFunc(word, func(r rune) bool {
+return (r < 'A' || r > 'Z') && (r < 'a' || r > 'z')
+}) {
+return false
+}
+}
+return true
+}
+
+// encodeArg converts one DoArgs argument to its wire form
+func encodeArg(arg interface{}) (string, error) {
+switch v := arg.(type) {
+case string:
+return quoteArg(v), nil
+case []byte:
+return base64.StdEncoding.EncodeToString(v), nil
+case int:
+return strconv.Itoa(v), nil
+case int8:
+return strconv.FormatInt(int64(v), 10), nil
+case int16:
+return strconv.FormatInt(int64(v), 10), nil
+case int32:
+return strconv.FormatInt(int64(v), 10), nil
+case int64:
+return strconv.FormatInt(v, 10), nil
+case uint:
+return strconv.FormatUint(uint64(v), 10), nil
+case uint8:
+return strconv.FormatUint(uint64(v), 10), nil
+case uint16:
+return strconv.FormatUint(uint64(v), 10), nil
+case uint32:
+return strconv.FormatUint(uint64(v), 10), nil
+case uint64:
+return strconv.FormatUint(v, 10), nil
+case float32:
+return strconv.FormatFloat(float64(v), 'g', -1, 32), nil
+case float64:
+return strconv.FormatFloat(v, 'g', -1, 64), nil
+case bool:
+if v {
+return "1", nil
+}
+return "0", nil
+}
+
+return "", fmt.Errorf("unsupported type %T", arg)
+}
+
+// quoteArg quotes s unless it is a non-empty token without spaces, quotes,
+// backslashes or control characters
+func quoteArg(s string) string {
+if s != "" && !strings.ContainsFunc(s, func(r rune) bool {
+return r <= ' ' || r == '"' || r == '\\' || r == 0x7f
+}) {
+return s
+}
+return strconv.Quote(s)
+}
+
+// unquoteArg reverses quoteArg for a word or reply line, returning plain
+// words unchanged
+func unquoteArg(s string) string {
+if strings.HasPrefix(s, `"`) {
+if unquoted, err := strconv.Unquote(s); err == nil {
+return unquoted
+}
+return strings.Trim(s, `"`)
+}
+return s
+}
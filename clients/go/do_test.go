@@ -0,0 +1,154 @@
+package nubdb
+
+import (
+"errors"
+"strings"
+"testing"
+)
+
+func TestDoArgs(t *testing.T) {
+var got string
+client := connectFake(t, func(line string) string {
+got = line
+return "OK"
+})
+
+tests := []struct {
+args []interface{}
+want string
+}{
+{[]interface{}{"SET", "key", "plain"}, `SET key plain`},
+{[]interface{}{"SET", "key", "two words"}, `SET key "two words"`},
+{[]interface{}{"SET", "key", `say "hi"`}, `SET key "say \"hi\""`},
+{[]interface{}{"SET", "key", "line\nbreak"}, `SET key "line\nbreak"`},
+{[]interface{}{"SET", "key", ""}, `SET key ""`},
+{[]interface{}{"INCRBY", "n", 42}, `INCRBY n 42`},
+{[]interface{}{"INCRBY", "n", int64(-7)}, `INCRBY n -7`},
+{[]interface{}{"EXPIRE", "k", uint16(30)}, `EXPIRE k 30`},
+{[]interface{}{"SET", "f", 1.5}, `SET f 1.5`},
+{[]interface{}{"SET", "f", float32(0.1)}, `SET f 0.1`},
+{[]interface{}{"SET", "b", true, false}, `SET b 1 0`},
+{[]interface{}{"SET", "bin", []byte{0, 0xff, '\n'}}, `SET bin AP8K`},
+{[]interface{}{"CLIENT LIST"}, `CLIENT LIST`},
+{[]interface{}{"memory  usage", "key"}, `memory usage key`},
+{[]interface{}{"CONFIG GET", "maxmemory"}, `CONFIG GET maxmemory`},
+{[]interface{}{"CONFIG SET", "save", "900 1"}, `CONFIG SET save "900 1"`},
+{[]interface{}{"SET", "CLIENT LIST", "v"}, `SET "CLIENT LIST" v`},
+{[]interface{}{"GET k2 x"}, `"GET k2 x"`},
+}
+
+for _, tt := range tests {
+if _, err := client.DoArgs(tt.args...); err != nil {
+t.Errorf("DoArgs(%v): %v", tt.args, err)
+continue
+}
+if got != tt.want {
+t.Errorf("DoArgs(%v) sent %q, want %q", tt.args, got, tt.want)
+}
+}
+}
+
+func TestDoArgsArrayReply(t *testing.T) {
+client := connectFake(t, func(line string) string {
+if line == "SMEMBERS s" {
+return multiLine("a", "b")
+}
+return `"v"`
+})
+
+reply, err := client.DoArgs("SMEMBERS", "s")
+if err != nil || reply != "*2\na\nb" {
+t.Fatalf("DoArgs(SMEMBERS) = %q, %v, want the whole array", reply, err)
+}
+if value, err := client.Get("k"); err != nil || value != "v" {
+t.Errorf("Get after DoArgs = %q, %v: connection out of sync", value, err)
+}
+}
+
+func TestDoArgsUnsupported(t *testing.T) {
+sent := false
+client := connectFake(t, func(string) string {
+sent = true
+return "OK"
+})
+
+for _, args := range [][]interface{}{
+{"SET", "k", nil},
+{"SET", "k", struct{}{}},
+{"SET", "k", []string{"a"}},
+{},
+} {
+_, err := client.DoArgs(args...)
+if err == nil {
+t.Errorf("DoArgs(%v) should fail", args)
+} else if len(args) > 0 && !strings.Contains(err.Error(), "argument 2") {
+t.Errorf("DoArgs(%v) error should name the argument: %v", args, err)
+}
+}
+if sent {
+t.Error("rejected commands must not reach the server")
+}
+}
+
+func TestSendAllArrayReplies(t *testing.T) {
+client := connectFake(t, func(line string) string {
+switch line {
+case "SMEMBERS s":
+return multiLine("a", "b")
+case "SMEMBERS t":
+return multiLine("c")
+}
+return `"v"`
+})
+
+last, err := client.SendAll([]string{"SMEMBERS s", "GET k", "SMEMBERS t"})
+if err != nil || last != "*1\nc" {
+t.Fatalf("SendAll = %q, %v, want the whole last array", last, err)
+}
+last, err = client.SendAll([]string{"SMEMBERS s", "GET k"})
+if err != nil || last != `"v"` {
+t.Fatalf("SendAll = %q, %v, want the GET reply", last, err)
+}
+if value, err := client.Get("k"); err != nil || value != "v" {
+t.Errorf("Get after SendAll = %q, %v: connection out of sync", value, err)
+}
+}
+
+func TestSendAll(t *testing.T) {
+var lines []string
+client := connectFake(t, func(line string) string {
+lines = append(lines, line)
+switch {
+case strings.HasPrefix(line, "BOGUS"):
+return "ERROR: unknown command"
+case strings.HasPrefix(line, "GET"):
+return "value"
+}
+return "OK"
+})
+
+last, err := client.SendAll([]string{"SET k value", "GET k"})
+if err != nil || last != "value" {
+t.Fatalf("SendAll = %q, %v", last, err)
+}
+
+// An intermediate error is surfaced and later replies are still read
+lines = nil
+_, err = client.SendAll([]string{"SET k v", "BOGUS k", "GET k"})
+var serverErr *ServerError
+if !errors.As(err, &serverErr) || !strings.Contains(err.Error(), "command 1 (BOGUS)") {
+t.Fatalf("SendAll with a failing command = %v, want a ServerError naming it", err)
+}
+if len(lines) != 3 {
+t.Errorf("server got %q, want all three commands", lines)
+}
+if value, err := client.Get("k"); err != nil || value != "value" {
+t.Errorf("Get after SendAll = %q, %v: connection out of sync", value, err)
+}
+
+for _, cmds := range [][]string{nil, {"GET k", ""}, {"SET k a\nGET k"}} {
+if _, err := client.SendAll(cmds); err == nil {
+t.Errorf("SendAll(%q) should fail", cmds)
+}
+}
+}
@@ -0,0 +1,248 @@
+package nubdb
+
+import (
+"bufio"
+"errors"
+"fmt"
+"io"
+"strconv"
+"strings"
+"time"
+)
+
+// ReplyType is the kind of a framed reply, given by its first byte
+type ReplyType byte
+
+// Framed reply types
+const (
+ReplyStatus  ReplyType = '+' // simple string, such as OK
+ReplyError   ReplyType = '-' // error message
+ReplyInteger ReplyType = ':' // signed 64-bit integer
+ReplyBulk    ReplyType = '$' // length-prefixed binary-safe string
+ReplyArray   ReplyType = '*' // array of replies, possibly nested
+ReplyNull    ReplyType = 0   // null bulk string or null array
+)
+
+// String returns the name of the reply type, as used in errors
+func (t ReplyType) String() string {
+switch t {
+case ReplyStatus:
+return "status"
+case ReplyError:
+return "error"
+case ReplyInteger:
+return "integer"
+case ReplyBulk:
+return "bulk"
+case ReplyArray:
+return "array"
+case ReplyNull:
+return "null"
+case replyConditional:
+return "status or null"
+}
+return fmt.Sprintf("ReplyType(%q)", byte(t))
+}
+
+// maxFramedLength caps bulk string and array lengths so a corrupt header
+// cannot make the reader allocate without bound
+const maxFramedLength = 512 * 1024 * 1024
+
+// maxFramedDepth caps how deeply arrays may nest, so a hostile reply cannot
+// recurse the reader into a stack overflow
+const maxFramedDepth = 512
+
+// Reply is a typed framed reply. Str holds the text of status, error and
+// bulk replies, Int the value of integer replies and Array the elements of
+// arrays.
+type Reply struct {
+Type  ReplyType
+Str   string
+Int   int64
+Array []Reply
+}
+
+// Err returns the error an error reply carries, as a *ServerError, or nil
+// for other replies
+func (r Reply) Err() error {
+if r.Type != ReplyError {
+return nil
+}
+return parseServerError("ERROR: " + r.Str)
+}
+
+// lines renders r in the line form the rest of the client parses: status
+// text as is, errors as "ERROR: msg", integers in decimal, a top-level bulk
+// string quoted like a GET reply, nulls as "(nil)" and arrays as a "*<n>"
+// header followed by their elements one per line. Bulk strings inside arrays
+// are left unquoted, and nested arrays are flattened into their parent, so
+// [cursor, [key...]] reads like a SCAN reply.
+func (r Reply) lines() []string {
+switch r.Type {
+case ReplyArray:
+elems := r.flatten(nil)
+return append([]string{fmt.Sprintf("*%d", len(elems))}, elems...)
+case ReplyBulk:
+return []string{`"` + r.Str + `"`}
+}
+return []string{r.line()}
+}
+
+// flatten appends the leaves of an array reply to lines
+func (r Reply) flatten(lines []string) []string {
+for _, elem := range r.Array {
+switch elem.Type {
+case ReplyArray:
+lines = elem.flatten(lines)
+case ReplyBulk:
+lines = append(lines, elem.Str)
+default:
+lines = append(lines, elem.line())
+}
+}
+return lines
+}
+
+// line renders a reply that is not an array or bulk string
+func (r Reply) line() string {
+switch r.Type {
+case ReplyError:
+return "ERROR: " + r.Str
+case ReplyInteger:
+return strconv.FormatInt(r.Int, 10)
+case ReplyNull:
+return "(nil)"
+}
+return r.Str
+}
+
+// readFramed reads one framed reply from r, returning it and the number of
+// bytes it took
+func readFramed(r *bufio.Reader) (Reply, int, error) {
+return readFramedAt(r, 0)
+}
+
+// readFramedAt is readFramed for a reply nested depth arrays deep
+func readFramedAt(r *bufio.Reader, depth int) (Reply, int, error) {
+header, err := r.ReadString('\n')
+n := len(header)
+if err != nil {
+return Reply{}, n, err
+}
+header = strings.TrimSuffix(strings.TrimSuffix(header, "\n"), "\r")
+if header == "" {
+return Reply{}, n, errors.New("empty reply header")
+}
+
+typ, rest := ReplyType(header[0]), header[1:]
+switch typ {
+case ReplyStatus, ReplyError:
+return Reply{Type: typ, Str: rest}, n, nil
+case ReplyInteger:
+i, err := strconv.ParseInt(rest, 10, 64)
+if err != nil {
+return Reply{}, n, fmt.Errorf("invalid integer reply %q", header)
+}
+return Reply{Type: typ, Int: i}, n, nil
+}
+
+length, err := strconv.Atoi(rest)
+if err != nil || length < -1 || length > maxFramedLength {
+return Reply{}, n, fmt.Errorf("invalid reply header %q", header)
+}
+if length == -1 && (typ == ReplyBulk || typ == ReplyArray) {
+return Reply{Type: ReplyNull}, n, nil
+}
+
+switch typ {
+case ReplyBulk:
+data := make([]byte, length+2)
+read, err := io.ReadFull(r, data)
+n += read
+if err != nil {
+return Reply{}, n, err
+}
+if data[length] != '\r' || data[length+1] != '\n' {
+return Reply{}, n, errors.New("bulk string not terminated by CRLF")
+}
+return Reply{Type: typ, Str: string(data[:length])}, n, nil
+case ReplyArray:
+if depth >= maxFramedDepth {
+return Reply{}, n, fmt.Errorf("reply nests arrays deeper than %d", maxFramedDepth)
+}
+elems := make([]Reply, 0, min(length, 1024))
+for i := 0; i < length; i++ {
+elem, read, err := readFramedAt(r, depth+1)
+n += read
+if err != nil {
+return Reply{}, n, err
+}
+elems = append(elems, elem)
+}
+return Reply{Type: typ, Array: elems}, n, nil
+}
+
+return Reply{}, n, fmt.Errorf("unknown reply type %q", header[0])
+}
+
+// readFramedLine reads the next line of a framed reply, reading and
+// rendering a new reply once the lines of the last one are used up. The
+// caller must hold c.mu.
+func (c *Client) readFramedLine() (string, error) {
+if len(c.framedLines) == 0 {
+reply, n, err := readFramed(c.reader)
+c.received += n
+if err != nil {
+c.markBroken(err)
+return "", fmt.Errorf("read error: %w", err)
+}
+c.framedLines = reply.lines()
+c.framedReply = reply
+}
+
+line := c.framedLines[0]
+c.framedLines = c.framedLines[1:]
+return line, nil
+}
+
+// DoReply sends an arbitrary command built from args, encoded as for DoArgs,
+// and returns its reply with its type intact. It requires framed replies,
+// from Config.FramedReplies or Config.NegotiateProtocol. Error replies are
+// returned as replies, not errors; see Reply.Err.
+func (c *Client) DoReply(args ...interface{}) (reply Reply, err error) {
+cmd, err := encodeArgs("DoReply", args)
+if err != nil {
+return Reply{}, err
+}
+
+c.lock()
+defer c.unlock()
+defer func() {
+var responses []string
+if err == nil {
+responses = []string{reply.line()}
+}
+c.recordErrors([]string{cmd}, responses, err)
+}()
+
+if err := c.resync(time.Time{}); err != nil {
+return Reply{}, err
+}
+if !c.framed {
+return Reply{}, errors.New("DoReply: requires framed replies")
+}
+start, mark := time.Now(), c.received
+if err := c.writeCommand(cmd); err != nil {
+return Reply{}, err
+}
+// A reply is read whole, so no rendered lines can be left over here
+reply, n, err := readFramed(c.reader)
+c.received += n
+if err != nil {
+c.markBroken(err)
+return Reply{}, fmt.Errorf("read error: %w", err)
+}
+c.outstanding--
+c.observeExchange(cmd, start, mark)
+return reply, nil
+}
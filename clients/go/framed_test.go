@@ -0,0 +1,184 @@
+package nubdb
+
+import (
+"bufio"
+"errors"
+"io"
+"reflect"
+"strings"
+"testing"
+)
+
+func TestReadFramed(t *testing.T) {
+tests := []struct {
+name  string
+input string
+want  Reply
+lines []string
+}{
+{"status", "+OK\r\n", Reply{Type: ReplyStatus, Str: "OK"}, []string{"OK"}},
+{"error", "-ERR no such key\r\n", Reply{Type: ReplyError, Str: "ERR no such key"}, []string{"ERROR: ERR no such key"}},
+{"integer", ":-42\r\n", Reply{Type: ReplyInteger, Int: -42}, []string{"-42"}},
+{"bulk", "$12\r\nhello\r\nworld\r\n", Reply{Type: ReplyBulk, Str: "hello\r\nworld"}, []string{"\"hello\r\nworld\""}},
+{"empty bulk", "$0\r\n\r\n", Reply{Type: ReplyBulk}, []string{`""`}},
+{"null bulk", "$-1\r\n", Reply{Type: ReplyNull}, []string{"(nil)"}},
+{"null array", "*-1\r\n", Reply{Type: ReplyNull}, []string{"(nil)"}},
+{"empty array", "*0\r\n", Reply{Type: ReplyArray, Array: []Reply{}}, []string{"*0"}},
+{
+"array",
+"*3\r\n$1\r\na\r\n:2\r\n$-1\r\n",
+Reply{Type: ReplyArray, Array: []Reply{
+{Type: ReplyBulk, Str: "a"},
+{Type: ReplyInteger, Int: 2},
+{Type: ReplyNull},
+}},
+[]string{"*3", "a", "2", "(nil)"},
+},
+{
+"nested array",
+"*2\r\n$2\r\n17\r\n*2\r\n$4\r\nkey1\r\n*1\r\n+key2\r\n",
+Reply{Type: ReplyArray, Array: []Reply{
+{Type: ReplyBulk, Str: "17"},
+{Type: ReplyArray, Array: []Reply{
+{Type: ReplyBulk, Str: "key1"},
+{Type: ReplyArray, Array: []Reply{{Type: ReplyStatus, Str: "key2"}}},
+}},
+}},
+[]string{"*3", "17", "key1", "key2"},
+},
+{"bare LF", "+OK\n", Reply{Type: ReplyStatus, Str: "OK"}, []string{"OK"}},
+}
+
+for _, tt := range tests {
+reply, n, err := readFramed(bufio.NewReader(strings.NewReader(tt.input)))
+if err != nil {
+t.Errorf("%s: %v", tt.name, err)
+continue
+}
+if n != len(tt.input) {
+t.Errorf("%s: read %d bytes, want %d", tt.name, n, len(tt.input))
+}
+if !reflect.DeepEqual(reply, tt.want) {
+t.Errorf("%s: got %+v, want %+v", tt.name, reply, tt.want)
+}
+if lines := reply.lines(); !reflect.DeepEqual(lines, tt.lines) {
+t.Errorf("%s: rendered as %q, want %q", tt.name, lines, tt.lines)
+}
+}
+}
+
+func TestReadFramedInvalid(t *testing.T) {
+for _, input := range []string{
+"\r\n",
+"?what\r\n",
+":twelve\r\n",
+"$-2\r\n",
+"$5\r\nhi\r\n",
+"$2\r\nhiXX",
+"*2\r\n+a\r\n",
+"$99999999999\r\n",
+} {
+if reply, _, err := readFramed(bufio.NewReader(strings.NewReader(input))); err == nil {
+t.Errorf("readFramed(%q) = %+v, want an error", input, reply)
+}
+}
+}
+
+func TestReadFramedDeepNesting(t *testing.T) {
+nested := func(depth int) string {
+return strings.Repeat("*1\r\n", depth) + ":1\r\n"
+}
+
+if _, _, err := readFramed(bufio.NewReader(strings.NewReader(nested(maxFramedDepth)))); err != nil {
+t.Errorf("readFramed at the nesting limit: %v", err)
+}
+// Deep enough to overflow the stack without the limit
+input := nested(8 << 20)
+if _, _, err := readFramed(bufio.NewReader(strings.NewReader(input))); err == nil {
+t.Error("readFramed of arrays nested 8M deep should fail")
+}
+}
+
+func TestFramedReplies(t *testing.T) {
+config := startFakeServerTerm(t, "\r\n", func(line string) string {
+switch line {
+case "SET k v", `SET k "v"`:
+return "+OK"
+case "GET k":
+return "$11\r\nline1\nline2"
+case "GET missing":
+return "$-1"
+case "INCR n":
+return ":7"
+case "SMEMBERS s":
+return "*2\r\n$1\r\na\r\n$3\r\nb c"
+case "SMEMBERS none":
+return "*0"
+case "SCAN 0":
+return "*2\r\n$1\r\n0\r\n*2\r\n$2\r\nk1\r\n$2\r\nk2"
+}
+return "-ERR unknown command"
+})
+config.FramedReplies = true
+config.StrictReplies = true
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+if err := client.Set("k", "v", 0); err != nil {
+t.Fatalf("Set: %v", err)
+}
+if value, err := client.Get("k"); err != nil || value != "line1\nline2" {
+t.Errorf("Get = %q, %v", value, err)
+}
+if _, found, err := client.lookup("missing", nil); err != nil || found {
+t.Errorf("Get of a null bulk string: found = %v, %v", found, err)
+}
+if n, err := client.Incr("n"); err != nil || n != 7 {
+t.Errorf("Incr = %d, %v", n, err)
+}
+if members, err := client.SMembers("s"); err != nil || !reflect.DeepEqual(members, []string{"a", "b c"}) {
+t.Errorf("SMembers = %q, %v", members, err)
+}
+if members, err := client.SMembers("none"); err != nil || len(members) != 0 {
+t.Errorf("SMembers of an empty set = %q, %v", members, err)
+}
+if keys, next, err := client.Scan(0, ScanOptions{}); err != nil || next != 0 || !reflect.DeepEqual(keys, []string{"k1", "k2"}) {
+t.Errorf("Scan = %q, %d, %v", keys, next, err)
+}
+var serverErr *ServerError
+if _, err := client.Get("bogus k"); !errors.As(err, &serverErr) || serverErr.Code != "ERR" {
+t.Errorf("Get with an error reply = %v, want a ServerError", err)
+}
+
+stream, err := client.GetStream("k")
+if err != nil {
+t.Fatalf("GetStream: %v", err)
+}
+if data, _ := io.ReadAll(stream); string(data) != "line1\nline2" {
+t.Errorf("GetStream read %q", data)
+}
+
+reply, err := client.DoReply("SCAN", 0)
+if err != nil || reply.Type != ReplyArray || len(reply.Array) != 2 || len(reply.Array[1].Array) != 2 {
+t.Errorf("DoReply(SCAN 0) = %+v, %v", reply, err)
+}
+reply, err = client.DoReply("BOGUS")
+if err != nil || reply.Err() == nil {
+t.Errorf("DoReply(BOGUS) = %+v, %v, want an error reply", reply, err)
+}
+
+// The connection is still in step after every reply type
+if n, err := client.Incr("n"); err != nil || n != 7 {
+t.Errorf("Incr at the end = %d, %v", n, err)
+}
+}
+
+func TestDoReplyNeedsFramedReplies(t *testing.T) {
+client := connectFake(t, func(string) string { return "OK" })
+if _, err := client.DoReply("PING"); err == nil {
+t.Error("DoReply without FramedReplies should fail")
+}
+}
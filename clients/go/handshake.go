@@ -0,0 +1,67 @@
+package nubdb
+
+import (
+"fmt"
+"strconv"
+"strings"
+"time"
+)
+
+// Protocol versions agreed by Config.NegotiateProtocol and reported by
+// Client.Protocol
+const (
+ProtocolLine   = 1 // one reply per line, NubDB's own protocol
+ProtocolFramed = 2 // typed framed replies, as with Config.FramedReplies
+)
+
+// negotiate settles the reply format of a new connection: the one chosen by
+// Config.FramedReplies, or, when Config.NegotiateProtocol is set, the one the
+// server agrees to in reply to "HELLO 2". The HELLO reply is read as a line.
+// A server that rejects HELLO keeps line mode; one that answers with a
+// version the client does not speak fails with ErrProtocolMismatch. The
+// caller must hold c.mu or be the only user of c.
+func (c *Client) negotiate() error {
+c.framed = c.config.FramedReplies
+if !c.config.NegotiateProtocol {
+return nil
+}
+
+if c.config.Timeout > 0 {
+c.conn.SetDeadline(time.Now().Add(c.config.Timeout))
+defer c.conn.SetDeadline(time.Time{})
+}
+
+c.framed = false
+if err := c.writeCommand(fmt.Sprintf("HELLO %d", ProtocolFramed)); err != nil {
+return err
+}
+response, err := c.readReply()
+if err != nil {
+return err
+}
+if parseServerError(response) != nil {
+return nil
+}
+
+switch version, _ := strconv.Atoi(strings.TrimLeft(response, "+:")); version {
+case ProtocolLine:
+case ProtocolFramed:
+c.framed = true
+default:
+return fmt.Errorf("%w: HELLO reply %q", ErrProtocolMismatch, response)
+}
+return nil
+}
+
+// Protocol returns the protocol version of the current connection:
+// ProtocolFramed if replies are read as framed replies, otherwise
+// ProtocolLine
+func (c *Client) Protocol() int {
+c.internalLock()
+defer c.internalUnlock()
+
+if c.framed {
+return ProtocolFramed
+}
+return ProtocolLine
+}
@@ -0,0 +1,91 @@
+package nubdb
+
+import (
+"errors"
+"sync/atomic"
+"testing"
+)
+
+func TestNegotiateFramed(t *testing.T) {
+var hellos atomic.Int64
+config := startFakeServerTerm(t, "\r\n", func(line string) string {
+switch line {
+case "HELLO 2":
+hellos.Add(1)
+return "2"
+case `SET k "v"`:
+return "+OK"
+case "GET k":
+return "$1\r\nv"
+}
+return "-ERR unknown command"
+})
+config.NegotiateProtocol = true
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+if p := client.Protocol(); p != ProtocolFramed {
+t.Fatalf("Protocol() = %d, want ProtocolFramed", p)
+}
+if err := client.Set("k", "v", 0); err != nil {
+t.Fatalf("Set: %v", err)
+}
+if value, err := client.Get("k"); err != nil || value != "v" {
+t.Errorf("Get = %q, %v", value, err)
+}
+if _, err := client.DoReply("GET", "k"); err != nil {
+t.Errorf("DoReply on a negotiated connection: %v", err)
+}
+
+// Every new connection negotiates again
+if err := client.Reset(); err != nil {
+t.Fatalf("Reset: %v", err)
+}
+if n := hellos.Load(); n != 2 || client.Protocol() != ProtocolFramed {
+t.Errorf("%d HELLOs, protocol %d after Reset", n, client.Protocol())
+}
+}
+
+func TestNegotiateLegacy(t *testing.T) {
+for _, tt := range []struct {
+name  string
+hello string
+}{
+{"rejects HELLO", "ERROR: Unknown command"},
+{"answers 1", "1"},
+} {
+store := newMemStore()
+config := startFakeServer(t, func(line string) string {
+if line == "HELLO 2" {
+return tt.hello
+}
+return store.handle(line)
+})
+config.NegotiateProtocol = true
+config.VerifyProtocol = true
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("%s: connect: %v", tt.name, err)
+}
+defer client.Close()
+
+if p := client.Protocol(); p != ProtocolLine {
+t.Errorf("%s: Protocol() = %d, want ProtocolLine", tt.name, p)
+}
+client.Set("k", "v", 0)
+if value, err := client.Get("k"); err != nil || value != "v" {
+t.Errorf("%s: Get = %q, %v", tt.name, value, err)
+}
+}
+}
+
+func TestNegotiateUnknownVersion(t *testing.T) {
+config := startFakeServer(t, func(string) string { return "7" })
+config.NegotiateProtocol = true
+if _, err := Connect(config); !errors.Is(err, ErrProtocolMismatch) {
+t.Errorf("Connect to a server answering version 7 = %v, want ErrProtocolMismatch", err)
+}
+}
@@ -0,0 +1,64 @@
+package nubdb
+
+import "fmt"
+
+// Hash commands. Fields and values are encoded like DoArgs strings: sent
+// as is when they are plain words and double-quoted with Go escapes
+// otherwise. HGET replies with one such word or "(nil)", and HGETALL with a
+// "*<2n>" header followed by alternating field and value lines, each
+// encoded the same way, so any field or value survives the line protocol.
+
+// HSet sets field of the hash at key to value, creating the hash if needed,
+// and reports whether the field is new
+func (c *Client) HSet(key, field, value string, opts ...CallOption) (bool, error) {
+response, err := c.sendCommand(memberCommand("HSET", key, []string{field, value}), opts...)
+if err != nil {
+return false, err
+}
+return parseBoolReply(response)
+}
+
+// HGet returns the value of field in the hash at key. found is false if
+// the field or the hash does not exist.
+func (c *Client) HGet(key, field string, opts ...CallOption) (value string, found bool, err error) {
+response, err := c.sendCommand(memberCommand("HGET", key, []string{field}), opts...)
+if err != nil {
+return "", false, err
+}
+if isNotFound(response) {
+return "", false, nil
+}
+return unquoteArg(response), true, nil
+}
+
+// HGetAll returns every field and value of the hash at key. A missing key
+// is an empty map.
+func (c *Client) HGetAll(key string, opts ...CallOption) (map[string]string, error) {
+lines, err := c.sendCommandList(fmt.Sprintf("HGETALL %s", key), opts...)
+if err != nil {
+return nil, err
+}
+if len(lines)%2 != 0 {
+return nil, fmt.Errorf("invalid response: HGETALL returned %d lines", len(lines))
+}
+
+hash := make(map[string]string, len(lines)/2)
+for i := 0; i < len(lines); i += 2 {
+hash[unquoteArg(lines[i])] = unquoteArg(lines[i+1])
+}
+return hash, nil
+}
+
+// HDel removes fields from the hash at key and returns how many existed
+func (c *Client) HDel(key string, fields []string, opts ...CallOption) (int64, error) {
+return c.memberCount("HDEL", key, fields, opts)
+}
+
+// HExists reports whether field exists in the hash at key
+func (c *Client) HExists(key, field string, opts ...CallOption) (bool, error) {
+response, err := c.sendCommand(memberCommand("HEXISTS", key, []string{field}), opts...)
+if err != nil {
+return false, err
+}
+return parseBoolReply(response)
+}
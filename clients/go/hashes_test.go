@@ -0,0 +1,124 @@
+package nubdb
+
+import (
+"sort"
+"strconv"
+"sync"
+"testing"
+)
+
+// hashStore implements the hash commands, encoding replies with quoteArg
+type hashStore struct {
+mu     sync.Mutex
+hashes map[string]map[string]string
+}
+
+func (s *hashStore) handle(line string) string {
+s.mu.Lock()
+defer s.mu.Unlock()
+
+args := splitArgs(line)
+hash := s.hashes[args[1]]
+switch args[0] {
+case "HSET":
+if hash == nil {
+hash = make(map[string]string)
+s.hashes[args[1]] = hash
+}
+_, existed := hash[args[2]]
+hash[args[2]] = args[3]
+if existed {
+return "0"
+}
+return "1"
+case "HGET":
+value, ok := hash[args[2]]
+if !ok {
+return "(nil)"
+}
+return quoteArg(value)
+case "HEXISTS":
+if _, ok := hash[args[2]]; ok {
+return "1"
+}
+return "0"
+case "HDEL":
+n := 0
+for _, field := range args[2:] {
+if _, ok := hash[field]; ok {
+delete(hash, field)
+n++
+}
+}
+return strconv.Itoa(n)
+case "HGETALL":
+var fields []string
+for field := range hash {
+fields = append(fields, field)
+}
+sort.Strings(fields)
+var lines []string
+for _, field := range fields {
+lines = append(lines, quoteArg(field), quoteArg(hash[field]))
+}
+return multiLine(lines...)
+}
+return "ERROR: Unknown command"
+}
+
+func TestHashes(t *testing.T) {
+client := connectFake(t, (&hashStore{hashes: make(map[string]map[string]string)}).handle)
+
+record := map[string]string{
+"name":       "Ada Lovelace",
+"quote":      `say "hi"`,
+"multi line": "first\nsecond",
+"empty":      "",
+}
+for field, value := range record {
+created, err := client.HSet("user:1", field, value)
+if err != nil || !created {
+t.Fatalf("HSet(%q) = %v, %v", field, created, err)
+}
+}
+if created, _ := client.HSet("user:1", "name", "Ada"); created {
+t.Error("HSet of an existing field reported it new")
+}
+record["name"] = "Ada"
+
+for field, want := range record {
+if got, found, err := client.HGet("user:1", field); err != nil || !found || got != want {
+t.Errorf("HGet(%q) = %q, %v, %v; want %q", field, got, found, err, want)
+}
+}
+if _, found, err := client.HGet("user:1", "missing"); err != nil || found {
+t.Errorf("HGet of a missing field: found = %v, %v", found, err)
+}
+
+all, err := client.HGetAll("user:1")
+if err != nil {
+t.Fatalf("HGetAll: %v", err)
+}
+if len(all) != len(record) {
+t.Errorf("HGetAll = %q, want %q", all, record)
+}
+for field, want := range record {
+if all[field] != want {
+t.Errorf("HGetAll[%q] = %q, want %q", field, all[field], want)
+}
+}
+
+if ok, _ := client.HExists("user:1", "quote"); !ok {
+t.Error("HExists of a field = false")
+}
+if n, _ := client.HDel("user:1", []string{"quote", "nope"}); n != 1 {
+t.Errorf("HDel = %d, want 1", n)
+}
+if ok, _ := client.HExists("user:1", "quote"); ok {
+t.Error("HExists of a deleted field = true")
+}
+
+if all, err := client.HGetAll("missing"); err != nil || len(all) != 0 {
+t.Errorf("HGetAll of a missing hash = %v, %v", all, err)
+}
+}
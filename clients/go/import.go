@@ -0,0 +1,106 @@
+package nubdb
+
+import (
+"bufio"
+"errors"
+"fmt"
+"io"
+"strconv"
+"strings"
+)
+
+// importBatchSize is the number of SETs ImportLines pipelines per round trip
+const importBatchSize = 500
+
+// ImportLines stores the entries read from r, one per line, as either
+// "key<TAB>value" or "key=value" (the tab form wins if a line has both).
+// Values containing tabs or other special characters can be written as a
+// double-quoted Go string literal, e.g. key="a\tb", which is unquoted before
+// storing. Values are sent inside double quotes on one command line, so a
+// value that contains, once unquoted, a CR, LF or double quote is malformed.
+// Blank lines are skipped.
+//
+// It returns the number of keys stored. On the first malformed line it stops,
+// after storing the entries before it, and reports the line number.
+func (c *Client) ImportLines(r io.Reader) (int, error) {
+reader := bufio.NewReader(r)
+p := c.Pipeline()
+count := 0
+
+flush := func() error {
+responses, err := p.Exec()
+var perr *PipelineError
+if errors.As(err, &perr) {
+for _, e := range perr.Errors() {
+if e == nil {
+count++
+}
+}
+return err
+}
+count += len(responses)
+return err
+}
+
+for lineno := 1; ; lineno++ {
+line, readErr := reader.ReadString('\n')
+if readErr != nil && readErr != io.EOF {
+return count, readErr
+}
+
+line = strings.TrimRight(line, "\r\n")
+if strings.TrimSpace(line) != "" {
+key, value, err := parseImportLine(line)
+if err != nil {
+if ferr := flush(); ferr != nil {
+return count, ferr
+}
+return count, fmt.Errorf("line %d: %w", lineno, err)
+}
+
+p.Set(key, value, 0)
+if p.Len() >= importBatchSize {
+if err := flush(); err != nil {
+return count, err
+}
+}
+}
+
+if readErr == io.EOF {
+break
+}
+}
+
+if err := flush(); err != nil {
+return count, err
+}
+
+return count, nil
+}
+
+// parseImportLine splits an ImportLines entry into key and value
+func parseImportLine(line string) (string, string, error) {
+key, value, ok := strings.Cut(line, "\t")
+if !ok {
+key, value, ok = strings.Cut(line, "=")
+}
+if !ok {
+return "", "", fmt.Errorf("malformed entry %q: want key<TAB>value or key=value", line)
+}
+if key == "" || strings.ContainsAny(key, " \t") {
+return "", "", fmt.Errorf("malformed entry %q: invalid key", line)
+}
+
+if strings.HasPrefix(value, `"`) {
+unquoted, err := strconv.Unquote(value)
+if err != nil {
+return "", "", fmt.Errorf("malformed entry %q: bad quoted value: %w", line, err)
+}
+value = unquoted
+}
+if strings.ContainsAny(value, "\r\n\"") {
+return "", "", fmt.Errorf("malformed entry %q: value contains CR, LF or a double quote", line)
+}
+
+return key, value, nil
+}
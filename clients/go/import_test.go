@@ -0,0 +1,74 @@
+package nubdb
+
+import (
+"strings"
+"testing"
+)
+
+func TestImportLines(t *testing.T) {
+store := newMemStore()
+client := connectFake(t, store.handle)
+
+input := "name\tAlice\n" +
+"city=Paris\n" +
+"\n" +
+"equation=a=b\n" +
+`quoted="tab\there"` + "\r\n" +
+"last\tno newline"
+
+count, err := client.ImportLines(strings.NewReader(input))
+if err != nil {
+t.Fatalf("ImportLines: %v", err)
+}
+if count != 5 {
+t.Fatalf("count = %d, want 5", count)
+}
+
+want := map[string]string{
+"name":     "Alice",
+"city":     "Paris",
+"equation": "a=b",
+"quoted":   "tab\there",
+"last":     "no newline",
+}
+for key, value := range want {
+if store.data[key] != value {
+t.Errorf("%s = %q, want %q", key, store.data[key], value)
+}
+}
+}
+
+func TestImportLinesMalformed(t *testing.T) {
+store := newMemStore()
+client := connectFake(t, store.handle)
+
+count, err := client.ImportLines(strings.NewReader("a=1\nb=2\nbroken line\nc=3\n"))
+if err == nil || !strings.Contains(err.Error(), "line 3") {
+t.Fatalf("error = %v, want line 3 reported", err)
+}
+if count != 2 || store.data["b"] != "2" {
+t.Fatalf("count = %d, store = %v", count, store.data)
+}
+if _, ok := store.data["c"]; ok {
+t.Fatal("entries after the malformed line should not be stored")
+}
+}
+
+func TestImportLinesUnsendableValues(t *testing.T) {
+for _, line := range []string{`a="x\ny"`, `a="x\r"`, `a="say \"hi\""`, `a=say "hi"`} {
+store := newMemStore()
+client := connectFake(t, store.handle)
+
+count, err := client.ImportLines(strings.NewReader("ok=1\n" + line + "\n"))
+if err == nil || !strings.Contains(err.Error(), "line 2") {
+t.Errorf("%s: error = %v, want line 2 reported", line, err)
+}
+if count != 1 || len(store.data) != 1 {
+t.Errorf("%s: count = %d, store = %v", line, count, store.data)
+}
+// The connection is still in sync
+if value, err := client.Get("ok"); err != nil || value != "1" {
+t.Errorf("%s: Get after import = %q, %v", line, value, err)
+}
+}
+}
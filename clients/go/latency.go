@@ -0,0 +1,124 @@
+package nubdb
+
+import (
+"math/bits"
+"sync"
+"time"
+)
+
+// CommandLatency summarizes the round-trip times of one command verb
+type CommandLatency struct {
+Count int64
+P50   time.Duration
+P95   time.Duration
+P99   time.Duration
+Max   time.Duration
+}
+
+// latencySubBuckets is the number of linear buckets per power of two,
+// which bounds the relative error of a percentile to 1/16
+const latencySubBuckets = 16
+
+// latencyHistogram is a log-linear histogram of durations in the style of
+// HDR histograms: exact below latencySubBuckets nanoseconds, then
+// latencySubBuckets equal buckets per power of two.
+type latencyHistogram struct {
+counts [latencySubBuckets * 61]int64
+total  int64
+max    time.Duration
+}
+
+func latencyBucket(d time.Duration) int {
+v := uint64(d)
+if v < latencySubBuckets {
+return int(v)
+}
+shift := bits.Len64(v) - 5
+return latencySubBuckets*(shift+1) + int(v>>shift) - latencySubBuckets
+}
+
+// latencyBucketMax returns the largest duration counted in bucket i
+func latencyBucketMax(i int) time.Duration {
+if i < latencySubBuckets {
+return time.Duration(i)
+}
+shift := i/latencySubBuckets - 1
+sub := uint64(i%latencySubBuckets + latencySubBuckets)
+return time.Duration((sub+1)<<shift - 1)
+}
+
+func (h *latencyHistogram) record(d time.Duration) {
+if d < 0 {
+d = 0
+}
+h.counts[latencyBucket(d)]++
+h.total++
+if d > h.max {
+h.max = d
+}
+}
+
+// percentile returns the upper bound of the bucket holding the p-th
+// fraction of recorded durations, capped at the largest one seen
+func (h *latencyHistogram) percentile(p float64) time.Duration {
+rank := int64(p*float64(h.total) + 0.5)
+if rank < 1 {
+rank = 1
+}
+var seen int64
+for i, n := range h.counts {
+seen += n
+if seen >= rank {
+return min(latencyBucketMax(i), h.max)
+}
+}
+return h.max
+}
+
+// latencyRecorder keeps one histogram per command verb
+type latencyRecorder struct {
+mu    sync.Mutex
+verbs map[string]*latencyHistogram
+}
+
+// record adds the round trip of cmds, which started at start. Round trips
+// of several commands are recorded as "PIPELINE".
+func (r *latencyRecorder) record(cmds []string, start time.Time) {
+elapsed := time.Since(start)
+verb := "PIPELINE"
+if len(cmds) == 1 {
+verb = commandVerb(cmds[0])
+}
+
+r.mu.Lock()
+defer r.mu.Unlock()
+h, ok := r.verbs[verb]
+if !ok {
+h = &latencyHistogram{}
+r.verbs[verb] = h
+}
+h.record(elapsed)
+}
+
+// LatencyStats returns the latency percentiles of every command verb sent
+// since the client connected, or nil unless Config.TrackLatency is set.
+// Commands sent together in one round trip are reported as "PIPELINE".
+func (c *Client) LatencyStats() map[string]CommandLatency {
+if c.latency == nil {
+return nil
+}
+
+c.latency.mu.Lock()
+defer c.latency.mu.Unlock()
+stats := make(map[string]CommandLatency, len(c.latency.verbs))
+for verb, h := range c.latency.verbs {
+stats[verb] = CommandLatency{
+Count: h.total,
+P50:   h.percentile(0.50),
+P95:   h.percentile(0.95),
+P99:   h.percentile(0.99),
+Max:   h.max,
+}
+}
+return stats
+}
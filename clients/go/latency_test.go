@@ -0,0 +1,82 @@
+package nubdb
+
+import (
+"strings"
+"testing"
+"time"
+)
+
+func TestLatencyBuckets(t *testing.T) {
+for _, d := range []time.Duration{0, 1, 15, 16, 17, 31, 32, 1000, time.Millisecond, time.Hour, 1<<63 - 1} {
+i := latencyBucket(d)
+if max := latencyBucketMax(i); d > max {
+t.Errorf("%d: bucket %d ends at %d", d, i, max)
+}
+if i > 0 && d <= latencyBucketMax(i-1) {
+t.Errorf("%d: also fits bucket %d", d, i-1)
+}
+if max := latencyBucketMax(i); d >= 16 && float64(max-d) > float64(d)/16 {
+t.Errorf("%d: bucket %d too wide, ends at %d", d, i, max)
+}
+}
+}
+
+func TestLatencyStats(t *testing.T) {
+// GETs of key "<n>" take n milliseconds; SETs are immediate
+store := newMemStore()
+config := startFakeServer(t, func(line string) string {
+if key, ok := strings.CutPrefix(line, "GET "); ok {
+d, _ := time.ParseDuration(key + "ms")
+time.Sleep(d)
+}
+return store.handle(line)
+})
+config.TrackLatency = true
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+// 90 fast GETs, 8 of 20ms and 2 of 50ms
+for i := 0; i < 90; i++ {
+client.Get("1")
+}
+for i := 0; i < 8; i++ {
+client.Get("20")
+}
+client.Get("50")
+client.Get("50")
+client.Set("k", "v", 0)
+
+stats := client.LatencyStats()
+get := stats["GET"]
+if get.Count != 100 {
+t.Fatalf("GET count = %d, want 100", get.Count)
+}
+within := func(name string, got, low, high time.Duration) {
+if got < low || got > high {
+t.Errorf("%s = %v, want between %v and %v", name, got, low, high)
+}
+}
+within("p50", get.P50, time.Millisecond, 15*time.Millisecond)
+within("p95", get.P95, 20*time.Millisecond, 45*time.Millisecond)
+within("p99", get.P99, 50*time.Millisecond, 100*time.Millisecond)
+if get.Max < 50*time.Millisecond || get.P99 > get.Max {
+t.Errorf("max = %v, p99 = %v", get.Max, get.P99)
+}
+if stats["SET"].Count != 1 {
+t.Errorf("SET count = %d, want 1", stats["SET"].Count)
+}
+
+config.TrackLatency = false
+untracked, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer untracked.Close()
+untracked.Set("k", "v", 0)
+if stats := untracked.LatencyStats(); stats != nil {
+t.Errorf("LatencyStats without TrackLatency = %v, want nil", stats)
+}
+}
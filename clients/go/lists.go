@@ -0,0 +1,134 @@
+package nubdb
+
+import (
+"fmt"
+"strconv"
+"strings"
+"time"
+)
+
+// List commands. Values are sent like set members (see SAdd) and come back
+// quoted the same way: LPOP and RPOP reply with the value or "(nil)", and
+// LRANGE with a "*<n>" header followed by one element per line.
+
+// LPush inserts values at the head of the list at key, one after another,
+// so the last value ends up first. It returns the new length of the list.
+func (c *Client) LPush(key string, values []string, opts ...CallOption) (int64, error) {
+return c.pushList("LPUSH", key, values, opts)
+}
+
+// RPush appends values to the tail of the list at key and returns the new
+// length of the list
+func (c *Client) RPush(key string, values []string, opts ...CallOption) (int64, error) {
+return c.pushList("RPUSH", key, values, opts)
+}
+
+func (c *Client) pushList(verb, key string, values []string, opts []CallOption) (int64, error) {
+if len(values) == 0 {
+return c.LLen(key, opts...)
+}
+
+response, err := c.sendCommand(memberCommand(verb, key, values), opts...)
+if err != nil {
+return 0, err
+}
+return parseIntReply(response)
+}
+
+// LPop removes and returns the first element of the list at key. found is
+// false if the list is empty or missing.
+func (c *Client) LPop(key string, opts ...CallOption) (value string, found bool, err error) {
+return c.popList("LPOP", key, opts)
+}
+
+// RPop removes and returns the last element of the list at key. found is
+// false if the list is empty or missing.
+func (c *Client) RPop(key string, opts ...CallOption) (value string, found bool, err error) {
+return c.popList("RPOP", key, opts)
+}
+
+func (c *Client) popList(verb, key string, opts []CallOption) (string, bool, error) {
+response, err := c.sendCommand(fmt.Sprintf("%s %s", verb, key), opts...)
+if err != nil {
+return "", false, err
+}
+
+value, found := parseListReply(response)
+return value, found, nil
+}
+
+// parseListReply decodes a popped element, reporting false for an empty or
+// missing list
+func parseListReply(response string) (string, bool) {
+if isNotFound(response) {
+return "", false
+}
+return unquoteArg(response), true
+}
+
+// LRange returns the elements of the list at key between the indexes start
+// and stop, both inclusive. Negative indexes count from the end, so -1 is
+// the last element; out-of-range indexes are clamped by the server. A
+// missing key is an empty list.
+func (c *Client) LRange(key string, start, stop int64, opts ...CallOption) ([]string, error) {
+values, err := c.sendCommandList(fmt.Sprintf("LRANGE %s %d %d", key, start, stop), opts...)
+if err != nil {
+return nil, err
+}
+for i, value := range values {
+values[i] = unquoteArg(value)
+}
+return values, nil
+}
+
+// LLen returns the length of the list at key, 0 if it is missing
+func (c *Client) LLen(key string, opts ...CallOption) (int64, error) {
+response, err := c.sendCommand(fmt.Sprintf("LLEN %s", key), opts...)
+if err != nil {
+return 0, err
+}
+return parseIntReply(response)
+}
+
+// BLPop removes and returns the first element of the first non-empty list
+// among keys, waiting up to timeout for one to receive an element. A zero
+// timeout waits indefinitely. ok is false if the timeout passed first.
+//
+// The client's connection is occupied while BLPop waits, and other commands
+// on the Client queue behind it, so use a Client of its own for a blocking
+// consumer rather than one shared with other work. MuxClient offers no
+// blocking commands for the same reason.
+func (c *Client) BLPop(timeout time.Duration, keys ...string) (key, value string, ok bool, err error) {
+return c.blockingPop("BLPOP", timeout, keys)
+}
+
+// BRPop is BLPop taking the last element of the list
+func (c *Client) BRPop(timeout time.Duration, keys ...string) (key, value string, ok bool, err error) {
+return c.blockingPop("BRPOP", timeout, keys)
+}
+
+// blockingPop sends "VERB key... seconds"; the reply is a "*2" list of the
+// key, quoted like a value, and the value as LPOP would return it, or
+// "(nil)" on timeout
+func (c *Client) blockingPop(verb string, timeout time.Duration, keys []string) (string, string, bool, error) {
+if len(keys) == 0 {
+return "", "", false, fmt.Errorf("%s: no keys", verb)
+}
+if timeout < 0 {
+return "", "", false, fmt.Errorf("%s: negative timeout %s", verb, timeout)
+}
+
+cmd := fmt.Sprintf("%s %s %s", verb, strings.Join(keys, " "), strconv.FormatFloat(timeout.Seconds(), 'f', -1, 64))
+lines, err := c.sendCommandList(cmd)
+if err != nil {
+return "", "", false, err
+}
+switch len(lines) {
+case 0:
+return "", "", false, nil
+case 2:
+value, _ := parseListReply(lines[1])
+return unquoteArg(lines[0]), value, true, nil
+}
+return "", "", false, fmt.Errorf("invalid response: %s returned %d lines", verb, len(lines))
+}
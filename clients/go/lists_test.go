@@ -0,0 +1,218 @@
+package nubdb
+
+import (
+"reflect"
+"strconv"
+"strings"
+"sync"
+"testing"
+"time"
+)
+
+// listStore implements the list commands, encoding replies with quoteArg
+type listStore struct {
+mu    sync.Mutex
+lists map[string][]string
+}
+
+func (s *listStore) handle(line string) string {
+s.mu.Lock()
+defer s.mu.Unlock()
+
+args := splitArgs(line)
+key := args[1]
+list := s.lists[key]
+switch args[0] {
+case "LPUSH":
+for _, v := range args[2:] {
+list = append([]string{v}, list...)
+}
+s.lists[key] = list
+return strconv.Itoa(len(list))
+case "RPUSH":
+s.lists[key] = append(list, args[2:]...)
+return strconv.Itoa(len(s.lists[key]))
+case "LPOP", "RPOP":
+if len(list) == 0 {
+return "(nil)"
+}
+var v string
+if args[0] == "LPOP" {
+v, s.lists[key] = list[0], list[1:]
+} else {
+v, s.lists[key] = list[len(list)-1], list[:len(list)-1]
+}
+return strconv.Quote(v)
+case "LLEN":
+return strconv.Itoa(len(list))
+case "LRANGE":
+start, _ := strconv.Atoi(args[2])
+stop, _ := strconv.Atoi(args[3])
+if start < 0 {
+start += len(list)
+}
+if stop < 0 {
+stop += len(list)
+}
+start = max(start, 0)
+stop = min(stop, len(list)-1)
+if start > stop {
+return multiLine()
+}
+var values []string
+for _, v := range list[start : stop+1] {
+values = append(values, quoteArg(v))
+}
+return multiLine(values...)
+}
+return "ERROR: Unknown command"
+}
+
+func TestListPushPop(t *testing.T) {
+client := connectFake(t, (&listStore{lists: make(map[string][]string)}).handle)
+
+if n, err := client.RPush("q", []string{"b", "c"}); err != nil || n != 2 {
+t.Fatalf("RPush = %d, %v", n, err)
+}
+// LPush inserts one at a time: "a" then "z" at the head
+if n, err := client.LPush("q", []string{"a", "z y"}); err != nil || n != 4 {
+t.Fatalf("LPush = %d, %v", n, err)
+}
+if n, _ := client.LLen("q"); n != 4 {
+t.Errorf("LLen = %d, want 4", n)
+}
+
+all, err := client.LRange("q", 0, -1)
+if err != nil || strings.Join(all, ",") != "z y,a,b,c" {
+t.Errorf("LRange(0, -1) = %q, %v", all, err)
+}
+
+if v, found, err := client.LPop("q"); err != nil || !found || v != "z y" {
+t.Errorf("LPop = %q, %v, %v", v, found, err)
+}
+if v, found, _ := client.RPop("q"); !found || v != "c" {
+t.Errorf("RPop = %q, %v", v, found)
+}
+for _, want := range []string{"a", "b"} {
+if v, _, _ := client.LPop("q"); v != want {
+t.Errorf("LPop = %q, want %q", v, want)
+}
+}
+
+if _, found, err := client.LPop("q"); err != nil || found {
+t.Errorf("LPop of an empty list: found = %v, %v", found, err)
+}
+if _, found, _ := client.RPop("missing"); found {
+t.Error("RPop of a missing list found a value")
+}
+}
+
+func TestListRange(t *testing.T) {
+client := connectFake(t, (&listStore{lists: make(map[string][]string)}).handle)
+client.RPush("l", []string{"0", "1", "2", "3", "4"})
+
+tests := []struct {
+start, stop int64
+want        string
+}{
+{0, 1, "0,1"},
+{1, 3, "1,2,3"},
+{-2, -1, "3,4"},
+{3, 100, "3,4"},
+{4, 4, "4"},
+{3, 1, ""},
+}
+for _, tt := range tests {
+got, err := client.LRange("l", tt.start, tt.stop)
+if err != nil || strings.Join(got, ",") != tt.want {
+t.Errorf("LRange(%d, %d) = %q, %v; want %s", tt.start, tt.stop, got, err, tt.want)
+}
+}
+
+if got, err := client.LRange("missing", 0, -1); err != nil || got == nil || len(got) != 0 {
+t.Errorf("LRange of a missing list = %#v, %v; want empty", got, err)
+}
+if n, _ := client.LLen("missing"); n != 0 {
+t.Errorf("LLen of a missing list = %d", n)
+}
+}
+
+func TestListEscapedValues(t *testing.T) {
+client := connectFake(t, (&listStore{lists: make(map[string][]string)}).handle)
+values := []string{`a "b"`, `back\slash`, "tab\there", ""}
+client.RPush("l", values)
+
+if got, err := client.LRange("l", 0, -1); err != nil || !reflect.DeepEqual(got, values) {
+t.Errorf("LRange = %q, %v; want %q", got, err, values)
+}
+if v, found, err := client.LPop("l"); err != nil || !found || v != values[0] {
+t.Errorf("LPop = %q, %v, %v; want %q", v, found, err, values[0])
+}
+if v, found, err := client.RPop("l"); err != nil || !found || v != "" {
+t.Errorf("RPop of an empty string = %q, %v, %v", v, found, err)
+}
+if v, _, _ := client.RPop("l"); v != values[2] {
+t.Errorf("RPop = %q, want %q", v, values[2])
+}
+}
+
+func TestBLPop(t *testing.T) {
+// One queue shared by every connection; BLPOP waits on it
+queue := make(chan string, 10)
+config := startFakeServer(t, func(line string) string {
+args := splitArgs(line)
+switch args[0] {
+case "RPUSH":
+for _, v := range args[2:] {
+queue <- v
+}
+return strconv.Itoa(len(queue))
+case "BLPOP":
+if args[1] != "jobs" || args[2] != "other" {
+return "ERROR: unexpected keys"
+}
+seconds, _ := strconv.ParseFloat(args[3], 64)
+select {
+case v := <-queue:
+return multiLine("jobs", strconv.Quote(v))
+case <-time.After(time.Duration(seconds * float64(time.Second))):
+return "(nil)"
+}
+}
+return "ERROR: Unknown command"
+})
+
+consumer, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer consumer.Close()
+producer, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer producer.Close()
+
+// Nothing queued: times out
+start := time.Now()
+if _, _, ok, err := consumer.BLPop(50*time.Millisecond, "jobs", "other"); err != nil || ok {
+t.Fatalf("BLPop on an empty queue: ok = %v, %v", ok, err)
+}
+if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+t.Errorf("BLPop returned after %v, before its timeout", elapsed)
+}
+
+go func() {
+time.Sleep(50 * time.Millisecond)
+producer.RPush("jobs", []string{`job "1"`})
+}()
+
+start = time.Now()
+key, value, ok, err := consumer.BLPop(5*time.Second, "jobs", "other")
+if err != nil || !ok || key != "jobs" || value != `job "1"` {
+t.Fatalf("BLPop = %q, %q, %v, %v", key, value, ok, err)
+}
+if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+t.Errorf("BLPop returned after %v, before the element was pushed", elapsed)
+}
+}
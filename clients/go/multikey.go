@@ -0,0 +1,284 @@
+package nubdb
+
+import (
+"errors"
+"fmt"
+"strings"
+"time"
+)
+
+// MGet fetches keys in pipelined GETs and returns one Result per key, in
+// the order listed, duplicates included. With WithDedup each distinct key is
+// fetched once and its Result repeated at every position it was listed.
+// A missing key has Found false, which tells it apart from an empty value.
+// A failed round trip is returned as the error; a key the server refused
+// carries the refusal in its Result.
+func (c *Client) MGet(keys []string, opts ...CallOption) ([]Result, error) {
+fetch, index := keys, []int(nil)
+if newCallOptions(opts).dedup {
+fetch, index = dedupKeys(keys)
+}
+
+fetched := make([]Result, 0, len(fetch))
+batch := c.config.PipelineBatchSize
+if batch <= 0 {
+batch = len(fetch)
+}
+for start := 0; start < len(fetch); start += batch {
+results, err := c.getMany(fetch[start:min(start+batch, len(fetch))], opts)
+if err != nil {
+return nil, err
+}
+fetched = append(fetched, results...)
+}
+
+if index == nil {
+return fetched, nil
+}
+results := make([]Result, len(keys))
+for i, j := range index {
+results[i] = fetched[j]
+}
+return results, nil
+}
+
+// DeleteMany removes keys in one pipelined round trip and returns how many
+// of them existed. With WithDedup each distinct key is sent once; without
+// it a repeated key is only counted the first time, as later DELETEs find
+// it gone.
+func (c *Client) DeleteMany(keys []string, opts ...CallOption) (int64, error) {
+if newCallOptions(opts).dedup {
+keys, _ = dedupKeys(keys)
+}
+if len(keys) == 0 {
+return 0, nil
+}
+
+cmds := make([]string, len(keys))
+for i, key := range keys {
+cmds[i] = fmt.Sprintf("DELETE %s", key)
+}
+responses, err := c.sendCommands(cmds, opts...)
+if err != nil {
+return 0, err
+}
+
+var deleted int64
+for i, response := range responses {
+if err := parseServerError(response); err != nil {
+return deleted, fmt.Errorf("DELETE %s: %w", keys[i], err)
+}
+switch {
+case response == "OK":
+deleted++
+case !isNotFound(response):
+return deleted, fmt.Errorf("unexpected response: %s", response)
+}
+}
+return deleted, nil
+}
+
+// TouchMany sets the TTL of each of keys that exists to ttl, rounded up to
+// whole seconds and spread by Config.TTLJitter, and returns how many
+// existed and were refreshed; missing keys are left missing. The EXPIREs
+// are pipelined in batches of Config.PipelineBatchSize, which suits
+// heartbeats for a fleet of sessions. A key listed more than once is
+// refreshed and counted each time, unless WithDedup is given. If a batch
+// fails, the keys of earlier batches stay refreshed and the result counts
+// them.
+func (c *Client) TouchMany(keys []string, ttl time.Duration, opts ...CallOption) (int64, error) {
+if ttl <= 0 {
+return 0, fmt.Errorf("TouchMany: invalid TTL %s", ttl)
+}
+if newCallOptions(opts).dedup {
+keys, _ = dedupKeys(keys)
+}
+
+var refreshed int64
+seconds := int((ttl + time.Second - 1) / time.Second)
+batch := c.config.PipelineBatchSize
+if batch <= 0 {
+batch = len(keys)
+}
+for start := 0; start < len(keys); start += batch {
+page := keys[start:min(start+batch, len(keys))]
+cmds := make([]string, len(page))
+for i, key := range page {
+cmds[i] = fmt.Sprintf("EXPIRE %s %d", key, c.jitterTTL(seconds))
+}
+responses, err := c.sendCommands(cmds, opts...)
+if err != nil {
+return refreshed, err
+}
+
+for i, response := range responses {
+if err := parseServerError(response); err != nil {
+return refreshed, fmt.Errorf("EXPIRE %s: %w", page[i], err)
+}
+ok, err := parseBoolReply(response)
+if err != nil {
+return refreshed, err
+}
+if ok {
+refreshed++
+}
+}
+}
+return refreshed, nil
+}
+
+// Unlink removes keys like DeleteMany, but with UNLINK, which frees their
+// memory in the background so deleting a large value does not stall the
+// server. It returns how many of the keys existed. A server without UNLINK
+// gets DELETEs instead, and is remembered for the life of the client so
+// later calls skip straight to them. With WithDedup each distinct key is
+// sent once.
+func (c *Client) Unlink(keys []string, opts ...CallOption) (int64, error) {
+if newCallOptions(opts).dedup {
+keys, _ = dedupKeys(keys)
+}
+if len(keys) == 0 {
+return 0, nil
+}
+if c.noUnlink.Load() {
+return c.DeleteMany(keys, opts...)
+}
+
+response, err := c.sendCommand("UNLINK "+strings.Join(keys, " "), opts...)
+if isUnknownCommand(err) {
+c.noUnlink.Store(true)
+return c.DeleteMany(keys, opts...)
+}
+if err != nil {
+return 0, err
+}
+
+count, err := parseIntReply(response)
+if err != nil || count < 0 {
+return 0, fmt.Errorf("invalid response: %s", response)
+}
+return count, nil
+}
+
+// DeleteMode tells how DeletePattern removed keys
+type DeleteMode int
+
+// Delete modes
+const (
+DeleteServerSide DeleteMode = iota + 1 // one DELPATTERN, atomic on the server
+DeleteScan                             // SCAN pages deleted by the client
+)
+
+func (m DeleteMode) String() string {
+switch m {
+case DeleteServerSide:
+return "server-side"
+case DeleteScan:
+return "scan"
+}
+return fmt.Sprintf("DeleteMode(%d)", int(m))
+}
+
+// PatternDeleteResult is the outcome of DeletePattern
+type PatternDeleteResult struct {
+Deleted int64
+Mode    DeleteMode
+}
+
+// DeletePattern removes every key matching the glob pattern and returns how
+// many it removed and how. The server does it in one atomic DELPATTERN
+// where it has one: every key matching at that instant is removed and no
+// other. Otherwise the client walks the keyspace with SCAN and deletes each
+// page with DeleteMany, which is not atomic: keys added during the walk
+// may be missed and keys written meanwhile may be removed, as with
+// ScanAll. A server without DELPATTERN is remembered for the life of the
+// client. If a page fails, the keys of earlier pages stay deleted and the
+// result counts them.
+func (c *Client) DeletePattern(pattern string, callOpts ...CallOption) (PatternDeleteResult, error) {
+if !c.noDelPattern.Load() {
+response, err := c.sendCommand("DELPATTERN "+pattern, callOpts...)
+switch {
+case isUnknownCommand(err):
+c.noDelPattern.Store(true)
+case err != nil:
+return PatternDeleteResult{}, err
+default:
+count, err := parseIntReply(response)
+if err != nil || count < 0 {
+return PatternDeleteResult{}, fmt.Errorf("invalid response: %s", response)
+}
+return PatternDeleteResult{Deleted: count, Mode: DeleteServerSide}, nil
+}
+}
+
+result := PatternDeleteResult{Mode: DeleteScan}
+var cursor uint64
+for {
+keys, next, err := c.Scan(cursor, ScanOptions{Match: pattern}, callOpts...)
+if err != nil {
+return result, err
+}
+n, err := c.DeleteMany(keys, callOpts...)
+result.Deleted += n
+if err != nil {
+return result, err
+}
+
+if next == 0 {
+return result, nil
+}
+cursor = next
+}
+}
+
+// DeletePrefix is DeletePattern for the keys starting with prefix
+func (c *Client) DeletePrefix(prefix string, callOpts ...CallOption) (PatternDeleteResult, error) {
+return c.DeletePattern(globEscape(prefix)+"*", callOpts...)
+}
+
+// isUnknownCommand reports whether err is the server rejecting a command
+// it does not implement
+func isUnknownCommand(err error) bool {
+var serverErr *ServerError
+return errors.As(err, &serverErr) && strings.HasPrefix(strings.ToLower(serverErr.Message), "unknown command")
+}
+
+// ExistsCount returns how many of keys exist using a single multi-key
+// EXISTS. A key listed more than once is counted each time, unless
+// WithDedup is given.
+func (c *Client) ExistsCount(keys []string, opts ...CallOption) (int64, error) {
+if newCallOptions(opts).dedup {
+keys, _ = dedupKeys(keys)
+}
+if len(keys) == 0 {
+return 0, nil
+}
+
+response, err := c.sendCommand("EXISTS "+strings.Join(keys, " "), opts...)
+if err != nil {
+return 0, err
+}
+
+count, err := parseIntReply(response)
+if err != nil || count < 0 {
+return 0, fmt.Errorf("invalid response: %s", response)
+}
+return count, nil
+}
+
+// dedupKeys returns the distinct keys in order of first appearance, and for
+// each of keys the position of that key in unique
+func dedupKeys(keys []string) (unique []string, index []int) {
+positions := make(map[string]int, len(keys))
+index = make([]int, len(keys))
+for i, key := range keys {
+j, ok := positions[key]
+if !ok {
+j = len(unique)
+positions[key] = j
+unique = append(unique, key)
+}
+index[i] = j
+}
+return unique, index
+}
@@ -0,0 +1,344 @@
+package nubdb
+
+import (
+"errors"
+"os"
+"path"
+"reflect"
+"sort"
+"strconv"
+"strings"
+"testing"
+"time"
+)
+
+func TestMGet(t *testing.T) {
+store := newMemStore()
+store.data["a"] = "1"
+store.data["b"] = "2"
+var sent []string
+client := connectFake(t, func(line string) string {
+sent = append(sent, line)
+return store.handle(line)
+})
+
+keys := []string{"b", "a", "missing", "b", "a", "b"}
+for _, tt := range []struct {
+name string
+opts []CallOption
+gets []string
+}{
+{"as listed", nil, []string{"GET b", "GET a", "GET missing", "GET b", "GET a", "GET b"}},
+{"deduplicated", []CallOption{WithDedup()}, []string{"GET b", "GET a", "GET missing"}},
+} {
+sent = nil
+results, err := client.MGet(keys, tt.opts...)
+if err != nil {
+t.Fatalf("%s: MGet: %v", tt.name, err)
+}
+if !reflect.DeepEqual(sent, tt.gets) {
+t.Errorf("%s: sent %q, want %q", tt.name, sent, tt.gets)
+}
+if len(results) != len(keys) {
+t.Fatalf("%s: %d results for %d keys", tt.name, len(results), len(keys))
+}
+for i, result := range results {
+want, found := store.data[keys[i]]
+if result.Key != keys[i] || result.Value != want || result.Found != found || result.Err != nil {
+t.Errorf("%s: result %d = %+v, want %s = %q", tt.name, i, result, keys[i], want)
+}
+}
+}
+
+if results, err := client.MGet(nil); err != nil || len(results) != 0 {
+t.Errorf("MGet(nil) = %v, %v", results, err)
+}
+}
+
+func TestMGetBatches(t *testing.T) {
+store := newMemStore()
+store.data["k"] = "v"
+config := startFakeServer(t, store.handle)
+config.PipelineBatchSize = 2
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+results, err := client.MGet([]string{"k", "x", "k", "x", "k"})
+if err != nil || len(results) != 5 || !results[4].Found || results[3].Found {
+t.Errorf("MGet across batches = %+v, %v", results, err)
+}
+}
+
+func TestMGetEmptyAndAbsent(t *testing.T) {
+store := newMemStore()
+store.data["present"] = "v"
+store.data["empty"] = ""
+client := connectFake(t, store.handle)
+
+results, err := client.MGet([]string{"present", "empty", "absent"})
+if err != nil {
+t.Fatalf("MGet: %v", err)
+}
+want := []Result{
+{Key: "present", Value: "v", Found: true},
+{Key: "empty", Value: "", Found: true},
+{Key: "absent", Value: "", Found: false},
+}
+if !reflect.DeepEqual(results, want) {
+t.Errorf("MGet = %+v, want %+v", results, want)
+}
+}
+
+func TestDeleteMany(t *testing.T) {
+store := newMemStore()
+var sent int
+client := connectFake(t, func(line string) string {
+sent++
+return store.handle(line)
+})
+
+for _, tt := range []struct {
+opts  []CallOption
+sends int
+}{
+{nil, 4},
+{[]CallOption{WithDedup()}, 3},
+} {
+store.data["a"], store.data["b"] = "1", "2"
+sent = 0
+n, err := client.DeleteMany([]string{"a", "b", "a", "c"}, tt.opts...)
+if err != nil || n != 2 {
+t.Errorf("DeleteMany = %d, %v, want 2", n, err)
+}
+if sent != tt.sends {
+t.Errorf("sent %d DELETEs, want %d", sent, tt.sends)
+}
+if len(store.data) != 0 {
+t.Errorf("store still has %v", store.data)
+}
+}
+}
+
+func TestTouchMany(t *testing.T) {
+store := newMemStore()
+var sent []string
+config := startFakeServer(t, func(line string) string {
+sent = append(sent, line)
+return store.handle(line)
+})
+config.PipelineBatchSize = 2
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+store.data["s1"], store.data["s2"], store.data["s3"] = "a", "b", "c"
+n, err := client.TouchMany([]string{"s1", "gone", "s2", "missing", "s3"}, 1500*time.Millisecond)
+if err != nil || n != 3 {
+t.Errorf("TouchMany = %d, %v, want 3", n, err)
+}
+for _, key := range []string{"s1", "s2", "s3"} {
+if store.ttl[key] != 2 {
+t.Errorf("TTL of %s = %d, want 2", key, store.ttl[key])
+}
+}
+if _, ok := store.data["gone"]; ok {
+t.Error("TouchMany created a missing key")
+}
+if len(sent) != 5 {
+t.Errorf("sent %d commands, want 5", len(sent))
+}
+
+if n, err := client.TouchMany([]string{"s1", "s1"}, time.Minute, WithDedup()); err != nil || n != 1 {
+t.Errorf("TouchMany with WithDedup = %d, %v, want 1", n, err)
+}
+if n, err := client.TouchMany(nil, time.Minute); err != nil || n != 0 {
+t.Errorf("TouchMany of no keys = %d, %v", n, err)
+}
+if _, err := client.TouchMany([]string{"s1"}, 0); err == nil {
+t.Error("TouchMany with a zero TTL should fail")
+}
+}
+
+func TestUnlink(t *testing.T) {
+store := newMemStore()
+var sent []string
+client := connectFake(t, func(line string) string {
+sent = append(sent, line)
+keys, ok := strings.CutPrefix(line, "UNLINK ")
+if !ok {
+return store.handle(line)
+}
+var n int
+for _, key := range strings.Fields(keys) {
+if _, ok := store.data[key]; ok {
+delete(store.data, key)
+n++
+}
+}
+return strconv.Itoa(n)
+})
+
+store.data["a"], store.data["b"] = "1", "2"
+if n, err := client.Unlink([]string{"a", "b", "c"}); err != nil || n != 2 {
+t.Errorf("Unlink = %d, %v, want 2", n, err)
+}
+if len(sent) != 1 || sent[0] != "UNLINK a b c" {
+t.Errorf("sent %q, want one UNLINK", sent)
+}
+if len(store.data) != 0 {
+t.Errorf("store still has %v", store.data)
+}
+if n, err := client.Unlink(nil); err != nil || n != 0 {
+t.Errorf("Unlink of no keys = %d, %v", n, err)
+}
+
+sent = nil
+store.data["a"] = "1"
+if n, err := client.Unlink([]string{"a", "a"}, WithDedup()); err != nil || n != 1 || len(sent) != 1 || sent[0] != "UNLINK a" {
+t.Errorf("Unlink with WithDedup = %d, %v, sent %q", n, err, sent)
+}
+sent = nil
+if _, err := client.Unlink([]string{"a"}, WithDeadline(time.Now().Add(-time.Second))); !errors.Is(err, os.ErrDeadlineExceeded) || len(sent) != 0 {
+t.Errorf("Unlink past its deadline = %v, sent %q", err, sent)
+}
+}
+
+func TestUnlinkFallback(t *testing.T) {
+store := newMemStore()
+var sent []string
+client := connectFake(t, func(line string) string {
+sent = append(sent, line)
+return store.handle(line)
+})
+
+for round := 0; round < 2; round++ {
+sent = nil
+store.data["a"], store.data["b"] = "1", "2"
+if n, err := client.Unlink([]string{"a", "b", "c"}); err != nil || n != 2 {
+t.Errorf("round %d: Unlink = %d, %v, want 2", round, n, err)
+}
+want := []string{"DELETE a", "DELETE b", "DELETE c"}
+if round == 0 {
+want = append([]string{"UNLINK a b c"}, want...)
+}
+if !reflect.DeepEqual(sent, want) {
+t.Errorf("round %d: sent %q, want %q", round, sent, want)
+}
+}
+
+// Other errors are not taken for a missing UNLINK
+other := connectFake(t, func(string) string { return "ERROR: out of memory" })
+if _, err := other.Unlink([]string{"a"}); err == nil {
+t.Error("Unlink should return a server error")
+}
+if other.noUnlink.Load() {
+t.Error("a server error disabled UNLINK")
+}
+}
+
+func TestExistsCountDedup(t *testing.T) {
+var sent string
+client := connectFake(t, func(line string) string {
+sent = line
+return "1"
+})
+
+if _, err := client.ExistsCount([]string{"a", "b", "a"}, WithDedup()); err != nil || sent != "EXISTS a b" {
+t.Errorf("ExistsCount with WithDedup sent %q, %v", sent, err)
+}
+}
+
+// patternStore is a memStore that also answers SCAN with MATCH, two keys
+// per page, and DELPATTERN if serverSide is set
+func patternStore(t *testing.T, store *memStore, serverSide bool) (*Client, *[]string) {
+var verbs []string
+client := connectFake(t, func(line string) string {
+fields := strings.Fields(line)
+verbs = append(verbs, fields[0])
+store.mu.Lock()
+var keys []string
+for key := range store.data {
+if len(fields) > 1 {
+if ok, _ := path.Match(fields[len(fields)-1], key); ok {
+keys = append(keys, key)
+}
+}
+}
+store.mu.Unlock()
+sort.Strings(keys)
+
+switch {
+case fields[0] == "DELPATTERN" && serverSide:
+for _, key := range keys {
+store.handle("DELETE " + key)
+}
+return strconv.Itoa(len(keys))
+case fields[0] == "SCAN":
+// Deleted keys shift later ones down, so the cursor counts
+// remaining matches rather than positions
+end := min(2, len(keys))
+next := "0"
+if end < len(keys) {
+next = "1"
+}
+return multiLine(append([]string{next}, keys[:end]...)...)
+}
+return store.handle(line)
+})
+return client, &verbs
+}
+
+func TestDeletePattern(t *testing.T) {
+for _, serverSide := range []bool{true, false} {
+store := newMemStore()
+for _, key := range []string{"user:1", "user:2", "user:3", "user:*", "session:1"} {
+store.data[key] = "v"
+}
+client, verbs := patternStore(t, store, serverSide)
+
+want := DeleteScan
+if serverSide {
+want = DeleteServerSide
+}
+for round := 0; round < 2; round++ {
+result, err := client.DeletePattern("user:*")
+if err != nil || result.Deleted != int64(4-4*round) || result.Mode != want {
+t.Errorf("%s round %d: DeletePattern = %+v, %v", want, round, result, err)
+}
+}
+if _, ok := store.data["session:1"]; !ok || len(store.data) != 1 {
+t.Errorf("%s: store has %v, want only session:1", want, store.data)
+}
+
+// A server without DELPATTERN is only asked once
+var asked int
+for _, verb := range *verbs {
+if verb == "DELPATTERN" {
+asked++
+}
+}
+if wantAsked := map[bool]int{true: 2, false: 1}[serverSide]; asked != wantAsked {
+t.Errorf("%s: sent %d DELPATTERNs, want %d", want, asked, wantAsked)
+}
+}
+}
+
+func TestDeletePrefix(t *testing.T) {
+store := newMemStore()
+for _, key := range []string{"a*1", "a*2", "ab"} {
+store.data[key] = "v"
+}
+client, _ := patternStore(t, store, true)
+
+if result, err := client.DeletePrefix("a*"); err != nil || result.Deleted != 2 {
+t.Errorf("DeletePrefix = %+v, %v, want the two keys starting with a*", result, err)
+}
+if _, ok := store.data["ab"]; !ok {
+t.Error("DeletePrefix took * in the prefix as a wildcard")
+}
+}
@@ -0,0 +1,284 @@
+package nubdb
+
+import (
+"bufio"
+"errors"
+"fmt"
+"net"
+"os"
+"strings"
+"sync"
+"time"
+)
+
+// ErrMuxClosed is returned by MuxClient calls after Close
+var ErrMuxClosed = errors.New("mux client closed")
+
+// MuxClient shares one connection between concurrent callers without
+// making them wait for each other's round trips: a writer loop sends
+// commands as they arrive, batching those that queue up, and a reader loop
+// hands each reply to the oldest waiting caller, relying on the server
+// answering in order. If the connection fails, every waiting call fails
+// with the error and so does every later call; dial a new MuxClient to
+// recover.
+//
+// Only commands with single-line replies can be multiplexed, so MuxClient
+// offers a smaller API than Client. Of the Config fields, it uses those that
+// pick and dial the server (Host, Port, Endpoints, Timeout, DialFunc),
+// Terminator, AllowedCommands and DisallowedCommands; the rest, such as
+// AutoReset, caching and the callbacks, apply only to Client.
+type MuxClient struct {
+conn       net.Conn
+reader     *bufio.Reader
+writer     *bufio.Writer
+terminator string
+allowed    map[string]bool // nil allows every command
+disallowed map[string]bool
+
+requests chan *muxRequest
+done     chan struct{}
+
+mu      sync.Mutex
+pending []*muxRequest // written, awaiting replies, oldest first
+err     error
+}
+
+type muxRequest struct {
+cmd   string
+reply chan muxReply // buffered so the reader never blocks on a caller
+}
+
+type muxReply struct {
+response string
+err      error
+}
+
+// DialMux connects to the server described by config and starts the
+// writer and reader loops
+func DialMux(config *Config) (*MuxClient, error) {
+if config == nil {
+config = DefaultConfig()
+}
+if err := config.Validate(); err != nil {
+return nil, err
+}
+
+conn, _, err := dial(config, 0)
+if err != nil {
+return nil, err
+}
+
+m := &MuxClient{
+conn:       conn,
+reader:     bufio.NewReader(conn),
+writer:     bufio.NewWriter(conn),
+terminator: config.Terminator,
+disallowed: commandSet(config.DisallowedCommands),
+requests:   make(chan *muxRequest),
+done:       make(chan struct{}),
+}
+if m.terminator == "" {
+m.terminator = "\n"
+}
+if len(config.AllowedCommands) > 0 {
+m.allowed = commandSet(config.AllowedCommands)
+}
+
+go m.writeLoop()
+go m.readLoop()
+return m, nil
+}
+
+// writeLoop queues and writes requests, flushing once no more are waiting
+func (m *MuxClient) writeLoop() {
+for {
+select {
+case req := <-m.requests:
+if !m.write(req) {
+return
+}
+for more := true; more; {
+select {
+case req := <-m.requests:
+if !m.write(req) {
+return
+}
+default:
+more = false
+}
+}
+if err := m.writer.Flush(); err != nil {
+m.fail(fmt.Errorf("flush error: %w", err))
+return
+}
+case <-m.done:
+return
+}
+}
+}
+
+// write queues req for a reply and buffers its command, reporting false
+// once the connection has failed
+func (m *MuxClient) write(req *muxRequest) bool {
+m.mu.Lock()
+if m.err != nil {
+err := m.err
+m.mu.Unlock()
+req.reply <- muxReply{err: err}
+return false
+}
+m.pending = append(m.pending, req)
+m.mu.Unlock()
+
+if _, err := m.writer.WriteString(req.cmd + m.terminator); err != nil {
+m.fail(fmt.Errorf("write error: %w", err))
+return false
+}
+return true
+}
+
+// readLoop delivers each reply to the oldest pending request
+func (m *MuxClient) readLoop() {
+for {
+line, err := m.reader.ReadString(m.terminator[len(m.terminator)-1])
+if err != nil {
+m.fail(fmt.Errorf("read error: %w", err))
+return
+}
+
+m.mu.Lock()
+if len(m.pending) == 0 {
+m.mu.Unlock()
+m.fail(fmt.Errorf("unexpected reply with no command pending: %q", line))
+return
+}
+req := m.pending[0]
+m.pending[0] = nil
+m.pending = m.pending[1:]
+m.mu.Unlock()
+
+req.reply <- muxReply{response: strings.TrimSpace(strings.TrimSuffix(line, m.terminator))}
+}
+}
+
+// fail records the first connection error, closes the connection and
+// fails every pending request
+func (m *MuxClient) fail(err error) {
+m.mu.Lock()
+if m.err != nil {
+m.mu.Unlock()
+return
+}
+m.err = err
+pending := m.pending
+m.pending = nil
+close(m.done)
+m.mu.Unlock()
+
+m.conn.Close()
+for _, req := range pending {
+req.reply <- muxReply{err: err}
+}
+}
+
+// Do sends cmd and returns its raw single-line reply, converting error
+// replies into *ServerError. A command that is not a single line, or that
+// the config's command filters refuse (ErrCommandNotAllowed), fails without
+// being sent. A call that gives up on its deadline leaves its reply to be
+// discarded when it arrives, so the connection stays in sync.
+func (m *MuxClient) Do(cmd string, opts ...CallOption) (string, error) {
+if cmd == "" || strings.ContainsAny(cmd, "\r\n") {
+return "", fmt.Errorf("Do: command is not a single line: %q", cmd)
+}
+if err := checkCommand(m.allowed, m.disallowed, cmd); err != nil {
+return "", err
+}
+
+var expired <-chan time.Time
+if deadline := newCallOptions(opts).effectiveDeadline(); !deadline.IsZero() {
+if err := checkDeadline(deadline); err != nil {
+return "", err
+}
+timer := time.NewTimer(time.Until(deadline))
+defer timer.Stop()
+expired = timer.C
+}
+
+req := &muxRequest{cmd: cmd, reply: make(chan muxReply, 1)}
+select {
+case m.requests <- req:
+case <-m.done:
+return "", m.closedErr()
+case <-expired:
+return "", fmt.Errorf("call deadline exceeded: %w", os.ErrDeadlineExceeded)
+}
+
+select {
+case reply := <-req.reply:
+if reply.err != nil {
+return "", reply.err
+}
+if err := parseServerError(reply.response); err != nil {
+return "", err
+}
+return reply.response, nil
+case <-expired:
+return "", fmt.Errorf("call deadline exceeded: %w", os.ErrDeadlineExceeded)
+}
+}
+
+func (m *MuxClient) closedErr() error {
+m.mu.Lock()
+defer m.mu.Unlock()
+return m.err
+}
+
+// Get retrieves a value by key, returning an empty string for a missing key
+func (m *MuxClient) Get(key string, opts ...CallOption) (string, error) {
+response, err := m.Do(fmt.Sprintf("GET %s", key), opts...)
+if err != nil {
+return "", err
+}
+value, _ := parseGetReply(response)
+return value, nil
+}
+
+// Set stores a key-value pair
+func (m *MuxClient) Set(key, value string, ttl int, opts ...CallOption) error {
+response, err := m.Do(setCommand(key, value, ttl), opts...)
+if err != nil {
+return err
+}
+if response != "OK" {
+return fmt.Errorf("unexpected response: %s", response)
+}
+return nil
+}
+
+// Delete removes a key
+func (m *MuxClient) Delete(key string, opts ...CallOption) error {
+response, err := m.Do(fmt.Sprintf("DELETE %s", key), opts...)
+if err != nil {
+return err
+}
+if response != "OK" && !isNotFound(response) {
+return fmt.Errorf("unexpected response: %s", response)
+}
+return nil
+}
+
+// Incr increments the integer value at key and returns the new value
+func (m *MuxClient) Incr(key string, opts ...CallOption) (int64, error) {
+response, err := m.Do(fmt.Sprintf("INCR %s", key), opts...)
+if err != nil {
+return 0, err
+}
+return parseIntReply(response)
+}
+
+// Close closes the connection, failing pending and later calls with
+// ErrMuxClosed
+func (m *MuxClient) Close() error {
+m.fail(ErrMuxClosed)
+return nil
+}
@@ -0,0 +1,171 @@
+package nubdb
+
+import (
+"errors"
+"fmt"
+"os"
+"strconv"
+"strings"
+"sync"
+"testing"
+"time"
+)
+
+func TestMuxConcurrentCallers(t *testing.T) {
+store := newMemStore()
+mux, err := DialMux(startFakeServer(t, store.handle))
+if err != nil {
+t.Fatalf("DialMux: %v", err)
+}
+defer mux.Close()
+
+var wg sync.WaitGroup
+errs := make(chan error, 100)
+for i := 0; i < 100; i++ {
+wg.Add(1)
+go func(i int) {
+defer wg.Done()
+key := fmt.Sprintf("key:%d", i)
+for j := 0; j < 20; j++ {
+value := strconv.Itoa(i*1000 + j)
+if err := mux.Set(key, value, 0); err != nil {
+errs <- err
+return
+}
+got, err := mux.Get(key)
+if err != nil {
+errs <- err
+return
+}
+if got != value {
+errs <- fmt.Errorf("%s: got %q, want %q", key, got, value)
+return
+}
+if _, err := mux.Incr("counter"); err != nil {
+errs <- err
+return
+}
+}
+}(i)
+}
+wg.Wait()
+close(errs)
+for err := range errs {
+t.Error(err)
+}
+
+if n, _ := mux.Incr("counter"); n != 2001 {
+t.Errorf("counter = %d, want 2001", n)
+}
+}
+
+func TestMuxConnectionLoss(t *testing.T) {
+release := make(chan struct{})
+t.Cleanup(func() { close(release) })
+mux, err := DialMux(startFakeServer(t, func(line string) string {
+if line == "SLOW" {
+<-release
+}
+return "OK"
+}))
+if err != nil {
+t.Fatalf("DialMux: %v", err)
+}
+
+var wg sync.WaitGroup
+errs := make(chan error, 5)
+for i := 0; i < 5; i++ {
+wg.Add(1)
+go func() {
+defer wg.Done()
+_, err := mux.Do("SLOW")
+errs <- err
+}()
+}
+
+// Wait until every call is pending, then drop the connection
+for {
+mux.mu.Lock()
+n := len(mux.pending)
+mux.mu.Unlock()
+if n == 5 {
+break
+}
+time.Sleep(time.Millisecond)
+}
+mux.conn.Close()
+wg.Wait()
+close(errs)
+
+for err := range errs {
+if err == nil || errors.Is(err, ErrMuxClosed) {
+t.Errorf("pending call error = %v, want the read error", err)
+}
+}
+if _, err := mux.Do("SIZE"); err == nil {
+t.Error("call after connection loss should fail")
+}
+
+mux.Close()
+}
+
+func TestMuxDeadline(t *testing.T) {
+release := make(chan struct{})
+mux, err := DialMux(startFakeServer(t, func(line string) string {
+if line == "SLOW" {
+<-release
+return `"slow"`
+}
+return `"fast"`
+}))
+if err != nil {
+t.Fatalf("DialMux: %v", err)
+}
+defer mux.Close()
+
+if _, err := mux.Do("SLOW", WithTimeout(20*time.Millisecond)); !errors.Is(err, os.ErrDeadlineExceeded) {
+t.Fatalf("Do = %v, want deadline exceeded", err)
+}
+close(release)
+
+// The abandoned reply is discarded, not handed to the next caller
+if got, err := mux.Get("k"); err != nil || got != "fast" {
+t.Errorf("Get after timeout = %q, %v; want fast", got, err)
+}
+}
+
+func TestMuxCommandFilters(t *testing.T) {
+store := newMemStore()
+var sent []string
+var mu sync.Mutex
+config := startFakeServer(t, func(line string) string {
+mu.Lock()
+sent = append(sent, line)
+mu.Unlock()
+return store.handle(line)
+})
+config.DisallowedCommands = []string{"clear"}
+mux, err := DialMux(config)
+if err != nil {
+t.Fatalf("DialMux: %v", err)
+}
+defer mux.Close()
+
+if _, err := mux.Do("CLEAR"); !errors.Is(err, ErrCommandNotAllowed) {
+t.Errorf("Do(CLEAR) = %v, want ErrCommandNotAllowed", err)
+}
+for _, cmd := range []string{"", "GET a\nCLEAR", "GET a\r"} {
+if _, err := mux.Do(cmd); err == nil {
+t.Errorf("Do(%q) should fail", cmd)
+}
+}
+if err := mux.Set("k", "v", 0); err != nil {
+t.Errorf("Set with CLEAR disallowed = %v", err)
+}
+
+mu.Lock()
+defer mu.Unlock()
+if len(sent) != 1 || !strings.HasPrefix(sent[0], "SET ") {
+t.Errorf("server received %q, want only the SET", sent)
+}
+}
@@ -3,20 +3,98 @@ package nubdb
 
 import (
 "bufio"
+"context"
+"encoding/json"
+"errors"
 "fmt"
+"io"
+"math"
+"math/rand"
 "net"
 "strconv"
 "strings"
+"sync"
+"sync/atomic"
 "time"
+"unicode/utf8"
 )
 
-// Client represents a connection to NubDB
+// Client represents a connection to NubDB. It is safe for concurrent use;
+// commands from different goroutines are serialized on the connection.
 type Client struct {
+mu     sync.Mutex
 conn   net.Conn
 reader *bufio.Reader
 writer *bufio.Writer
 host   string
 port   int
+
+chunkSize  int
+terminator string
+
+config   Config
+endpoint int // index into config.addrs() of the current connection
+desynced bool
+flight   *flightGroup
+cache    *valueCache
+latency  *latencyRecorder
+
+breaker        breakerState
+reconnectFails int // automatic reconnects failed in a row
+
+// internalHolds counts internalLock callers holding or waiting for mu
+internalHolds atomic.Int32
+
+// live mirrors conn so interrupt can reach it without waiting for mu
+live atomic.Value // net.Conn
+
+// noUnlink, noDelPattern and noDBSize are set once the server has
+// rejected UNLINK, DELPATTERN or DBSIZE as unknown
+noUnlink     atomic.Bool
+noDelPattern atomic.Bool
+noDBSize     atomic.Bool
+
+// subscribed is set once a raw SUBSCRIBE has put the connection into
+// subscribe mode, where the server runs no ordinary commands
+subscribed bool
+
+// handshaking is set while handshake sends the client's own setup
+// commands, which the command filters let through
+handshaking bool
+
+outstanding int      // commands written whose replies are still unread
+framed      bool     // replies are framed, by FramedReplies or HELLO
+framedLines []string // rendered lines of the framed reply being read
+framedReply Reply    // that reply, for checkReply
+received    int      // bytes read since connecting, for traffic
+traffic     trafficCounters
+
+// inflater and deflater sit between the buffers and conn when
+// Config.Compression is in effect; see wire
+inflater io.Reader
+deflater io.Writer
+
+// maxRead and maxWritten are the largest reply line and command batch
+// seen, for Config.AdaptiveBuffers
+maxRead    int
+maxWritten int
+
+// recentMu lets RecentCommands and RecentErrors read recent and failed
+// while a command holds mu, which is when a hung connection needs
+// diagnosing
+recentMu sync.Mutex
+recent   ring[string] // verbs of the commands written
+failed   ring[CommandError]
+
+allowed    map[string]bool // nil allows every command
+disallowed map[string]bool
+
+idleTimer  *time.Timer
+lastUsed   time.Time
+idleClosed bool
+
+// scanTypeFallback is set once the server rejects SCAN's TYPE option
+scanTypeFallback atomic.Bool
 }
 
 // Config holds configuration for the client
@@ -24,104 +102,1568 @@ type Config struct {
 Host    string
 Port    int
 Timeout time.Duration
+
+// Endpoints is an ordered list of "host:port" addresses to try instead of
+// Host and Port. Connect uses the first that accepts a connection; Reset
+// starts from the one after the current endpoint.
+Endpoints []string
+
+// DialFunc, if set, opens connections instead of net.DialTimeout, e.g.
+// through a SOCKS proxy or to an in-memory test server. It is called with
+// network "tcp" and each address from Endpoints or Host and Port; ctx
+// expires after Timeout.
+DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// ChunkSize is the largest value SetLarge sends in a single command.
+// Zero means DefaultChunkSize.
+ChunkSize int
+
+// Terminator ends every command line and is expected to end every response.
+// Empty means "\n"; set it to "\r\n" for CRLF-based servers.
+Terminator string
+
+// AutoReset makes the next command call Reset after an I/O error may have
+// left the connection out of sync, instead of failing with ErrDesynced.
+AutoReset bool
+
+// NoDelay disables Nagle's algorithm on TCP connections so each command
+// is sent immediately, which suits one-command-at-a-time round trips.
+// Batched writes such as pipelines already flush many commands at once,
+// so leaving it off trades a little latency for fewer small packets.
+NoDelay bool
+
+// CoalesceGets makes concurrent Gets for the same key share a single
+// in-flight request and its result. The shared request runs under the
+// first caller's CallOptions; a caller that joins it still returns at its
+// own deadline, without the value.
+CoalesceGets bool
+
+// CacheSize, when positive, caches up to that many values read by Get
+// and its typed variants on the client. A cached key is served without a
+// round trip until this client writes it or Invalidate drops it; writes by
+// other clients are not seen, so use it for values that rarely change.
+// Cached reads do not refresh SlidingTTL.
+CacheSize int
+
+// NegativeCacheTTL, when positive, makes Get remember for that long that a
+// key was missing, answering repeated Gets of it without a round trip.
+// Writes by this client and Invalidate forget the miss at once. Misses share
+// the CacheSize bound, or DefaultNegativeCacheSize if CacheSize is unset.
+NegativeCacheTTL time.Duration
+
+// StrictReplies makes the client check, before each command, that every
+// earlier reply was read and no unexpected data arrived, failing with
+// ErrUnbalanced otherwise. It is a debugging aid for code that might leave
+// the reader a reply ahead or behind; unsolicited data is only caught once
+// it has reached the client.
+StrictReplies bool
+
+// TrackLatency makes the client keep a histogram of round-trip times per
+// command verb, reported by LatencyStats
+TrackLatency bool
+
+// AdaptiveBuffers makes the client grow its read and write buffers to fit
+// the largest reply line and command batch seen so far, up to
+// MaxAdaptiveBufferSize, so workloads with large values stop assembling
+// each one from many buffer-sized fragments. Buffers never shrink.
+AdaptiveBuffers bool
+
+// RetryReads makes commands that only read, such as GET, EXISTS or SCAN,
+// reconnect and try once more when the connection drops under them, so a
+// server restart or idle connection reaped by a proxy does not surface as
+// an error. Server errors and timeouts are returned as usual, and writes are
+// never repeated, since the first attempt may have taken effect.
+RetryReads bool
+
+// FramedReplies makes the client read replies in a RESP-like framed format,
+// where a first byte of '+', '-', ':', '$' or '*' marks a status, error,
+// integer, bulk string or array reply, instead of one reply per line. It
+// suits servers and proxies that answer that way; bulk strings may then hold
+// any bytes, including line breaks. Commands are still sent as lines. Every
+// method works as with line replies, and DoReply returns the typed Reply.
+// MuxClient and Subscription connections always read line replies.
+FramedReplies bool
+
+// NegotiateProtocol makes Connect and Reset send "HELLO 2" to ask the
+// server for framed replies, reading replies as framed if it agrees and as
+// lines if it answers 1 or rejects HELLO, as NubDB servers without framing
+// do. It costs one extra round trip per connection and decides the reply
+// format in place of FramedReplies. Client.Protocol reports the outcome.
+NegotiateProtocol bool
+
+// Compression makes Connect and Reset send "COMPRESS DEFLATE" to ask the
+// server to compress the connection. If it answers OK, everything after
+// the OK is DEFLATE-compressed both ways, commands and replies alike,
+// flushed at the end of each batch of commands. A server that rejects it
+// leaves the connection in plain text. Client.Compressed reports the
+// outcome. It suits slow links; on fast ones it only costs CPU.
+Compression bool
+
+// VerifyProtocol makes Connect and Reset send a SIZE probe and fail with
+// ErrProtocolMismatch unless the reply looks like NubDB's. It costs one
+// extra round trip per connection.
+VerifyProtocol bool
+
+// IdleTimeout, when positive, closes the connection after that long
+// without commands. The next command redials it if AutoReset is set and
+// fails with ErrIdleClosed otherwise.
+IdleTimeout time.Duration
+
+// SlidingTTL, when positive, makes every Get of a key that already has an
+// expiry push that expiry out to SlidingTTL from now (in the same round trip),
+// for session-style sliding expiration. Keys without an expiry are left alone.
+SlidingTTL time.Duration
+
+// TTLJitter, at least 0 and less than 1, makes Set, SetLarge and SetAllOK
+// spread expirations of keys written with the same TTL by picking each
+// key's TTL at random within that fraction of the requested one: 0.1 turns
+// a TTL of 100 seconds into one from 90 to 110. This keeps keys cached
+// together from expiring together and stampeding the backing store. TTLs
+// never drop below one second, and a TTL of zero (no expiry) is left alone.
+TTLJitter float64
+
+// PipelineBatchSize caps how many commands Pipeline.Exec writes per flush;
+// larger pipelines are split into several round trips transparently.
+// Zero or negative sends every command in one flush.
+PipelineBatchSize int
+
+// PipelineMaxBytes, when positive, caps the encoded size of the commands
+// a Pipeline holds. Queuing a command that reaches it sends the queued
+// commands at once and keeps only their responses until Exec, so a
+// runaway pipeline costs its replies' memory but not its commands'.
+PipelineMaxBytes int
+
+// SlowLatency is the round-trip time above which Health reports the
+// server as degraded. Zero means DefaultSlowLatency.
+SlowLatency time.Duration
+
+// PoolMaxDialing, when positive, caps how many connections a ClientPool
+// opens at once, and PoolDialInterval, when positive, is the least time
+// between the starts of two of its dials. Together they keep a burst of
+// demand on a cold pool from storming the server with connections; Gets
+// wait for their turn to dial within their deadline. Connect ignores both.
+PoolMaxDialing   int
+PoolDialInterval time.Duration
+
+// MaxUpdateRetries is how many times Update retries after losing a race
+// with another writer before giving up with ErrConflict.
+MaxUpdateRetries int
+
+// CircuitBreaker, if its Failures is positive, makes commands fail fast
+// with ErrCircuitOpen for a while after repeated connection failures,
+// instead of each waiting out the timeout against a server that is down.
+CircuitBreaker CircuitBreaker
+
+// ReconnectBackoff, if its Initial is positive, spaces out automatic
+// reconnects with jittered exponential backoff.
+ReconnectBackoff ReconnectBackoff
+
+// DisallowedCommands lists command verbs, such as "CLEAR", that the client
+// refuses to send; AllowedCommands, if non-empty, lists the only verbs it
+// will send. An entry of two words, such as "CLIENT LIST", matches that
+// subcommand only. Matching ignores case. Refused commands fail with
+// ErrCommandNotAllowed before anything is written. This guards against
+// accidents, not against a hostile caller, who can open their own
+// connection.
+DisallowedCommands []string
+AllowedCommands    []string
+
+// DetectConcurrentUse makes overlapping commands from different goroutines
+// panic instead of waiting for each other. Enable it while testing code that
+// is meant to give each goroutine its own Client, where silently sharing one
+// would serialize every command behind a single connection. The client's
+// own background work, such as IdleTimeout and ClientPool, does not count.
+DetectConcurrentUse bool
+
+// OnConnect, OnDisconnect and OnReconnect are called with the server
+// address when the client connects, loses or closes its connection, and
+// redials it via Reset. err is the failure that caused the event, or nil.
+// They run synchronously and must return quickly without using the Client.
+OnConnect    ConnEventFunc
+OnDisconnect ConnEventFunc
+OnReconnect  ConnEventFunc
+}
+
+// ConnEventFunc is a connection lifecycle callback
+type ConnEventFunc func(addr string, err error)
+
+// ErrKeyNotFound is returned by commands that report a missing key as an error
+var ErrKeyNotFound = errors.New("key not found")
+
+// ErrDesynced is returned after an I/O error may have left unread responses on
+// the connection. Call Reset (or enable Config.AutoReset) to recover.
+var ErrDesynced = errors.New("connection out of sync, call Reset")
+
+// ErrIdleClosed is returned for commands on a connection closed by
+// Config.IdleTimeout when AutoReset is not set
+var ErrIdleClosed = errors.New("connection closed after idle timeout")
+
+// ErrEmptyResponse is returned when the server answers a command with an
+// empty line, which no NubDB command does. The connection stays usable.
+var ErrEmptyResponse = errors.New("empty response from server")
+
+// ErrVerifyMismatch is returned by a Set made with WithVerify when the value
+// read back differs from the one written
+var ErrVerifyMismatch = errors.New("value read back differs from value written")
+
+// ErrInvalidUTF8 is returned by Get for a value that is not valid UTF-8 text
+var ErrInvalidUTF8 = errors.New("value is not valid UTF-8")
+
+// ErrConfirmationRequired is returned by ClearConfirm when its token does
+// not name the server the client is connected to
+var ErrConfirmationRequired = errors.New("confirmation required: token does not name this server")
+
+// ErrUnexpectedReplyType is returned when a command's reply is not of the
+// type the command answers with, such as an array in reply to INCR
+var ErrUnexpectedReplyType = errors.New("unexpected reply type")
+
+// ErrInSubscribeMode is returned for commands on a connection that a raw
+// SUBSCRIBE, sent with DoArgs or SendAll, has put into subscribe mode. Call
+// Reset to get an ordinary connection back; use Client.Subscribe to receive
+// messages, which subscribes on a connection of its own.
+var ErrInSubscribeMode = errors.New("connection is in subscribe mode")
+
+// ErrUnbalanced is returned when Config.StrictReplies is set and the client
+// finds a reply it did not expect or a reply it failed to read. The
+// connection is then out of sync, as with ErrDesynced.
+var ErrUnbalanced = errors.New("commands and replies out of balance")
+
+// ErrCommandNotAllowed is returned for commands excluded by
+// Config.AllowedCommands or Config.DisallowedCommands
+var ErrCommandNotAllowed = errors.New("command not allowed")
+
+// ErrProtocolMismatch is returned when Config.VerifyProtocol is set and the
+// peer does not answer like a NubDB server
+var ErrProtocolMismatch = errors.New("peer does not speak the NubDB protocol")
+
+// ServerError is an error reply sent by the server. Code is the leading
+// upper-case error code, such as "ERR" or "WRONGTYPE", if the reply has one,
+// and Message is the rest of the text.
+type ServerError struct {
+Code    string
+Message string
+}
+
+func (e *ServerError) Error() string {
+if e.Code == "" {
+return "server error: " + e.Message
+}
+return "server error: " + e.Code + " " + e.Message
+}
+
+// Is makes errors.Is match the sentinels for well-known server errors
+func (e *ServerError) Is(target error) bool {
+switch target {
+case ErrReadOnly:
+return e.Code == "READONLY" || isReadOnlyMessage(e.Message)
+case ErrNoScript:
+return e.Code == "NOSCRIPT"
+}
+return false
+}
+
+// ErrReadOnly matches server errors rejecting a write because the server is
+// read-only, e.g. a replica or a node mid-failover. Callers can back off or
+// retry elsewhere: errors.Is(err, ErrReadOnly).
+var ErrReadOnly = errors.New("server is read-only")
+
+func isReadOnlyMessage(message string) bool {
+lower := strings.ToLower(message)
+return strings.HasPrefix(lower, "readonly") || strings.Contains(lower, "read-only") || strings.Contains(lower, "read only")
+}
+
+// parseServerError returns a *ServerError if response is an error reply and
+// ErrEmptyResponse if it is empty. Every reply goes through it before being
+// parsed, so commands never mistake an empty line for a value.
+func parseServerError(response string) error {
+if response == "" {
+return ErrEmptyResponse
+}
+if !strings.HasPrefix(response, "ERROR") {
+return nil
+}
+
+message := strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(response, "ERROR"), ":"))
+code, rest, _ := strings.Cut(message, " ")
+if !isErrorCode(code) {
+return &ServerError{Message: message}
+}
+return &ServerError{Code: code, Message: strings.TrimSpace(rest)}
+}
+
+// commandVerbs are the command names the server may start an error
+// message with, which isErrorCode must not take for codes
+var commandVerbs = map[string]bool{
+"GET": true, "SET": true, "DELETE": true, "DEL": true, "UNLINK": true,
+"EXISTS": true, "INCR": true, "INCRBY": true, "DECR": true, "APPEND": true,
+"EXPIRE": true, "TTL": true, "PTTL": true, "CAS": true, "SETNX": true,
+"GETRANGE": true, "SETRANGE": true, "SIZE": true, "DBSIZE": true,
+"CLEAR": true, "DELPATTERN": true, "SCAN": true, "TYPE": true,
+"MEMORY": true, "INFO": true, "TIME": true, "PING": true, "QUIT": true,
+"CLIENT": true, "CONFIG": true, "HELLO": true, "COMPRESS": true, "SELECT": true,
+"WAIT": true, "MULTI": true, "EXEC": true, "DISCARD": true,
+"EVAL": true, "EVALSHA": true, "SCRIPT": true,
+"SUBSCRIBE": true, "UNSUBSCRIBE": true, "PSUBSCRIBE": true,
+"PUNSUBSCRIBE": true, "SSUBSCRIBE": true, "SUNSUBSCRIBE": true,
+"PUBLISH": true, "SADD": true, "SREM": true, "SMEMBERS": true,
+"SISMEMBER": true, "LPUSH": true, "RPUSH": true, "LPOP": true,
+"RPOP": true, "BLPOP": true, "BRPOP": true, "LRANGE": true, "LLEN": true,
+"HSET": true, "HGET": true, "HDEL": true, "HGETALL": true, "HEXISTS": true,
+}
+
+// isErrorCode reports whether word looks like an error code: an upper-case
+// word that is not a command name, since the server starts argument errors
+// with the command ("SET requires key and value")
+func isErrorCode(word string) bool {
+if len(word) < 2 || commandVerbs[word] {
+return false
+}
+for _, r := range word {
+if (r < 'A' || r > 'Z') && r != '_' {
+return false
+}
+}
+return true
+}
+
+// DefaultPipelineBatchSize is the PipelineBatchSize set by DefaultConfig
+const DefaultPipelineBatchSize = 1000
+
+// DefaultSlowLatency is the Health latency threshold used when
+// Config.SlowLatency is unset
+const DefaultSlowLatency = 100 * time.Millisecond
+
+// DefaultChunkSize is the chunk size used by SetLarge when Config.ChunkSize is unset
+const DefaultChunkSize = 512 * 1024
+
+// DefaultConfig returns default configuration
+func DefaultConfig() *Config {
+return &Config{
+Host:       "localhost",
+Port:       6379,
+Timeout:    5 * time.Second,
+ChunkSize:  DefaultChunkSize,
+Terminator: "\n",
+NoDelay:    true,
+
+PipelineBatchSize: DefaultPipelineBatchSize,
+MaxUpdateRetries:  10,
+}
+}
+
+// Validate reports the first invalid setting in config
+func (config *Config) Validate() error {
+for _, endpoint := range config.Endpoints {
+host, port, err := net.SplitHostPort(endpoint)
+if err != nil {
+return fmt.Errorf("invalid config: endpoint %q: %w", endpoint, err)
+}
+if n, err := strconv.Atoi(port); err != nil || host == "" || n <= 0 || n > 65535 {
+return fmt.Errorf("invalid config: endpoint %q is not host:port", endpoint)
+}
+}
+if len(config.Endpoints) == 0 {
+if config.Host == "" {
+return errors.New("invalid config: host is empty")
+}
+if config.Port <= 0 || config.Port > 65535 {
+return fmt.Errorf("invalid config: port %d out of range 1-65535", config.Port)
+}
+}
+if config.Timeout < 0 {
+return fmt.Errorf("invalid config: negative timeout %s", config.Timeout)
+}
+if config.PoolMaxDialing < 0 || config.PoolDialInterval < 0 {
+return errors.New("invalid config: negative pool dial limit")
+}
+if config.NegativeCacheTTL < 0 {
+return fmt.Errorf("invalid config: negative negative cache TTL %s", config.NegativeCacheTTL)
+}
+if config.CircuitBreaker.Failures < 0 || config.CircuitBreaker.CoolDown < 0 {
+return errors.New("invalid config: negative circuit breaker setting")
+}
+if backoff := config.ReconnectBackoff; backoff.Initial < 0 || backoff.Max < 0 {
+return errors.New("invalid config: negative reconnect backoff")
+}
+if jitter := config.ReconnectBackoff.Jitter; jitter < 0 || jitter > 1 {
+return fmt.Errorf("invalid config: reconnect jitter %g out of range [0, 1]", jitter)
+}
+if config.SlowLatency < 0 {
+return fmt.Errorf("invalid config: negative slow latency %s", config.SlowLatency)
+}
+if config.TTLJitter < 0 || config.TTLJitter >= 1 {
+return fmt.Errorf("invalid config: TTL jitter %g out of range [0, 1)", config.TTLJitter)
+}
+if config.PipelineMaxBytes < 0 {
+return fmt.Errorf("invalid config: negative pipeline byte budget %d", config.PipelineMaxBytes)
+}
+if config.ChunkSize < 0 {
+return fmt.Errorf("invalid config: negative chunk size %d", config.ChunkSize)
+}
+for _, filter := range [][]string{config.AllowedCommands, config.DisallowedCommands} {
+for _, cmd := range filter {
+if n := len(strings.Fields(cmd)); n == 0 || n > 2 {
+return fmt.Errorf("invalid config: command filter %q is not a verb or verb and subcommand", cmd)
+}
+}
+}
+if config.Terminator != "" && config.Terminator != "\n" && config.Terminator != "\r\n" {
+return fmt.Errorf("invalid config: terminator %q is not \"\\n\" or \"\\r\\n\"", config.Terminator)
+}
+
+return nil
+}
+
+// Connect creates a new connection to NubDB
+func Connect(config *Config) (*Client, error) {
+return connect(config, nil)
+}
+
+// connect is Connect with bufs, if not nil, reset onto the new connection
+// as its buffered reader and writer instead of allocating new ones
+func connect(config *Config, bufs *connBuffers) (*Client, error) {
+if config == nil {
+config = DefaultConfig()
+}
+if err := config.Validate(); err != nil {
+return nil, err
+}
+
+conn, endpoint, err := dial(config, 0)
+if err != nil {
+return nil, err
+}
+
+if bufs == nil {
+bufs = &connBuffers{reader: bufio.NewReader(conn), writer: bufio.NewWriter(conn)}
+} else {
+bufs.reader.Reset(conn)
+bufs.writer.Reset(conn)
+}
+
+client := &Client{
+conn:   conn,
+reader: bufs.reader,
+writer: bufs.writer,
+host:   config.Host,
+port:   config.Port,
+
+chunkSize:  config.ChunkSize,
+terminator: config.Terminator,
+
+config:   *config,
+endpoint: endpoint,
+}
+
+if client.chunkSize <= 0 {
+client.chunkSize = DefaultChunkSize
+}
+if client.terminator == "" {
+client.terminator = "\n"
+}
+if config.CoalesceGets {
+client.flight = &flightGroup{}
+}
+switch {
+case config.CacheSize > 0:
+client.cache = newValueCache(config.CacheSize, true, config.NegativeCacheTTL)
+case config.NegativeCacheTTL > 0:
+client.cache = newValueCache(DefaultNegativeCacheSize, false, config.NegativeCacheTTL)
+}
+if config.TrackLatency {
+client.latency = &latencyRecorder{verbs: make(map[string]*latencyHistogram)}
+}
+if len(config.AllowedCommands) > 0 {
+client.allowed = commandSet(config.AllowedCommands)
+}
+client.disallowed = commandSet(config.DisallowedCommands)
+
+if err := client.handshake(); err != nil {
+conn.Close()
+return nil, err
+}
+
+client.live.Store(conn)
+client.notify(config.OnConnect, nil)
+
+if config.IdleTimeout > 0 {
+client.lastUsed = time.Now()
+client.idleTimer = time.AfterFunc(config.IdleTimeout, client.closeIdle)
+}
+
+return client, nil
+}
+
+// addrs returns the server addresses described by config, in dial order
+func (config *Config) addrs() []string {
+if len(config.Endpoints) > 0 {
+return config.Endpoints
+}
+return []string{net.JoinHostPort(config.Host, strconv.Itoa(config.Port))}
+}
+
+// dial opens a TCP connection to the first reachable address of config,
+// trying them in order starting at index start. It returns the index of the
+// address it connected to.
+func dial(config *Config, start int) (net.Conn, int, error) {
+addrs := config.addrs()
+var errs []error
+for i := range addrs {
+index := (start + i) % len(addrs)
+conn, err := dialAddr(config, addrs[index])
+if err != nil {
+errs = append(errs, err)
+continue
+}
+
+if tc, ok := conn.(*net.TCPConn); ok {
+setNoDelay(tc, config.NoDelay)
+}
+
+return conn, index, nil
+}
+
+return nil, 0, fmt.Errorf("failed to connect: %w", errors.Join(errs...))
+}
+
+// dialAddr opens one connection with Config.DialFunc or, by default, TCP
+func dialAddr(config *Config, addr string) (net.Conn, error) {
+if config.DialFunc == nil {
+return net.DialTimeout("tcp", addr, config.Timeout)
+}
+
+ctx := context.Background()
+if config.Timeout > 0 {
+var cancel context.CancelFunc
+ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+defer cancel()
+}
+return config.DialFunc(ctx, "tcp", addr)
+}
+
+// setNoDelay is a variable so tests can observe it
+var setNoDelay = (*net.TCPConn).SetNoDelay
+
+// Reset closes the current connection and dials a new one, discarding any
+// responses left unread by an earlier failure or protocol desync.
+func (c *Client) Reset() error {
+c.lock()
+defer c.unlock()
+
+return c.reset()
+}
+
+// Reconnect closes the current connection and dials a fresh one, for when
+// the connection is known to be bad, e.g. after a failover detected
+// elsewhere. Unlike Reset, which moves on to the next endpoint, it dials
+// the current endpoint first.
+func (c *Client) Reconnect() error {
+c.lock()
+defer c.unlock()
+
+return c.redial(c.endpoint)
+}
+
+func (c *Client) reset() error {
+return c.redial(c.endpoint + 1)
+}
+
+// redial replaces the connection with one to the first reachable endpoint
+// from index start. The caller must hold c.mu.
+func (c *Client) redial(start int) error {
+if c.conn != nil {
+c.conn.Close()
+}
+
+// Stay desynced until the new connection is up so a failed dial is retried
+c.desynced = true
+conn, endpoint, err := dial(&c.config, start)
+if err != nil {
+c.notify(c.config.OnReconnect, err)
+return err
+}
+
+c.conn = conn
+c.live.Store(conn)
+c.endpoint = endpoint
+c.outstanding = 0
+c.subscribed = false
+c.framedLines = nil
+c.inflater, c.deflater = nil, nil
+c.reader.Reset(conn)
+c.writer.Reset(conn)
+if err := c.handshake(); err != nil {
+conn.Close()
+c.notify(c.config.OnReconnect, err)
+return err
+}
+c.desynced = false
+c.notify(c.config.OnReconnect, nil)
+
+if c.idleTimer != nil {
+c.idleClosed = false
+c.idleTimer.Reset(c.config.IdleTimeout)
+}
+return nil
+}
+
+// closeIdle closes the connection if it has not been used for IdleTimeout
+func (c *Client) closeIdle() {
+c.internalLock()
+defer c.internalUnlock()
+
+if c.idleClosed || c.conn == nil {
+return
+}
+if idle := time.Since(c.lastUsed); idle < c.config.IdleTimeout {
+c.idleTimer.Reset(c.config.IdleTimeout - idle)
+return
+}
+
+c.conn.Close()
+c.idleClosed = true
+c.desynced = true
+c.notify(c.config.OnDisconnect, nil)
+}
+
+// lock acquires c.mu for a command, panicking on contention with another
+// command if DetectConcurrentUse is set. Contention with the client's own
+// bookkeeping (see internalLock) waits instead.
+func (c *Client) lock() {
+if !c.config.DetectConcurrentUse {
+c.mu.Lock()
+return
+}
+
+if !c.mu.TryLock() {
+if c.internalHolds.Load() > 0 {
+c.mu.Lock()
+return
+}
+panic("nubdb: concurrent use of Client detected with DetectConcurrentUse set; " +
+"give each goroutine its own Client or disable the check (see Client documentation)")
+}
+}
+
+// internalLock acquires c.mu for the client's own short critical sections,
+// such as the idle timer, ClientPool and state accessors, which may run on
+// other goroutines than the commands. Counting them before they take c.mu
+// keeps DetectConcurrentUse from reporting them as concurrent use.
+func (c *Client) internalLock() {
+c.internalHolds.Add(1)
+c.mu.Lock()
+}
+
+// internalUnlock releases c.mu taken with internalLock
+func (c *Client) internalUnlock() {
+c.mu.Unlock()
+c.internalHolds.Add(-1)
+}
+
+// handshake sets up a fresh connection: it settles the protocol and
+// compression and verifies the server. Its commands bypass the command
+// filters, which apply to the caller's commands only. The caller must hold
+// c.mu or be the only user of c.
+func (c *Client) handshake() error {
+c.handshaking = true
+defer func() { c.handshaking = false }()
+
+if err := c.negotiate(); err != nil {
+return err
+}
+if err := c.negotiateCompression(); err != nil {
+return err
+}
+return c.verifyProtocol()
+}
+
+// verifyProtocol probes a fresh connection if Config.VerifyProtocol is set
+func (c *Client) verifyProtocol() error {
+if !c.config.VerifyProtocol {
+return nil
+}
+
+if c.config.Timeout > 0 {
+c.conn.SetDeadline(time.Now().Add(c.config.Timeout))
+defer c.conn.SetDeadline(time.Time{})
+}
+
+if err := c.writeCommand("SIZE"); err != nil {
+return err
+}
+
+response, err := c.readReply()
+if err != nil {
+return fmt.Errorf("%w: %v", ErrProtocolMismatch, err)
+}
+if _, err := parseSizeReply(response); err != nil {
+return fmt.Errorf("%w: received %q", ErrProtocolMismatch, response)
+}
+
+return nil
+}
+
+// unlock records the end of a command for the idle timer and releases c.mu
+func (c *Client) unlock() {
+c.breakerDone()
+if c.idleTimer != nil {
+c.lastUsed = time.Now()
+}
+c.mu.Unlock()
+}
+
+// markBroken records an I/O failure that may have left the connection out
+// of sync. The caller must hold c.mu.
+func (c *Client) markBroken(err error) {
+c.breakerFail()
+if !c.desynced {
+c.desynced = true
+c.notify(c.config.OnDisconnect, err)
+}
+}
+
+// notify invokes a lifecycle callback if it is set
+func (c *Client) notify(fn ConnEventFunc, err error) {
+if fn == nil {
+return
+}
+
+addr := c.config.addrs()[c.endpoint]
+if c.conn != nil && err == nil {
+addr = c.conn.RemoteAddr().String()
+}
+fn(addr, err)
+}
+
+// sendCommand sends a command and returns the response
+func (c *Client) sendCommand(cmd string, opts ...CallOption) (string, error) {
+return c.sendExpect(cmd, replyAny, opts)
+}
+
+// sendExpect is sendCommand for a command whose reply should be of type
+// want, failing with ErrUnexpectedReplyType if it is not; see checkReply.
+// An unchecked reply is read whole, as readWholeReply does.
+func (c *Client) sendExpect(cmd string, want ReplyType, opts []CallOption) (response string, err error) {
+c.lock()
+defer c.unlock()
+defer func() { c.recordErrors([]string{cmd}, nil, err) }()
+
+deadline := newCallOptions(opts).effectiveDeadline()
+if err := c.resync(deadline); err != nil {
+return "", err
+}
+
+clear, err := c.setDeadline(deadline)
+if err != nil {
+return "", err
+}
+defer clear()
+
+start, mark := time.Now(), c.received
+exchange := func() (string, error) {
+if err := c.writeCommand(cmd); err != nil {
+return "", err
+}
+if want == replyAny {
+return c.readWholeReply()
+}
+response, err := c.readReply()
+if err != nil {
+return "", err
+}
+return c.checkReply(cmd, want, response)
+}
+response, err = exchange()
+if err != nil && c.retryRead([]string{cmd}, err, opts) {
+mark = c.received
+response, err = exchange()
+}
+if err != nil {
+return "", err
+}
+c.observeExchange(cmd, start, mark)
+
+if err := parseServerError(response); err != nil {
+return "", err
+}
+
+return response, nil
+}
+
+// sendCommandLines sends a command whose reply spans several lines: a
+// "*<n>" header followed by n lines. A reply without a header is returned as
+// a single line.
+func (c *Client) sendCommandLines(cmd string, opts ...CallOption) ([]string, error) {
+return c.sendLines(cmd, false, opts)
+}
+
+// sendCommandList is sendCommandLines for commands that always reply with
+// a "*<n>" list, treating "(nil)" as an empty list and any other single
+// line as unexpected
+func (c *Client) sendCommandList(cmd string, opts ...CallOption) ([]string, error) {
+return c.sendLines(cmd, true, opts)
+}
+
+func (c *Client) sendLines(cmd string, list bool, opts []CallOption) (lines []string, err error) {
+c.lock()
+defer c.unlock()
+defer func() { c.recordErrors([]string{cmd}, nil, err) }()
+
+deadline := newCallOptions(opts).effectiveDeadline()
+if err := c.resync(deadline); err != nil {
+return nil, err
+}
+
+clear, err := c.setDeadline(deadline)
+if err != nil {
+return nil, err
+}
+defer clear()
+
+start, mark := time.Now(), c.received
+exchange := func() ([]string, error) {
+if err := c.writeCommand(cmd); err != nil {
+return nil, err
+}
+return c.readLines(list)
+}
+lines, err = exchange()
+if err != nil && c.retryRead([]string{cmd}, err, opts) {
+mark = c.received
+lines, err = exchange()
+}
+if err != nil {
+return nil, err
+}
+c.observeExchange(cmd, start, mark)
+return lines, nil
+}
+
+// observeExchange records a completed exchange of cmd, started at start
+// with c.received at mark, in BytesStats and LatencyStats. Every path that
+// talks to the server goes through it or sendCommands, and records its
+// failures with recordErrors. The caller must hold c.mu.
+func (c *Client) observeExchange(cmd string, start time.Time, mark int) {
+c.traffic.record(cmd, len(cmd)+len(c.terminator), c.received-mark)
+if c.latency != nil {
+c.latency.record([]string{cmd}, start)
+}
+}
+
+// readLines reads a reply that may span several lines, as described for
+// sendCommandLines, or for sendCommandList if list is set. The caller must
+// hold c.mu.
+func (c *Client) readLines(list bool) ([]string, error) {
+header, err := c.readReply()
+if err != nil {
+return nil, err
+}
+if err := parseServerError(header); err != nil {
+return nil, err
+}
+if !strings.HasPrefix(header, "*") {
+switch {
+case !list:
+return []string{header}, nil
+case isNotFound(header):
+return []string{}, nil
+}
+return nil, fmt.Errorf("unexpected response: %s", header)
+}
+
+n, err := strconv.Atoi(header[1:])
+if err != nil || n < 0 {
+c.markBroken(fmt.Errorf("invalid multi-line header: %s", header))
+return nil, fmt.Errorf("invalid response: %s", header)
+}
+
+lines := make([]string, 0, n)
+for i := 0; i < n; i++ {
+line, err := c.readLine()
+if err != nil {
+return nil, err
+}
+lines = append(lines, line)
+}
+
+return lines, nil
+}
+
+// sendCommands pipelines cmds in a single flush and returns their raw
+// responses in order, each read whole as readWholeReply does. Error replies
+// are left for the caller to inspect.
+func (c *Client) sendCommands(cmds []string, opts ...CallOption) (responses []string, err error) {
+c.lock()
+defer c.unlock()
+defer func() { c.recordErrors(cmds, responses, err) }()
+
+deadline := newCallOptions(opts).effectiveDeadline()
+if err := c.resync(deadline); err != nil {
+return nil, err
+}
+
+clear, err := c.setDeadline(deadline)
+if err != nil {
+return nil, err
+}
+defer clear()
+
+start := time.Now()
+exchange := func() ([]string, error) {
+if err := c.writeCommand(cmds...); err != nil {
+return nil, err
+}
+
+responses := make([]string, len(cmds))
+for i, cmd := range cmds {
+mark := c.received
+response, err := c.readWholeReply()
+if err != nil {
+return nil, err
+}
+responses[i] = response
+c.traffic.record(cmd, len(cmd)+len(c.terminator), c.received-mark)
+}
+return responses, nil
+}
+responses, err = exchange()
+if err != nil && c.retryRead(cmds, err, opts) {
+responses, err = exchange()
+}
+if err != nil {
+return nil, err
+}
+if c.latency != nil {
+c.latency.record(cmds, start)
+}
+
+return responses, nil
+}
+
+// setDeadline applies the call's deadline, from callOptions.effectiveDeadline,
+// to the connection and returns a func that clears it again. It fails
+// without touching the connection if the deadline has already passed. The
+// caller must hold c.mu.
+func (c *Client) setDeadline(deadline time.Time) (func(), error) {
+if deadline.IsZero() {
+return func() {}, nil
+}
+if err := checkDeadline(deadline); err != nil {
+return nil, err
+}
+
+c.conn.SetDeadline(deadline)
+return func() { c.conn.SetDeadline(time.Time{}) }, nil
+}
+
+// resync makes sure the connection is usable, resetting it if it is out of
+// sync and AutoReset is enabled. A reset does not wait out
+// Config.ReconnectBackoff past the call's deadline, if it has one. A
+// connection the client closed for being idle did not fail, so it is
+// redialed at the same endpoint rather than failed over. The caller must
+// hold c.mu.
+func (c *Client) resync(deadline time.Time) error {
+if err := c.breakerAllow(); err != nil {
+return err
+}
+if !c.desynced {
+return nil
+}
+if c.idleClosed && !c.config.AutoReset {
+c.breaker.inCall = false
+return ErrIdleClosed
+}
+if !c.config.AutoReset {
+c.breaker.inCall = false
+return ErrDesynced
+}
+
+start := c.endpoint + 1
+if c.idleClosed {
+start = c.endpoint
+}
+if err := c.backoffRedial(start, deadline); err != nil {
+c.breakerFail()
+return err
+}
+return nil
+}
+
+// writeCommand writes command lines and flushes them together. The caller must hold c.mu.
+func (c *Client) writeCommand(cmds ...string) error {
+for _, cmd := range cmds {
+if err := c.checkAllowed(cmd); err != nil {
+return err
+}
+if c.subscribed && !subscribeModeVerbs[commandVerb(cmd)] {
+return ErrInSubscribeMode
+}
+}
+if err := c.checkBalanced(); err != nil {
+return err
+}
+c.tuneBuffers()
+c.outstanding += len(cmds)
+
+c.recentMu.Lock()
+for _, cmd := range cmds {
+c.recent.add(commandVerb(cmd))
+}
+c.recentMu.Unlock()
+
+// Write commands
+written := 0
+for _, cmd := range cmds {
+written += len(cmd) + len(c.terminator)
+if c.cache != nil {
+c.cache.observe(cmd)
+}
+if subscribeVerbs[commandVerb(cmd)] {
+c.subscribed = true
+}
+if _, err := c.writer.WriteString(cmd + c.terminator); err != nil {
+c.markBroken(err)
+return fmt.Errorf("write error: %w", err)
+}
+}
+
+if err := c.writer.Flush(); err != nil {
+c.markBroken(err)
+return fmt.Errorf("flush error: %w", err)
+}
+c.observeSizes(0, written)
+
+return nil
+}
+
+// commandSet normalizes command filter entries for checkAllowed
+func commandSet(cmds []string) map[string]bool {
+set := make(map[string]bool, len(cmds))
+for _, cmd := range cmds {
+set[strings.ToUpper(strings.Join(strings.Fields(cmd), " "))] = true
+}
+return set
+}
+
+// checkAllowed applies the command filters to cmd. QUIT is always allowed
+// so that Close works, and so is the handshake of a new connection.
+func (c *Client) checkAllowed(cmd string) error {
+if c.handshaking {
+return nil
+}
+return checkCommand(c.allowed, c.disallowed, cmd)
+}
+
+// checkCommand applies the command filters built from
+// Config.AllowedCommands and Config.DisallowedCommands to cmd
+func checkCommand(allowed, disallowed map[string]bool, cmd string) error {
+fields := strings.Fields(strings.ToUpper(cmd))
+if len(fields) == 0 || fields[0] == "QUIT" {
+return nil
+}
+
+verb := fields[0]
+sub := verb
+if len(fields) > 1 {
+sub += " " + fields[1]
+}
+if disallowed[verb] || disallowed[sub] || (allowed != nil && !allowed[verb] && !allowed[sub]) {
+return fmt.Errorf("%w: %s", ErrCommandNotAllowed, verb)
+}
+return nil
+}
+
+// checkBalanced verifies, when Config.StrictReplies is set, that every
+// reply to earlier commands has been read and that nothing else arrived.
+// A violation marks the connection broken. The caller must hold c.mu.
+func (c *Client) checkBalanced() error {
+if !c.config.StrictReplies {
+return nil
+}
+
+var err error
+switch {
+case c.outstanding != 0:
+err = fmt.Errorf("%w: %d replies left unread", ErrUnbalanced, c.outstanding)
+case len(c.framedLines) > 0:
+err = fmt.Errorf("%w: %d reply lines left unread", ErrUnbalanced, len(c.framedLines))
+case c.reader.Buffered() > 0:
+err = fmt.Errorf("%w: %d bytes received with no command pending", ErrUnbalanced, c.reader.Buffered())
+}
+if err != nil {
+c.markBroken(err)
+}
+return err
+}
+
+// readReply reads a single response line. The caller must hold c.mu.
+func (c *Client) readReply() (string, error) {
+if c.config.StrictReplies && c.outstanding <= 0 {
+err := fmt.Errorf("%w: reading a reply with no command pending", ErrUnbalanced)
+c.markBroken(err)
+return "", err
+}
+
+response, err := c.readLine()
+if err == nil {
+c.outstanding--
+}
+return response, err
+}
+
+// readWholeReply is readReply for a reply of any type. An array reply
+// comes back with its elements after its "*<n>" header, one per line, so
+// none are left to be read as the next reply. The caller must hold c.mu.
+func (c *Client) readWholeReply() (string, error) {
+response, err := c.readReply()
+if err != nil {
+return "", err
+}
+typ := c.framedReply.Type
+if !c.framed {
+typ = lineReplyType(response)
+}
+if typ != ReplyArray {
+return response, nil
+}
+
+n, _ := strconv.Atoi(response[1:])
+lines := make([]string, 1, 1+min(n, 1024))
+lines[0] = response
+for i := 0; i < n; i++ {
+line, err := c.readLine()
+if err != nil {
+return "", err
+}
+lines = append(lines, line)
+}
+return strings.Join(lines, "\n"), nil
+}
+
+// readLine reads one line of a reply. The caller must hold c.mu.
+func (c *Client) readLine() (string, error) {
+if c.framed {
+return c.readFramedLine()
+}
+
+// Read response up to the last byte of the terminator; TrimSpace also
+// drops the "\r" of CRLF replies when the terminator is a plain "\n".
+response, err := c.reader.ReadString(c.terminator[len(c.terminator)-1])
+c.received += len(response)
+c.observeSizes(len(response), 0)
+if err != nil {
+c.markBroken(err)
+return "", fmt.Errorf("read error: %w", err)
+}
+
+return strings.TrimSpace(strings.TrimSuffix(response, c.terminator)), nil
+}
+
+// Set stores a key-value pair with a TTL in seconds, zero for none. It
+// fails if given WithTTL, WithNX or WithKeepTTL, which only SetWith honors.
+func (c *Client) Set(key, value string, ttl int, opts ...CallOption) error {
+if o := newCallOptions(opts); o.ttl != 0 || o.nx || o.keepTTL {
+return errors.New("Set: WithTTL, WithNX and WithKeepTTL need SetWith")
+}
+return c.set(key, value, c.jitterTTL(ttl), opts)
+}
+
+// set is Set without Config.TTLJitter applied
+func (c *Client) set(key, value string, ttl int, opts []CallOption) error {
+cmd := setCommand(key, value, ttl)
+var response string
+var err error
+if newCallOptions(opts).verify {
+response, err = c.setVerified(cmd, key, value, opts)
+} else {
+response, err = c.sendExpect(cmd, ReplyStatus, opts)
+}
+if err != nil {
+return err
+}
+
+if response != "OK" {
+return fmt.Errorf("unexpected response: %s", response)
+}
+
+return nil
+}
+
+// SetWith stores a key-value pair with the flags given by WithTTL, WithNX
+// and WithKeepTTL, and reports whether it was stored, which is only false
+// under WithNX when the key already existed. WithVerify applies as for Set.
+func (c *Client) SetWith(key, value string, opts ...CallOption) (bool, error) {
+o := newCallOptions(opts)
+if o.ttl < 0 {
+return false, fmt.Errorf("SetWith: invalid TTL %s", o.ttl)
+}
+if o.ttl > 0 && o.keepTTL {
+return false, errors.New("SetWith: WithTTL and WithKeepTTL conflict")
 }
 
-// DefaultConfig returns default configuration
-func DefaultConfig() *Config {
-return &Config{
-Host:    "localhost",
-Port:    6379,
-Timeout: 5 * time.Second,
+seconds := int((o.ttl + time.Second - 1) / time.Second)
+cmd := setCommand(key, value, c.jitterTTL(seconds))
+if o.keepTTL {
+cmd += " KEEPTTL"
 }
+if o.nx {
+cmd += " NX"
 }
 
-// Connect creates a new connection to NubDB
-func Connect(config *Config) (*Client, error) {
-if config == nil {
-config = DefaultConfig()
+var response string
+var err error
+if o.verify {
+response, err = c.setVerified(cmd, key, value, opts)
+} else {
+want := ReplyStatus
+if o.nx {
+want = replyConditional
+}
+response, err = c.sendExpect(cmd, want, opts)
 }
-
-addr := fmt.Sprintf("%s:%d", config.Host, config.Port)
-conn, err := net.DialTimeout("tcp", addr, config.Timeout)
 if err != nil {
-return nil, fmt.Errorf("failed to connect: %w", err)
+return false, err
 }
 
-client := &Client{
-conn:   conn,
-reader: bufio.NewReader(conn),
-writer: bufio.NewWriter(conn),
-host:   config.Host,
-port:   config.Port,
+switch {
+case response == "OK":
+return true, nil
+case o.nx && (isNotFound(response) || response == "0"):
+return false, nil
 }
-
-return client, nil
+return false, fmt.Errorf("unexpected response: %s", response)
 }
 
-// sendCommand sends a command and returns the response
-func (c *Client) sendCommand(cmd string) (string, error) {
-// Write command
-_, err := c.writer.WriteString(cmd + "\n")
+// setVerified sends cmd, a SET of key to value, with a GET pipelined after
+// it for WithVerify and returns the SET's reply. The value read back is
+// only checked if the SET answered OK.
+func (c *Client) setVerified(cmd, key, value string, opts []CallOption) (string, error) {
+responses, err := c.sendCommands([]string{
+cmd,
+fmt.Sprintf("GET %s", key),
+}, opts...)
 if err != nil {
-return "", fmt.Errorf("write error: %w", err)
+return "", err
 }
 
-if err := c.writer.Flush(); err != nil {
-return "", fmt.Errorf("flush error: %w", err)
+for _, response := range responses {
+if err := parseServerError(response); err != nil {
+return "", err
+}
+}
+if responses[0] != "OK" {
+return responses[0], nil
+}
+if got, found := parseGetReply(responses[1]); !found || got != value {
+return "", fmt.Errorf("%w: %s read back as %s", ErrVerifyMismatch, key, responses[1])
+}
+
+return responses[0], nil
 }
 
-// Read response
-response, err := c.reader.ReadString('\n')
+// SetGet stores a key-value pair like Set and returns the value it
+// replaced, using SET's GET option; found is false if the key did not
+// exist. Plain Set never sends GET, so it is unaffected by how a server
+// answers this form.
+func (c *Client) SetGet(key, value string, ttl int, opts ...CallOption) (old string, found bool, err error) {
+response, err := c.sendCommand(setCommand(key, value, c.jitterTTL(ttl))+" GET", opts...)
 if err != nil {
-return "", fmt.Errorf("read error: %w", err)
+return "", false, err
+}
+
+// Values come back quoted, so a bare OK means the option was ignored
+if response == "OK" {
+return "", false, fmt.Errorf("SET GET unsupported: unexpected response %s", response)
+}
+old, found = parseGetReply(response)
+return old, found, nil
 }
 
-return strings.TrimSpace(response), nil
+// jitterTTL applies Config.TTLJitter to a TTL in seconds
+func (c *Client) jitterTTL(ttl int) int {
+if ttl <= 0 || c.config.TTLJitter == 0 {
+return ttl
+}
+spread := float64(ttl) * c.config.TTLJitter
+jittered := int(math.Round(float64(ttl) + spread*(2*jitterRand()-1)))
+return max(jittered, 1)
 }
 
-// Set stores a key-value pair
-func (c *Client) Set(key, value string, ttl int) error {
+// jitterRand returns a number in [0, 1); it is a variable so tests can make
+// TTL and reconnect jitter deterministic
+var jitterRand = rand.Float64
+
+func setCommand(key, value string, ttl int) string {
 cmd := fmt.Sprintf(`SET %s "%s"`, key, value)
 if ttl > 0 {
 cmd += fmt.Sprintf(" %d", ttl)
 }
+return cmd
+}
 
-response, err := c.sendCommand(cmd)
+// Get retrieves a value by key. A missing key yields "". Values are text:
+// one that is not valid UTF-8 is returned as is along with an error
+// matching ErrInvalidUTF8. Use GetBytes for binary values.
+func (c *Client) Get(key string, opts ...CallOption) (string, error) {
+value, err := c.getShared(key, opts)
+if err == nil && !utf8.ValidString(value) {
+return value, fmt.Errorf("%w: key %s", ErrInvalidUTF8, key)
+}
+return value, err
+}
+
+// getShared is Get without the UTF-8 check, sharing the request with
+// concurrent Gets of key if Config.CoalesceGets is set
+func (c *Client) getShared(key string, opts []CallOption) (string, error) {
+if c.flight != nil {
+deadline := newCallOptions(opts).effectiveDeadline()
+return c.flight.do(key, deadline, func() (string, error) { return c.get(key, opts) })
+}
+
+return c.get(key, opts)
+}
+
+func (c *Client) get(key string, opts []CallOption) (string, error) {
+value, _, err := c.lookup(key, opts)
+return value, err
+}
+
+// lookup retrieves a value by key, reporting whether the key exists
+func (c *Client) lookup(key string, opts []CallOption) (string, bool, error) {
+if c.cache == nil {
+return c.lookupServer(key, opts)
+}
+
+if value, found, ok := c.cache.get(key); ok {
+return value, found, nil
+}
+gen := c.cache.generation()
+value, found, err := c.lookupServer(key, opts)
+if err == nil {
+c.cache.put(key, value, found, gen)
+}
+return value, found, err
+}
+
+// lookupServer is lookup without the client-side cache
+func (c *Client) lookupServer(key string, opts []CallOption) (string, bool, error) {
+if c.config.SlidingTTL > 0 {
+return c.lookupSliding(key, opts)
+}
+return c.lookupPlain(key, opts)
+}
+
+// lookupPlain is lookupServer without SlidingTTL, for reads that must not
+// keep the key alive
+func (c *Client) lookupPlain(key string, opts []CallOption) (string, bool, error) {
+response, err := c.sendExpect(fmt.Sprintf("GET %s", key), ReplyBulk, opts)
 if err != nil {
-return err
+return "", false, err
 }
 
-if response != "OK" {
-return fmt.Errorf("unexpected response: %s", response)
+value, found := parseGetReply(response)
+return value, found, nil
 }
 
-return nil
+// lookupSliding is lookup with a conditional EXPIRE pipelined after the GET
+func (c *Client) lookupSliding(key string, opts []CallOption) (string, bool, error) {
+seconds := int64((c.config.SlidingTTL + time.Second - 1) / time.Second)
+responses, err := c.sendCommands([]string{
+fmt.Sprintf("GET %s", key),
+fmt.Sprintf("EXPIRE %s %d XX", key, seconds),
+}, opts...)
+if err != nil {
+return "", false, err
+}
+
+for _, response := range responses {
+if err := parseServerError(response); err != nil {
+return "", false, err
+}
+}
+
+value, found := parseGetReply(responses[0])
+return value, found, nil
+}
+
+// isNotFound reports whether response is one of the server's missing-key
+// sentinels. The server answers GET with "(nil)" and DELETE with
+// "(not found)"; both are treated alike wherever a key is looked up.
+func isNotFound(response string) bool {
+return response == "(nil)" || response == "(not found)"
+}
+
+// parseGetReply unquotes a GET reply, reporting false for a missing key
+func parseGetReply(response string) (string, bool) {
+if isNotFound(response) {
+return "", false
 }
 
-// Get retrieves a value by key
-func (c *Client) Get(key string) (string, error) {
-response, err := c.sendCommand(fmt.Sprintf("GET %s", key))
+// Remove quotes if present
+return strings.Trim(response, `"`), true
+}
+
+// GetAuto retrieves a value and decodes it if it is a JSON object or array,
+// returning map[string]interface{} or []interface{} with numbers as
+// json.Number. Every other value, including ones that would parse as JSON
+// scalars such as "42", "true" or "null", and malformed JSON, is returned
+// unchanged as a string. A missing key yields nil.
+func (c *Client) GetAuto(key string, opts ...CallOption) (interface{}, error) {
+value, found, err := c.lookup(key, opts)
+if err != nil || !found {
+return nil, err
+}
+
+trimmed := strings.TrimSpace(value)
+if trimmed == "" || (trimmed[0] != '{' && trimmed[0] != '[') {
+return value, nil
+}
+
+dec := json.NewDecoder(strings.NewReader(trimmed))
+dec.UseNumber()
+var decoded interface{}
+if err := dec.Decode(&decoded); err != nil || dec.More() {
+return value, nil
+}
+
+return decoded, nil
+}
+
+// GetRange returns the substring of the value at key between the byte
+// offsets start and end, both inclusive. Negative offsets count from the end
+// of the value, so -1 is the last byte. Ranges past the end are truncated
+// by the server and a missing key yields an empty string.
+func (c *Client) GetRange(key string, start, end int64, opts ...CallOption) (string, error) {
+response, err := c.sendCommand(fmt.Sprintf("GETRANGE %s %d %d", key, start, end), opts...)
 if err != nil {
 return "", err
 }
 
-if response == "(nil)" {
-return "", nil
+value, _ := parseGetReply(response)
+return value, nil
 }
 
-// Remove quotes if present
-response = strings.Trim(response, `"`)
-return response, nil
+// SetRange overwrites the value at key starting at byte offset, padding with
+// zero bytes if offset is past the end, and returns the new value length.
+// Unlike GetRange, offset must not be negative.
+func (c *Client) SetRange(key string, offset int64, value string, opts ...CallOption) (int64, error) {
+if offset < 0 {
+return 0, fmt.Errorf("negative offset %d", offset)
+}
+
+response, err := c.sendCommand(fmt.Sprintf(`SETRANGE %s %d "%s"`, key, offset, value), opts...)
+if err != nil {
+return 0, err
+}
+
+return parseIntReply(response)
+}
+
+// NoExpiry is the TTL reported for keys that never expire
+const NoExpiry time.Duration = -1
+
+// GetWithTTL retrieves a value and its remaining time to live in one round
+// trip. ttl is NoExpiry for keys without an expiry; found is false if the key
+// is missing (including when it expires between the GET and the TTL lookup).
+func (c *Client) GetWithTTL(key string, opts ...CallOption) (value string, ttl time.Duration, found bool, err error) {
+responses, err := c.sendCommands([]string{fmt.Sprintf("GET %s", key), fmt.Sprintf("TTL %s", key)}, opts...)
+if err != nil {
+return "", 0, false, err
+}
+
+for _, response := range responses {
+if err := parseServerError(response); err != nil {
+return "", 0, false, err
+}
+}
+
+value, found = parseGetReply(responses[0])
+if !found {
+return "", 0, false, nil
+}
+
+ttl, found, err = parseTTLReply(responses[1], time.Second)
+if err != nil || !found {
+return "", 0, false, err
+}
+
+return value, ttl, true, nil
+}
+
+// TTL returns the remaining time to live of key, NoExpiry if it has no
+// expiry, or ErrKeyNotFound if it does not exist
+func (c *Client) TTL(key string, opts ...CallOption) (time.Duration, error) {
+return c.ttl("TTL", key, time.Second, opts)
+}
+
+// PTTL is TTL with millisecond precision
+func (c *Client) PTTL(key string, opts ...CallOption) (time.Duration, error) {
+return c.ttl("PTTL", key, time.Millisecond, opts)
+}
+
+func (c *Client) ttl(verb, key string, unit time.Duration, opts []CallOption) (time.Duration, error) {
+response, err := c.sendCommand(fmt.Sprintf("%s %s", verb, key), opts...)
+if err != nil {
+return 0, err
+}
+
+ttl, found, err := parseTTLReply(response, unit)
+if err != nil {
+return 0, err
+}
+if !found {
+return 0, ErrKeyNotFound
+}
+return ttl, nil
+}
+
+// noExpiryReplies are the textual forms servers use for a key without an
+// expiry, lowercased and without parentheses
+var noExpiryReplies = map[string]bool{
+"no expiry": true, "no ttl": true, "none": true, "persistent": true, "never": true,
+}
+
+// parseTTLReply parses a TTL reply counting in unit. Besides the numeric
+// conventions, -2 for a missing key and -1 for no expiry, it accepts the
+// textual forms of older servers: the missing-key sentinels, the phrases in
+// noExpiryReplies and durations with their own unit, such as "1m30s".
+func parseTTLReply(response string, unit time.Duration) (time.Duration, bool, error) {
+if isNotFound(response) {
+return 0, false, nil
+}
+if noExpiryReplies[strings.ToLower(strings.Trim(response, "()"))] {
+return NoExpiry, true, nil
+}
+
+n, err := parseIntReply(response)
+if err != nil {
+d, durationErr := time.ParseDuration(response)
+if durationErr != nil || d < 0 {
+return 0, false, err
+}
+return d, true, nil
+}
+
+switch {
+case n == -2:
+return 0, false, nil
+case n == -1:
+return NoExpiry, true, nil
+case n < 0:
+return 0, false, fmt.Errorf("invalid response: %s", response)
+}
+
+return time.Duration(n) * unit, true, nil
 }
 
 // Delete removes a key
-func (c *Client) Delete(key string) error {
-response, err := c.sendCommand(fmt.Sprintf("DELETE %s", key))
+func (c *Client) Delete(key string, opts ...CallOption) error {
+response, err := c.sendCommand(fmt.Sprintf("DELETE %s", key), opts...)
 if err != nil {
 return err
 }
 
-if response != "OK" && response != "(not found)" {
+if response != "OK" && !isNotFound(response) {
 return fmt.Errorf("unexpected response: %s", response)
 }
 
@@ -129,83 +1671,367 @@ return nil
 }
 
 // Exists checks if a key exists
-func (c *Client) Exists(key string) (bool, error) {
-response, err := c.sendCommand(fmt.Sprintf("EXISTS %s", key))
+func (c *Client) Exists(key string, opts ...CallOption) (bool, error) {
+response, err := c.sendCommand(fmt.Sprintf("EXISTS %s", key), opts...)
 if err != nil {
 return false, err
 }
 
-return response == "1", nil
+return parseBoolReply(response)
 }
 
-// Incr increments a counter
-func (c *Client) Incr(key string) (int64, error) {
-response, err := c.sendCommand(fmt.Sprintf("INCR %s", key))
+// parseBoolReply reads a yes/no reply such as EXISTS's: "1"/"0" from NubDB,
+// "true"/"false" from some server variants, or a count where non-zero is yes
+func parseBoolReply(response string) (bool, error) {
+switch strings.ToLower(response) {
+case "1", "true":
+return true, nil
+case "0", "false":
+return false, nil
+}
+
+count, err := strconv.ParseInt(response, 10, 64)
+if err != nil || count < 0 {
+return false, fmt.Errorf("invalid response: %s", response)
+}
+return count > 0, nil
+}
+
+// ExistsMap checks many keys in one round trip and reports each key's
+// presence. Duplicate keys are queried once and appear once in the map.
+func (c *Client) ExistsMap(keys ...string) (map[string]bool, error) {
+result := make(map[string]bool, len(keys))
+unique := make([]string, 0, len(keys))
+cmds := make([]string, 0, len(keys))
+for _, key := range keys {
+if _, ok := result[key]; ok {
+continue
+}
+result[key] = false
+unique = append(unique, key)
+cmds = append(cmds, fmt.Sprintf("EXISTS %s", key))
+}
+
+if len(cmds) == 0 {
+return result, nil
+}
+
+responses, err := c.sendCommands(cmds)
 if err != nil {
-return 0, err
+return nil, err
 }
 
-value, err := strconv.ParseInt(response, 10, 64)
+for i, key := range unique {
+if err := parseServerError(responses[i]); err != nil {
+return nil, err
+}
+if result[key], err = parseBoolReply(responses[i]); err != nil {
+return nil, err
+}
+}
+
+return result, nil
+}
+
+// Incr increments a counter
+func (c *Client) Incr(key string, opts ...CallOption) (int64, error) {
+response, err := c.sendExpect(fmt.Sprintf("INCR %s", key), ReplyInteger, opts)
 if err != nil {
-return 0, fmt.Errorf("invalid response: %s", response)
+return 0, err
 }
 
-return value, nil
+return parseIntReply(response)
 }
 
 // Decr decrements a counter
-func (c *Client) Decr(key string) (int64, error) {
-response, err := c.sendCommand(fmt.Sprintf("DECR %s", key))
+func (c *Client) Decr(key string, opts ...CallOption) (int64, error) {
+response, err := c.sendExpect(fmt.Sprintf("DECR %s", key), ReplyInteger, opts)
 if err != nil {
 return 0, err
 }
 
-value, err := strconv.ParseInt(response, 10, 64)
+return parseIntReply(response)
+}
+
+// AllowN is a fixed-window rate limiter: it records n events against key and
+// reports whether the window's total stays within limit, along with how many
+// events remain. The window starts with the first event and lasts window
+// (rounded up to whole seconds). The increment and the expiry are sent in
+// one round trip, and the expiry is only set if the key has none, so
+// concurrent callers share a single window.
+func (c *Client) AllowN(key string, limit int, window time.Duration, n int) (allowed bool, remaining int, err error) {
+seconds := int64((window + time.Second - 1) / time.Second)
+if seconds < 1 {
+seconds = 1
+}
+
+responses, err := c.sendCommands([]string{
+fmt.Sprintf("INCRBY %s %d", key, n),
+fmt.Sprintf("EXPIRE %s %d NX", key, seconds),
+})
 if err != nil {
-return 0, fmt.Errorf("invalid response: %s", response)
+return false, 0, err
 }
 
-return value, nil
+for _, response := range responses {
+if err := parseServerError(response); err != nil {
+return false, 0, err
+}
+}
+
+count, err := parseIntReply(responses[0])
+if err != nil {
+return false, 0, err
+}
+
+remaining = limit - int(count)
+if remaining < 0 {
+remaining = 0
+}
+return count <= int64(limit), remaining, nil
 }
 
 // Size returns the number of keys
-func (c *Client) Size() (int64, error) {
-response, err := c.sendCommand("SIZE")
+func (c *Client) Size(opts ...CallOption) (int64, error) {
+response, err := c.sendExpect("SIZE", ReplyInteger, opts)
 if err != nil {
 return 0, err
 }
 
-// Parse "N keys" format
-parts := strings.Fields(response)
-if len(parts) > 0 {
-value, err := strconv.ParseInt(parts[0], 10, 64)
+return parseSizeReply(response)
+}
+
+// EstimatedSize returns the number of keys from DBSIZE, a constant-time
+// counter for dashboards that refresh often, where Size may walk the
+// keyspace. The count may include keys that have expired but not yet been
+// reclaimed, so it can run above Size but never below it at the same
+// instant. A server without DBSIZE is answered with Size, exactly, and
+// remembered for the life of the client.
+func (c *Client) EstimatedSize(opts ...CallOption) (int64, error) {
+if c.noDBSize.Load() {
+return c.Size(opts...)
+}
+
+response, err := c.sendExpect("DBSIZE", ReplyInteger, opts)
+if isUnknownCommand(err) {
+c.noDBSize.Store(true)
+return c.Size(opts...)
+}
+if err != nil {
+return 0, err
+}
+
+return parseSizeReply(response)
+}
+
+// parseIntReply parses an integer reply such as the result of INCR
+func parseIntReply(response string) (int64, error) {
+value, err := strconv.ParseInt(response, 10, 64)
 if err != nil {
 return 0, fmt.Errorf("invalid response: %s", response)
 }
+
 return value, nil
 }
 
+// parseSizeReply parses the "N keys" reply of SIZE
+func parseSizeReply(response string) (int64, error) {
+parts := strings.Fields(response)
+if len(parts) == 0 || len(parts) > 2 || (len(parts) == 2 && parts[1] != "keys") {
 return 0, fmt.Errorf("invalid response: %s", response)
 }
 
-// Clear deletes all keys
-func (c *Client) Clear() error {
-response, err := c.sendCommand("CLEAR")
+value, err := strconv.ParseInt(parts[0], 10, 64)
+if err != nil || value < 0 {
+return 0, fmt.Errorf("invalid response: %s", response)
+}
+
+return value, nil
+}
+
+// MemoryUsage returns the number of bytes the server uses to store key.
+// It returns ErrKeyNotFound if the key does not exist.
+func (c *Client) MemoryUsage(key string, opts ...CallOption) (int64, error) {
+response, err := c.sendCommand(fmt.Sprintf("MEMORY USAGE %s", key), opts...)
 if err != nil {
+return 0, err
+}
+
+if isNotFound(response) {
+return 0, ErrKeyNotFound
+}
+
+return parseIntReply(response)
+}
+
+// Clear deletes all keys
+func (c *Client) Clear(opts ...CallOption) error {
+_, err := c.ClearCount(opts...)
 return err
 }
 
-if response != "OK" {
-return fmt.Errorf("unexpected response: %s", response)
+// ClearConfirm is Clear behind a deliberate speed bump for production
+// tooling: token must be the address of the server to wipe, host:port as
+// configured (Host and Port, or the current entry of Endpoints). Any other
+// token fails with ErrConfirmationRequired before anything is sent, so a
+// tool pointed at the wrong server stops short. Clear stays unguarded for
+// tests.
+func (c *Client) ClearConfirm(token string, opts ...CallOption) error {
+c.lock()
+addr := c.config.addrs()[c.endpoint]
+c.unlock()
+
+if token != addr {
+return ErrConfirmationRequired
+}
+return c.Clear(opts...)
 }
 
-return nil
+// ClearCount deletes all keys and returns how many were deleted.
+// If the server does not report a count (a plain "OK" reply) it returns -1.
+func (c *Client) ClearCount(opts ...CallOption) (int64, error) {
+response, err := c.sendCommand("CLEAR", opts...)
+if err != nil {
+return 0, err
+}
+
+return parseClearReply(response)
+}
+
+// parseClearReply accepts "OK", "OK <n>", "<n>" and "<n> keys"
+func parseClearReply(response string) (int64, error) {
+if response == "OK" {
+return -1, nil
+}
+
+parts := strings.Fields(strings.TrimPrefix(response, "OK"))
+if len(parts) == 0 || len(parts) > 2 || (len(parts) == 2 && parts[1] != "keys") {
+return 0, fmt.Errorf("unexpected response: %s", response)
+}
+
+value, err := strconv.ParseInt(parts[0], 10, 64)
+if err != nil || value < 0 {
+return 0, fmt.Errorf("unexpected response: %s", response)
+}
+
+return value, nil
+}
+
+// chunkHeaderPrefix marks a value written by SetLarge. The full header is
+// "<prefix><chunk count>:<total length>" and is stored under the original key,
+// while the chunks themselves live under chunkKey(key, i).
+const chunkHeaderPrefix = "nubdb-chunked:"
+
+func chunkKey(key string, i int) string {
+return fmt.Sprintf("%s:chunk:%d", key, i)
+}
+
+// parseChunkHeader parses a header written by SetLarge
+func parseChunkHeader(header string) (count, total int, err error) {
+fields := strings.Split(strings.TrimPrefix(header, chunkHeaderPrefix), ":")
+if len(fields) == 2 {
+count, err = strconv.Atoi(fields[0])
+if err == nil {
+total, err = strconv.Atoi(fields[1])
+}
+}
+if len(fields) != 2 || err != nil || count <= 0 || total < 0 {
+return 0, 0, fmt.Errorf("invalid chunk header: %s", header)
+}
+
+return count, total, nil
+}
+
+// SetLarge stores a value that may exceed the single-command limit by
+// splitting it into chunks of at most ChunkSize bytes. Values that fit in
+// one chunk are stored with a plain Set. Use GetLarge to read it back.
+func (c *Client) SetLarge(key, value string, ttl int, opts ...CallOption) error {
+// Jitter once so the chunks and header expire together
+ttl = c.jitterTTL(ttl)
+if len(value) <= c.chunkSize {
+return c.set(key, value, ttl, opts)
+}
+
+count := (len(value) + c.chunkSize - 1) / c.chunkSize
+for i := 0; i < count; i++ {
+start := i * c.chunkSize
+end := start + c.chunkSize
+if end > len(value) {
+end = len(value)
+}
+if err := c.set(chunkKey(key, i), value[start:end], ttl, opts); err != nil {
+return fmt.Errorf("chunk %d: %w", i, err)
+}
+}
+
+// Write the header last so readers never see it before all chunks exist
+header := fmt.Sprintf("%s%d:%d", chunkHeaderPrefix, count, len(value))
+return c.set(key, header, ttl, opts)
+}
+
+// GetLarge retrieves a value written by SetLarge, reassembling its chunks.
+// Values stored with a plain Set are returned unchanged.
+func (c *Client) GetLarge(key string, opts ...CallOption) (string, error) {
+value, err := c.getShared(key, opts)
+if err != nil || !strings.HasPrefix(value, chunkHeaderPrefix) {
+return value, err
+}
+
+count, total, err := parseChunkHeader(value)
+if err != nil {
+return "", err
+}
+
+var sb strings.Builder
+// total comes from the server, so only trust it as a hint when it is
+// consistent with the chunk count
+if total/c.chunkSize < count {
+sb.Grow(total)
+}
+for i := 0; i < count; i++ {
+chunk, err := c.getShared(chunkKey(key, i), opts)
+if err != nil {
+return "", fmt.Errorf("chunk %d: %w", i, err)
+}
+sb.WriteString(chunk)
+}
+
+if sb.Len() != total {
+return "", fmt.Errorf("chunked value length mismatch: got %d, want %d", sb.Len(), total)
+}
+
+return sb.String(), nil
+}
+
+// interrupt closes the connection without waiting for a command in
+// progress, which then fails and leaves the client out of sync
+func (c *Client) interrupt() {
+if conn, ok := c.live.Load().(net.Conn); ok {
+conn.Close()
+}
 }
 
 // Close closes the connection
 func (c *Client) Close() error {
+c.lock()
+defer c.unlock()
+
+if c.idleTimer != nil {
+c.idleTimer.Stop()
+if c.idleClosed {
+return nil
+}
+// Keep a pending closeIdle from closing the connection again
+c.idleClosed = true
+}
+
 if c.conn != nil {
-c.sendCommand("QUIT")
+if !c.desynced && c.writeCommand("QUIT") == nil {
+c.readReply()
+}
+if !c.desynced {
+c.notify(c.config.OnDisconnect, nil)
+}
 return c.conn.Close()
 }
 return nil
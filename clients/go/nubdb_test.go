@@ -0,0 +1,1714 @@
+package nubdb
+
+import (
+"bufio"
+"context"
+"encoding/json"
+"errors"
+"fmt"
+"math/rand"
+"net"
+"os"
+"strconv"
+"strings"
+"sync"
+"testing"
+"time"
+)
+
+// startFakeServer listens on a random local port and answers every command
+// line with the reply returned by handle. It returns a config pointing at it.
+func startFakeServer(t *testing.T, handle func(line string) string) *Config {
+t.Helper()
+return startFakeServerTerm(t, "\n", handle)
+}
+
+// startFakeServerTerm is startFakeServer with replies ending in terminator
+func startFakeServerTerm(t *testing.T, terminator string, handle func(line string) string) *Config {
+t.Helper()
+
+ln, err := net.Listen("tcp", "127.0.0.1:0")
+if err != nil {
+t.Fatalf("listen: %v", err)
+}
+t.Cleanup(func() { ln.Close() })
+
+go func() {
+for {
+conn, err := ln.Accept()
+if err != nil {
+return
+}
+go func() {
+defer conn.Close()
+reader := bufio.NewReader(conn)
+for {
+line, err := reader.ReadString('\n')
+if err != nil {
+return
+}
+line = strings.TrimSuffix(line, terminator)
+if line == "QUIT" {
+conn.Write([]byte("Goodbye" + terminator))
+return
+}
+if _, err := conn.Write([]byte(handle(line) + terminator)); err != nil {
+return
+}
+}
+}()
+}
+}()
+
+addr := ln.Addr().(*net.TCPAddr)
+config := DefaultConfig()
+config.Host = "127.0.0.1"
+config.Port = addr.Port
+config.Terminator = terminator
+return config
+}
+
+// memStore is a minimal in-memory implementation of the NubDB line protocol
+type memStore struct {
+mu   sync.Mutex
+data map[string]string
+ttl  map[string]int64 // seconds, for keys with an expiry; never counts down
+}
+
+func newMemStore() *memStore {
+return &memStore{data: make(map[string]string), ttl: make(map[string]int64)}
+}
+
+func (m *memStore) handle(line string) string {
+m.mu.Lock()
+defer m.mu.Unlock()
+
+parts := strings.SplitN(line, " ", 3)
+switch parts[0] {
+case "SET":
+if len(parts) < 3 {
+return "ERROR: SET requires key and value"
+}
+rest := parts[2]
+end := strings.LastIndex(rest, `"`)
+value := rest[strings.Index(rest, `"`)+1 : end]
+m.data[parts[1]] = value
+delete(m.ttl, parts[1])
+if ttl, err := strconv.ParseInt(strings.TrimSpace(rest[end+1:]), 10, 64); err == nil && ttl > 0 {
+m.ttl[parts[1]] = ttl
+}
+return "OK"
+case "GET":
+value, ok := m.data[parts[1]]
+if !ok {
+return "(nil)"
+}
+return `"` + value + `"`
+case "DELETE":
+if _, ok := m.data[parts[1]]; !ok {
+return "(not found)"
+}
+delete(m.data, parts[1])
+delete(m.ttl, parts[1])
+return "OK"
+case "EXISTS":
+if _, ok := m.data[parts[1]]; ok {
+return "1"
+}
+return "0"
+case "INCR", "DECR":
+n, _ := strconv.ParseInt(m.data[parts[1]], 10, 64)
+if parts[0] == "INCR" {
+n++
+} else {
+n--
+}
+m.data[parts[1]] = strconv.FormatInt(n, 10)
+return m.data[parts[1]]
+case "INCRBY":
+fields := strings.Fields(line)
+n, _ := strconv.ParseInt(m.data[fields[1]], 10, 64)
+by, _ := strconv.ParseInt(fields[2], 10, 64)
+m.data[fields[1]] = strconv.FormatInt(n+by, 10)
+return m.data[fields[1]]
+case "EXPIRE":
+fields := strings.Fields(line)
+if _, ok := m.data[fields[1]]; !ok {
+return "0"
+}
+_, hasTTL := m.ttl[fields[1]]
+if len(fields) > 3 && ((fields[3] == "NX" && hasTTL) || (fields[3] == "XX" && !hasTTL)) {
+return "0"
+}
+m.ttl[fields[1]], _ = strconv.ParseInt(fields[2], 10, 64)
+return "1"
+case "TTL":
+if _, ok := m.data[parts[1]]; !ok {
+return "-2"
+}
+if ttl, ok := m.ttl[parts[1]]; ok {
+return strconv.FormatInt(ttl, 10)
+}
+return "-1"
+case "SETNX":
+if _, ok := m.data[parts[1]]; ok {
+return "0"
+}
+m.data[parts[1]] = strings.Trim(parts[2], `"`)
+return "1"
+case "CAS":
+args := strings.SplitN(parts[2], `" "`, 2)
+current, ok := m.data[parts[1]]
+if !ok {
+return "(nil)"
+}
+if current != strings.TrimPrefix(args[0], `"`) {
+return "(conflict)"
+}
+m.data[parts[1]] = strings.TrimSuffix(args[1], `"`)
+return "OK"
+case "SIZE":
+return strconv.Itoa(len(m.data)) + " keys"
+case "CLEAR":
+m.data = make(map[string]string)
+m.ttl = make(map[string]int64)
+return "OK"
+case "GETRANGE":
+var start, end int
+fmt.Sscanf(parts[2], "%d %d", &start, &end)
+value := m.data[parts[1]]
+if start < 0 {
+start += len(value)
+}
+if end < 0 {
+end += len(value)
+}
+if start < 0 {
+start = 0
+}
+if end >= len(value) {
+end = len(value) - 1
+}
+if start > end {
+return `""`
+}
+return `"` + value[start:end+1] + `"`
+case "SETRANGE":
+rest := strings.SplitN(parts[2], " ", 2)
+offset, _ := strconv.Atoi(rest[0])
+patch := strings.Trim(rest[1], `"`)
+value := m.data[parts[1]]
+for len(value) < offset {
+value += "\x00"
+}
+if offset+len(patch) < len(value) {
+value = value[:offset] + patch + value[offset+len(patch):]
+} else {
+value = value[:offset] + patch
+}
+m.data[parts[1]] = value
+return strconv.Itoa(len(value))
+}
+return "ERROR: Unknown command"
+}
+
+func connectFake(t *testing.T, handle func(line string) string) *Client {
+t.Helper()
+
+client, err := Connect(startFakeServer(t, handle))
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+t.Cleanup(func() { client.Close() })
+return client
+}
+
+func TestSetLargeRoundTrip(t *testing.T) {
+store := newMemStore()
+client := connectFake(t, store.handle)
+
+value := strings.Repeat("0123456789abcdef", 5*1024*1024/16)
+if err := client.SetLarge("blob", value, 0); err != nil {
+t.Fatalf("SetLarge: %v", err)
+}
+
+if got := len(store.data); got < 2 {
+t.Fatalf("expected value to be chunked, store has %d keys", got)
+}
+
+got, err := client.GetLarge("blob")
+if err != nil {
+t.Fatalf("GetLarge: %v", err)
+}
+if got != value {
+t.Fatalf("round trip mismatch: got %d bytes, want %d", len(got), len(value))
+}
+}
+
+func TestSetLargeSmallValue(t *testing.T) {
+store := newMemStore()
+client := connectFake(t, store.handle)
+
+if err := client.SetLarge("small", "hello", 0); err != nil {
+t.Fatalf("SetLarge: %v", err)
+}
+if store.data["small"] != "hello" {
+t.Fatalf("small value should be stored directly, got %q", store.data["small"])
+}
+
+got, err := client.GetLarge("small")
+if err != nil || got != "hello" {
+t.Fatalf("GetLarge = %q, %v", got, err)
+}
+}
+
+func TestSetGet(t *testing.T) {
+store := newMemStore()
+var sent []string
+client := connectFake(t, func(line string) string {
+sent = append(sent, line)
+if rest, ok := strings.CutSuffix(line, " GET"); ok {
+key := strings.Fields(rest)[1]
+old := store.handle("GET " + key)
+store.handle(rest)
+return old
+}
+return store.handle(line)
+})
+
+old, found, err := client.SetGet("k", "first", 0)
+if err != nil || found || old != "" {
+t.Errorf("SetGet of a new key = %q, %v, %v", old, found, err)
+}
+old, found, err = client.SetGet("k", "second", 60)
+if err != nil || !found || old != "first" {
+t.Errorf("SetGet of an existing key = %q, %v, %v", old, found, err)
+}
+if sent[1] != `SET k "second" 60 GET` {
+t.Errorf("SetGet sent %q", sent[1])
+}
+if store.data["k"] != "second" {
+t.Errorf("store has %q after SetGet", store.data["k"])
+}
+
+// Plain Set keeps sending the plain form
+sent = nil
+if err := client.Set("k", "third", 0); err != nil || sent[0] != `SET k "third"` {
+t.Errorf("Set = %v, sent %q", err, sent)
+}
+}
+
+func TestSetGetIgnored(t *testing.T) {
+client := connectFake(t, func(string) string { return "OK" })
+if _, _, err := client.SetGet("k", "v", 0); err == nil {
+t.Error("SetGet should fail when the server ignores GET and answers OK")
+}
+}
+
+func TestTTLJitter(t *testing.T) {
+var ttls []int
+config := startFakeServer(t, func(line string) string {
+fields := strings.Fields(line)
+ttl, _ := strconv.Atoi(fields[len(fields)-1])
+ttls = append(ttls, ttl)
+return "OK"
+})
+config.TTLJitter = 0.1
+config.ChunkSize = 4
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+defer func(orig func() float64) { jitterRand = orig }(jitterRand)
+for _, tt := range []struct {
+rand float64
+want int
+}{
+{0, 90},
+{0.25, 95},
+{0.5, 100},
+{0.999, 110},
+} {
+jitterRand = func() float64 { return tt.rand }
+ttls = nil
+client.Set("k", "v", 100)
+if len(ttls) != 1 || ttls[0] != tt.want {
+t.Errorf("rand %g: sent TTL %v, want %d", tt.rand, ttls, tt.want)
+}
+}
+
+// SetLarge jitters once so its chunks expire with the header
+calls := 0
+jitterRand = func() float64 { calls++; return float64(calls%2) * 0.9 }
+ttls = nil
+client.SetLarge("big", "0123456789", 100)
+for _, ttl := range ttls {
+if ttl != ttls[0] {
+t.Fatalf("SetLarge sent TTLs %v, want one jittered TTL", ttls)
+}
+}
+
+// Short TTLs stay positive and no expiry stays no expiry
+jitterRand = func() float64 { return 0 }
+config.TTLJitter = 0.9
+short, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer short.Close()
+ttls = nil
+short.Set("k", "v", 1)
+if ttls[0] != 1 {
+t.Errorf("TTL 1 jittered to %d", ttls[0])
+}
+ttls = nil
+short.Set("k", "v", 0)
+if ttls[0] != 0 {
+t.Errorf("no expiry jittered to TTL %d", ttls[0])
+}
+
+// With the real source every TTL lands in range
+jitterRand = rand.Float64
+for i := 0; i < 100; i++ {
+ttls = nil
+client.Set("k", "v", 1000)
+if ttls[0] < 900 || ttls[0] > 1100 {
+t.Fatalf("TTL 1000 jittered to %d, outside [900, 1100]", ttls[0])
+}
+}
+}
+
+func TestClearCount(t *testing.T) {
+tests := []struct {
+reply string
+want  int64
+}{
+{"OK", -1},
+{"OK 42", 42},
+{"7 keys", 7},
+{"3", 3},
+}
+
+for _, tt := range tests {
+client := connectFake(t, func(string) string { return tt.reply })
+
+got, err := client.ClearCount()
+if err != nil {
+t.Fatalf("ClearCount(%q): %v", tt.reply, err)
+}
+if got != tt.want {
+t.Errorf("ClearCount(%q) = %d, want %d", tt.reply, got, tt.want)
+}
+if err := client.Clear(); err != nil {
+t.Errorf("Clear(%q): %v", tt.reply, err)
+}
+}
+}
+
+func TestClearConfirm(t *testing.T) {
+store := newMemStore()
+var sent int
+config := startFakeServer(t, func(line string) string {
+sent++
+return store.handle(line)
+})
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+addr := net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+for _, token := range []string{"", "yes", "127.0.0.1:1", strings.ToUpper(addr) + " "} {
+store.data["k"] = "v"
+if err := client.ClearConfirm(token); !errors.Is(err, ErrConfirmationRequired) {
+t.Errorf("ClearConfirm(%q) = %v, want ErrConfirmationRequired", token, err)
+}
+}
+if sent != 0 || len(store.data) != 1 {
+t.Fatalf("a refused ClearConfirm reached the server: %d commands, store %v", sent, store.data)
+}
+
+if err := client.ClearConfirm(addr); err != nil {
+t.Fatalf("ClearConfirm(%q): %v", addr, err)
+}
+if len(store.data) != 0 {
+t.Errorf("store still has %v", store.data)
+}
+}
+
+func TestClearCountInvalidReply(t *testing.T) {
+client := connectFake(t, func(string) string { return "ERROR: boom" })
+
+if _, err := client.ClearCount(); err == nil {
+t.Fatal("expected error for error reply")
+}
+}
+
+func TestTerminators(t *testing.T) {
+for _, terminator := range []string{"\n", "\r\n"} {
+var mu sync.Mutex
+var lines []string
+store := newMemStore()
+config := startFakeServerTerm(t, terminator, func(line string) string {
+mu.Lock()
+lines = append(lines, line)
+mu.Unlock()
+return store.handle(line)
+})
+
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+
+if err := client.Set("greeting", "hello", 0); err != nil {
+t.Fatalf("%q: Set: %v", terminator, err)
+}
+got, err := client.Get("greeting")
+if err != nil || got != "hello" {
+t.Fatalf("%q: Get = %q, %v", terminator, got, err)
+}
+client.Close()
+
+mu.Lock()
+for _, line := range lines {
+if strings.ContainsAny(line, "\r\n") {
+t.Errorf("%q: server saw unterminated line %q", terminator, line)
+}
+}
+mu.Unlock()
+}
+}
+
+func TestCRLFRepliesWithDefaultTerminator(t *testing.T) {
+config := startFakeServerTerm(t, "\r\n", func(string) string { return "OK" })
+config.Terminator = ""
+
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+if err := client.Set("k", "v", 0); err != nil {
+t.Fatalf("Set with CRLF reply: %v", err)
+}
+}
+
+// FuzzParseReplies feeds arbitrary server replies to the response parsers,
+// which must return errors rather than panic on malformed input.
+func FuzzParseReplies(f *testing.F) {
+for _, seed := range []string{"OK", "(nil)", `"value"`, `"`, "42", "-1", "10 keys", "keys", "", "OK 3", chunkHeaderPrefix + "2:10"} {
+f.Add(seed)
+}
+
+f.Fuzz(func(t *testing.T, response string) {
+parseGetReply(response)
+parseIntReply(response)
+if n, err := parseSizeReply(response); err == nil && n < 0 {
+t.Errorf("parseSizeReply(%q) = %d", response, n)
+}
+if n, err := parseClearReply(response); err == nil && n < -1 {
+t.Errorf("parseClearReply(%q) = %d", response, n)
+}
+if count, total, err := parseChunkHeader(response); err == nil && (count <= 0 || total < 0) {
+t.Errorf("parseChunkHeader(%q) = %d, %d", response, count, total)
+}
+})
+}
+
+func TestGetLargeHugeHeader(t *testing.T) {
+store := newMemStore()
+store.data["blob"] = chunkHeaderPrefix + "1:9223372036854775807"
+store.data[chunkKey("blob", 0)] = "abc"
+client := connectFake(t, store.handle)
+
+if _, err := client.GetLarge("blob"); err == nil {
+t.Fatal("expected length mismatch error")
+}
+}
+
+func TestMemoryUsage(t *testing.T) {
+client := connectFake(t, func(line string) string {
+switch line {
+case "MEMORY USAGE present":
+return "56"
+case "MEMORY USAGE missing":
+return "(nil)"
+}
+return "ERROR: Unknown command"
+})
+
+n, err := client.MemoryUsage("present")
+if err != nil || n != 56 {
+t.Fatalf("MemoryUsage(present) = %d, %v", n, err)
+}
+
+if _, err := client.MemoryUsage("missing"); !errors.Is(err, ErrKeyNotFound) {
+t.Fatalf("MemoryUsage(missing) error = %v, want ErrKeyNotFound", err)
+}
+}
+
+func TestEstimatedSize(t *testing.T) {
+var sent []string
+client := connectFake(t, func(line string) string {
+sent = append(sent, line)
+switch line {
+case "DBSIZE":
+return "1042"
+case "SIZE":
+return "1000 keys"
+}
+return "ERROR: Unknown command"
+})
+if n, err := client.EstimatedSize(); err != nil || n != 1042 {
+t.Errorf("EstimatedSize = %d, %v, want 1042", n, err)
+}
+if len(sent) != 1 || sent[0] != "DBSIZE" {
+t.Errorf("sent %q, want DBSIZE alone", sent)
+}
+}
+
+func TestEstimatedSizeFallback(t *testing.T) {
+store := newMemStore()
+store.data["a"], store.data["b"] = "1", "2"
+var sent []string
+client := connectFake(t, func(line string) string {
+sent = append(sent, line)
+return store.handle(line)
+})
+
+for i := 0; i < 2; i++ {
+if n, err := client.EstimatedSize(); err != nil || n != 2 {
+t.Errorf("EstimatedSize = %d, %v, want the exact 2", n, err)
+}
+}
+if got := strings.Join(sent, ","); got != "DBSIZE,SIZE,SIZE" {
+t.Errorf("sent %s, want DBSIZE tried once", got)
+}
+}
+
+func TestResetRecoversFromDesync(t *testing.T) {
+store := newMemStore()
+client := connectFake(t, func(line string) string {
+if line == "GET stutter" {
+// Answer twice, leaving a stale reply for the next command
+return "\"first\"\n\"second\""
+}
+return store.handle(line)
+})
+
+if err := client.Set("k", "v", 0); err != nil {
+t.Fatalf("Set: %v", err)
+}
+if _, err := client.Get("stutter"); err != nil {
+t.Fatalf("Get: %v", err)
+}
+if got, _ := client.Get("k"); got != "second" {
+t.Fatalf("expected stale reply after injected desync, got %q", got)
+}
+
+if err := client.Reset(); err != nil {
+t.Fatalf("Reset: %v", err)
+}
+if got, err := client.Get("k"); err != nil || got != "v" {
+t.Fatalf("Get after Reset = %q, %v", got, err)
+}
+}
+
+func TestAutoResetAfterIOError(t *testing.T) {
+store := newMemStore()
+config := startFakeServer(t, store.handle)
+config.AutoReset = true
+
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+// Simulate a failed read by killing the socket underneath the client
+client.conn.Close()
+if _, err := client.Get("k"); err == nil {
+t.Fatal("expected error on closed connection")
+}
+
+if err := client.Set("k", "v", 0); err != nil {
+t.Fatalf("Set after auto reset: %v", err)
+}
+
+client.config.AutoReset = false
+client.conn.Close()
+client.Get("k")
+if _, err := client.Get("k"); !errors.Is(err, ErrDesynced) {
+t.Fatalf("error = %v, want ErrDesynced", err)
+}
+}
+
+func TestCoalesceGets(t *testing.T) {
+var mu sync.Mutex
+requests := 0
+release := make(chan struct{})
+config := startFakeServer(t, func(line string) string {
+mu.Lock()
+requests++
+mu.Unlock()
+<-release
+return `"hot"`
+})
+config.CoalesceGets = true
+
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+const n = 100
+var started sync.WaitGroup
+started.Add(n)
+results := make(chan string, n)
+for i := 0; i < n; i++ {
+go func() {
+started.Done()
+value, err := client.Get("key")
+if err != nil {
+t.Errorf("Get: %v", err)
+}
+results <- value
+}()
+}
+
+// Hold the server reply until every caller has had time to join the flight
+started.Wait()
+time.Sleep(50 * time.Millisecond)
+close(release)
+
+for i := 0; i < n; i++ {
+if got := <-results; got != "hot" {
+t.Fatalf("Get = %q, want hot", got)
+}
+}
+mu.Lock()
+defer mu.Unlock()
+if requests != 1 {
+t.Fatalf("server saw %d GET requests, want 1", requests)
+}
+}
+
+func TestCoalesceGetsWaiterDeadline(t *testing.T) {
+release := make(chan struct{})
+config := startFakeServer(t, func(line string) string {
+<-release
+return `"hot"`
+})
+config.CoalesceGets = true
+
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+leader := make(chan string, 1)
+go func() {
+value, err := client.Get("key")
+if err != nil {
+t.Errorf("leader Get: %v", err)
+}
+leader <- value
+}()
+for {
+client.flight.mu.Lock()
+call := client.flight.calls["key"]
+client.flight.mu.Unlock()
+if call != nil {
+break
+}
+time.Sleep(time.Millisecond)
+}
+
+// The waiter gives up at its own deadline, not the leader's
+start := time.Now()
+if _, err := client.Get("key", WithTimeout(20*time.Millisecond)); !errors.Is(err, os.ErrDeadlineExceeded) {
+t.Fatalf("waiter Get error = %v, want os.ErrDeadlineExceeded", err)
+}
+if elapsed := time.Since(start); elapsed > time.Second {
+t.Fatalf("waiter Get took %s, want about 20ms", elapsed)
+}
+
+close(release)
+if got := receive(t, leader); got != "hot" {
+t.Fatalf("leader Get = %q, want hot", got)
+}
+}
+
+func TestConcurrentUse(t *testing.T) {
+store := newMemStore()
+client := connectFake(t, store.handle)
+
+var wg sync.WaitGroup
+for i := 0; i < 20; i++ {
+wg.Add(1)
+go func(i int) {
+defer wg.Done()
+key := "k" + strconv.Itoa(i)
+for j := 0; j < 20; j++ {
+if err := client.Set(key, key, 0); err != nil {
+t.Errorf("Set: %v", err)
+return
+}
+if got, err := client.Get(key); err != nil || got != key {
+t.Errorf("Get(%s) = %q, %v", key, got, err)
+return
+}
+}
+}(i)
+}
+wg.Wait()
+}
+
+func TestConfigValidate(t *testing.T) {
+tests := []struct {
+name   string
+modify func(*Config)
+}{
+{"empty host", func(c *Config) { c.Host = "" }},
+{"zero port", func(c *Config) { c.Port = 0 }},
+{"port too large", func(c *Config) { c.Port = 70000 }},
+{"negative timeout", func(c *Config) { c.Timeout = -time.Second }},
+{"negative chunk size", func(c *Config) { c.ChunkSize = -1 }},
+{"bad terminator", func(c *Config) { c.Terminator = ";" }},
+{"negative slow latency", func(c *Config) { c.SlowLatency = -time.Second }},
+{"negative TTL jitter", func(c *Config) { c.TTLJitter = -0.1 }},
+{"TTL jitter of 1", func(c *Config) { c.TTLJitter = 1 }},
+{"negative pool dial limit", func(c *Config) { c.PoolMaxDialing = -1 }},
+{"negative pipeline byte budget", func(c *Config) { c.PipelineMaxBytes = -1 }},
+{"negative reconnect backoff", func(c *Config) { c.ReconnectBackoff.Max = -time.Second }},
+{"reconnect jitter above 1", func(c *Config) { c.ReconnectBackoff.Jitter = 1.5 }},
+{"empty command filter", func(c *Config) { c.DisallowedCommands = []string{" "} }},
+}
+
+if err := DefaultConfig().Validate(); err != nil {
+t.Fatalf("default config invalid: %v", err)
+}
+
+for _, tt := range tests {
+config := DefaultConfig()
+tt.modify(config)
+if err := config.Validate(); err == nil {
+t.Errorf("%s: expected error", tt.name)
+}
+if _, err := Connect(config); err == nil {
+t.Errorf("%s: Connect should reject invalid config", tt.name)
+}
+}
+}
+
+func TestNoDelay(t *testing.T) {
+defer func(orig func(*net.TCPConn, bool) error) { setNoDelay = orig }(setNoDelay)
+
+for _, want := range []bool{true, false} {
+var got []bool
+setNoDelay = func(c *net.TCPConn, on bool) error {
+got = append(got, on)
+return c.SetNoDelay(on)
+}
+
+config := startFakeServer(t, newMemStore().handle)
+config.NoDelay = want
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+client.Close()
+
+if len(got) != 1 || got[0] != want {
+t.Errorf("NoDelay %v: SetNoDelay calls = %v", want, got)
+}
+}
+}
+
+func TestExistsMap(t *testing.T) {
+var mu sync.Mutex
+var lines []string
+store := newMemStore()
+store.data["a"] = "1"
+store.data["c"] = "3"
+client := connectFake(t, func(line string) string {
+mu.Lock()
+lines = append(lines, line)
+mu.Unlock()
+return store.handle(line)
+})
+
+got, err := client.ExistsMap("a", "b", "c", "a")
+if err != nil {
+t.Fatalf("ExistsMap: %v", err)
+}
+
+want := map[string]bool{"a": true, "b": false, "c": true}
+if len(got) != len(want) {
+t.Fatalf("ExistsMap = %v, want %v", got, want)
+}
+for key, present := range want {
+if got[key] != present {
+t.Errorf("ExistsMap[%s] = %v, want %v", key, got[key], present)
+}
+}
+if len(lines) != 3 {
+t.Errorf("duplicate key should be queried once, server saw %v", lines)
+}
+}
+
+func TestNotFoundSentinels(t *testing.T) {
+for _, sentinel := range []string{"(nil)", "(not found)"} {
+client := connectFake(t, func(string) string { return sentinel })
+
+if got, err := client.Get("missing"); err != nil || got != "" {
+t.Errorf("Get with %s = %q, %v", sentinel, got, err)
+}
+if _, err := client.MemoryUsage("missing"); !errors.Is(err, ErrKeyNotFound) {
+t.Errorf("MemoryUsage with %s error = %v", sentinel, err)
+}
+if _, err := client.GetStream("missing"); !errors.Is(err, ErrKeyNotFound) {
+t.Errorf("GetStream with %s error = %v", sentinel, err)
+}
+if err := client.Delete("missing"); err != nil {
+t.Errorf("Delete with %s: %v", sentinel, err)
+}
+}
+}
+
+func TestConnectionEvents(t *testing.T) {
+var events []string
+record := func(name string) ConnEventFunc {
+return func(addr string, err error) {
+if addr == "" {
+t.Errorf("%s: empty address", name)
+}
+event := name
+if err != nil {
+event += " (error)"
+}
+events = append(events, event)
+}
+}
+
+config := startFakeServer(t, newMemStore().handle)
+config.AutoReset = true
+config.OnConnect = record("connect")
+config.OnDisconnect = record("disconnect")
+config.OnReconnect = record("reconnect")
+
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+
+// Drop the connection underneath the client, then use it again
+client.conn.Close()
+client.Get("k")
+if err := client.Set("k", "v", 0); err != nil {
+t.Fatalf("Set after reconnect: %v", err)
+}
+client.Close()
+
+want := []string{"connect", "disconnect (error)", "reconnect", "disconnect"}
+if strings.Join(events, ",") != strings.Join(want, ",") {
+t.Fatalf("events = %v, want %v", events, want)
+}
+}
+
+func TestGetWithTTL(t *testing.T) {
+store := newMemStore()
+store.data["session"] = "abc"
+store.data["config"] = "xyz"
+client := connectFake(t, func(line string) string {
+switch line {
+case "TTL session":
+return "30"
+case "TTL config":
+return "-1"
+case "TTL missing":
+return "-2"
+}
+return store.handle(line)
+})
+
+value, ttl, found, err := client.GetWithTTL("session")
+if err != nil || !found || value != "abc" || ttl != 30*time.Second {
+t.Errorf("GetWithTTL(session) = %q, %s, %v, %v", value, ttl, found, err)
+}
+
+value, ttl, found, err = client.GetWithTTL("config")
+if err != nil || !found || value != "xyz" || ttl != NoExpiry {
+t.Errorf("GetWithTTL(config) = %q, %s, %v, %v", value, ttl, found, err)
+}
+
+value, ttl, found, err = client.GetWithTTL("missing")
+if err != nil || found || value != "" || ttl != 0 {
+t.Errorf("GetWithTTL(missing) = %q, %s, %v, %v", value, ttl, found, err)
+}
+}
+
+func TestTTL(t *testing.T) {
+replies := map[string]string{
+"TTL session":       "30",
+"PTTL session":      "1500",
+"TTL forever":       "-1",
+"TTL missing":       "-2",
+"TTL old-forever":   "(no expiry)",
+"TTL old-none":      "none",
+"TTL old-missing":   "(nil)",
+"TTL old-duration":  "1m30s",
+"TTL broken":        "-3",
+"TTL garbage":       "soon",
+"PTTL forever":      "-1",
+"PTTL missing":      "-2",
+"PTTL old-missing":  "(not found)",
+"PTTL old-duration": "250ms",
+}
+client := connectFake(t, func(line string) string {
+return replies[line]
+})
+
+tests := []struct {
+ttl  func(string, ...CallOption) (time.Duration, error)
+key  string
+want time.Duration
+err  error
+}{
+{client.TTL, "session", 30 * time.Second, nil},
+{client.PTTL, "session", 1500 * time.Millisecond, nil},
+{client.TTL, "forever", NoExpiry, nil},
+{client.TTL, "missing", 0, ErrKeyNotFound},
+{client.TTL, "old-forever", NoExpiry, nil},
+{client.TTL, "old-none", NoExpiry, nil},
+{client.TTL, "old-missing", 0, ErrKeyNotFound},
+{client.TTL, "old-duration", 90 * time.Second, nil},
+{client.PTTL, "forever", NoExpiry, nil},
+{client.PTTL, "missing", 0, ErrKeyNotFound},
+{client.PTTL, "old-missing", 0, ErrKeyNotFound},
+{client.PTTL, "old-duration", 250 * time.Millisecond, nil},
+}
+for _, tt := range tests {
+got, err := tt.ttl(tt.key)
+if got != tt.want || !errors.Is(err, tt.err) {
+t.Errorf("TTL(%s) = %s, %v, want %s, %v", tt.key, got, err, tt.want, tt.err)
+}
+}
+
+for _, key := range []string{"broken", "garbage"} {
+if got, err := client.TTL(key); err == nil {
+t.Errorf("TTL(%s) = %s, want an error", key, got)
+}
+}
+}
+
+func TestGetAuto(t *testing.T) {
+store := newMemStore()
+store.data["object"] = `{"name":"nub","port":6379}`
+store.data["array"] = `[1,2,3]`
+store.data["number"] = "42"
+store.data["plain"] = "hello"
+store.data["broken"] = "{not json"
+client := connectFake(t, store.handle)
+
+got, err := client.GetAuto("object")
+obj, ok := got.(map[string]interface{})
+if err != nil || !ok || obj["name"] != "nub" || obj["port"] != json.Number("6379") {
+t.Errorf("GetAuto(object) = %#v, %v", got, err)
+}
+
+got, err = client.GetAuto("array")
+if arr, ok := got.([]interface{}); err != nil || !ok || len(arr) != 3 {
+t.Errorf("GetAuto(array) = %#v, %v", got, err)
+}
+
+for _, key := range []string{"number", "plain", "broken"} {
+got, err := client.GetAuto(key)
+if s, ok := got.(string); err != nil || !ok || s != store.data[key] {
+t.Errorf("GetAuto(%s) = %#v, %v", key, got, err)
+}
+}
+
+if got, err := client.GetAuto("missing"); err != nil || got != nil {
+t.Errorf("GetAuto(missing) = %#v, %v", got, err)
+}
+}
+
+func TestDetectConcurrentUse(t *testing.T) {
+received := make(chan struct{})
+release := make(chan struct{})
+config := startFakeServer(t, func(line string) string {
+if line == "GET slow" {
+close(received)
+<-release
+}
+return "OK"
+})
+config.DetectConcurrentUse = true
+
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+done := make(chan struct{})
+go func() {
+defer close(done)
+client.Get("slow")
+}()
+<-received
+
+func() {
+defer func() {
+if r := recover(); r == nil {
+t.Error("expected panic for overlapping commands")
+}
+}()
+client.Get("other")
+}()
+
+close(release)
+<-done
+
+// The client's own bookkeeping, such as the idle timer, is waited for
+held := make(chan struct{})
+go func() {
+client.internalLock()
+close(held)
+time.Sleep(20 * time.Millisecond)
+client.internalUnlock()
+}()
+<-held
+if _, err := client.Get("other"); err != nil {
+t.Errorf("Get while the client held its own lock = %v", err)
+}
+}
+
+func TestGetRangeSetRange(t *testing.T) {
+store := newMemStore()
+store.data["greeting"] = "Hello World"
+client := connectFake(t, store.handle)
+
+ranges := []struct {
+start, end int64
+want       string
+}{
+{0, 4, "Hello"},
+{-5, -1, "World"},
+{6, 100, "World"},
+{20, 30, ""},
+{5, 2, ""},
+}
+for _, r := range ranges {
+got, err := client.GetRange("greeting", r.start, r.end)
+if err != nil || got != r.want {
+t.Errorf("GetRange(%d, %d) = %q, %v, want %q", r.start, r.end, got, err, r.want)
+}
+}
+
+// Overlapping write replaces part of the value in place
+n, err := client.SetRange("greeting", 6, "Redis")
+if err != nil || n != 11 || store.data["greeting"] != "Hello Redis" {
+t.Errorf("SetRange overlap = %d, %v, value %q", n, err, store.data["greeting"])
+}
+
+// Writing past the end pads with zero bytes
+n, err = client.SetRange("short", 3, "ab")
+if err != nil || n != 5 || store.data["short"] != "\x00\x00\x00ab" {
+t.Errorf("SetRange out of bounds = %d, %v, value %q", n, err, store.data["short"])
+}
+
+if _, err := client.SetRange("greeting", -1, "x"); err == nil {
+t.Error("SetRange with negative offset should fail")
+}
+}
+
+func TestAllowN(t *testing.T) {
+client := connectFake(t, newMemStore().handle)
+
+const limit = 10
+var mu sync.Mutex
+allowed := 0
+var wg sync.WaitGroup
+for i := 0; i < 25; i++ {
+wg.Add(1)
+go func() {
+defer wg.Done()
+ok, remaining, err := client.AllowN("api:user1", limit, time.Minute, 1)
+if err != nil {
+t.Errorf("AllowN: %v", err)
+return
+}
+if remaining < 0 || remaining > limit-1 {
+t.Errorf("remaining = %d out of range", remaining)
+}
+if ok {
+mu.Lock()
+allowed++
+mu.Unlock()
+}
+}()
+}
+wg.Wait()
+
+if allowed != limit {
+t.Fatalf("allowed %d requests, want %d", allowed, limit)
+}
+
+ok, remaining, err := client.AllowN("api:user2", limit, time.Minute, 4)
+if err != nil || !ok || remaining != 6 {
+t.Fatalf("AllowN(4) = %v, %d, %v", ok, remaining, err)
+}
+}
+
+func TestEndpointsFailover(t *testing.T) {
+// Grab a free port and release it so nothing is listening there
+ln, err := net.Listen("tcp", "127.0.0.1:0")
+if err != nil {
+t.Fatalf("listen: %v", err)
+}
+dead := ln.Addr().String()
+ln.Close()
+
+live := startFakeServer(t, newMemStore().handle)
+liveAddr := net.JoinHostPort(live.Host, strconv.Itoa(live.Port))
+
+config := DefaultConfig()
+config.Host = ""
+config.Endpoints = []string{dead, liveAddr}
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("Connect: %v", err)
+}
+defer client.Close()
+
+if client.conn.RemoteAddr().String() != liveAddr {
+t.Fatalf("connected to %s, want %s", client.conn.RemoteAddr(), liveAddr)
+}
+if err := client.Set("k", "v", 0); err != nil {
+t.Fatalf("Set: %v", err)
+}
+
+// Reset rotates past the dead endpoint back to the live one
+if err := client.Reset(); err != nil {
+t.Fatalf("Reset: %v", err)
+}
+if client.conn.RemoteAddr().String() != liveAddr {
+t.Fatalf("reconnected to %s, want %s", client.conn.RemoteAddr(), liveAddr)
+}
+
+config.Endpoints = []string{"no-port"}
+if err := config.Validate(); err == nil {
+t.Error("endpoint without port should be invalid")
+}
+}
+
+func TestIdleCloseKeepsEndpoint(t *testing.T) {
+first := startFakeServer(t, newMemStore().handle)
+second := startFakeServer(t, newMemStore().handle)
+firstAddr := net.JoinHostPort(first.Host, strconv.Itoa(first.Port))
+
+config := DefaultConfig()
+config.Host = ""
+config.Endpoints = []string{firstAddr, net.JoinHostPort(second.Host, strconv.Itoa(second.Port))}
+config.IdleTimeout = 20 * time.Millisecond
+config.AutoReset = true
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("Connect: %v", err)
+}
+defer client.Close()
+if err := client.Set("k", "v", 0); err != nil {
+t.Fatalf("Set: %v", err)
+}
+
+for deadline := time.Now().Add(2 * time.Second); ; {
+client.internalLock()
+closed := client.idleClosed
+client.internalUnlock()
+if closed {
+break
+}
+if time.Now().After(deadline) {
+t.Fatal("connection was not closed while idle")
+}
+time.Sleep(5 * time.Millisecond)
+}
+
+if value, err := client.Get("k"); err != nil || value != "v" {
+t.Fatalf("Get after idle close = %q, %v, want the first endpoint's value", value, err)
+}
+if addr := client.conn.RemoteAddr().String(); addr != firstAddr {
+t.Errorf("reconnected to %s after idle close, want %s", addr, firstAddr)
+}
+}
+
+func TestVerifyProtocol(t *testing.T) {
+config := startFakeServer(t, func(string) string {
+return "HTTP/1.1 400 Bad Request\r\nContent-Length: 0\r\n"
+})
+config.VerifyProtocol = true
+
+_, err := Connect(config)
+if !errors.Is(err, ErrProtocolMismatch) {
+t.Fatalf("Connect error = %v, want ErrProtocolMismatch", err)
+}
+if !strings.Contains(err.Error(), "HTTP/1.1 400") {
+t.Errorf("error should include the received bytes: %v", err)
+}
+
+config = startFakeServer(t, newMemStore().handle)
+config.VerifyProtocol = true
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("Connect to NubDB: %v", err)
+}
+client.Close()
+}
+
+func TestSlidingTTL(t *testing.T) {
+for _, sliding := range []time.Duration{0, time.Hour} {
+store := newMemStore()
+config := startFakeServer(t, store.handle)
+config.SlidingTTL = sliding
+
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+
+client.Set("session", "abc", 60)
+client.Set("persistent", "xyz", 0)
+if got, err := client.Get("session"); err != nil || got != "abc" {
+t.Fatalf("Get = %q, %v", got, err)
+}
+client.Get("persistent")
+client.Close()
+
+wantTTL := int64(60)
+if sliding > 0 {
+wantTTL = 3600
+}
+if store.ttl["session"] != wantTTL {
+t.Errorf("sliding %s: session ttl = %d, want %d", sliding, store.ttl["session"], wantTTL)
+}
+if _, ok := store.ttl["persistent"]; ok {
+t.Errorf("sliding %s: key without expiry gained one", sliding)
+}
+}
+}
+
+func TestErrReadOnly(t *testing.T) {
+for _, reply := range []string{
+"ERROR: READONLY You can't write against a read only replica.",
+"ERROR: server is read-only",
+} {
+client := connectFake(t, func(string) string { return reply })
+
+err := client.Set("k", "v", 0)
+if !errors.Is(err, ErrReadOnly) {
+t.Errorf("Set with %q: error = %v, want ErrReadOnly", reply, err)
+}
+var serr *ServerError
+if !errors.As(err, &serr) {
+t.Errorf("Set with %q: error should still be a *ServerError", reply)
+}
+}
+
+client := connectFake(t, func(string) string { return "ERROR: out of memory" })
+if err := client.Set("k", "v", 0); err == nil || errors.Is(err, ErrReadOnly) {
+t.Errorf("unrelated server error matched ErrReadOnly: %v", err)
+}
+}
+
+func TestIdleTimeout(t *testing.T) {
+for _, autoReset := range []bool{true, false} {
+var mu sync.Mutex
+var events []string
+record := func(name string) ConnEventFunc {
+return func(string, error) {
+mu.Lock()
+events = append(events, name)
+mu.Unlock()
+}
+}
+
+config := startFakeServer(t, newMemStore().handle)
+config.IdleTimeout = 50 * time.Millisecond
+config.AutoReset = autoReset
+config.OnDisconnect = record("disconnect")
+config.OnReconnect = record("reconnect")
+
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+
+// Regular use keeps the connection open
+for i := 0; i < 5; i++ {
+if err := client.Set("k", "v", 0); err != nil {
+t.Fatalf("Set: %v", err)
+}
+time.Sleep(20 * time.Millisecond)
+}
+mu.Lock()
+if len(events) != 0 {
+t.Fatalf("connection closed while in use: %v", events)
+}
+mu.Unlock()
+
+time.Sleep(120 * time.Millisecond)
+mu.Lock()
+if len(events) != 1 || events[0] != "disconnect" {
+t.Fatalf("events after idle = %v, want [disconnect]", events)
+}
+mu.Unlock()
+
+_, err = client.Get("k")
+if autoReset {
+if err != nil {
+t.Fatalf("Get after idle close: %v", err)
+}
+mu.Lock()
+if len(events) != 2 || events[1] != "reconnect" {
+t.Errorf("events = %v, want reconnect after use", events)
+}
+mu.Unlock()
+} else if !errors.Is(err, ErrIdleClosed) {
+t.Fatalf("Get after idle close error = %v, want ErrIdleClosed", err)
+}
+
+if err := client.Close(); err != nil && autoReset {
+t.Errorf("Close: %v", err)
+}
+}
+}
+
+func TestEmptyResponse(t *testing.T) {
+store := newMemStore()
+client := connectFake(t, func(line string) string {
+if strings.Contains(line, " empty") || line == "SIZE" {
+return ""
+}
+return store.handle(line)
+})
+
+checks := map[string]func() error{
+"Get":    func() error { _, err := client.Get("empty"); return err },
+"Set":    func() error { return client.Set("empty", "v", 0) },
+"Exists": func() error { _, err := client.Exists("empty"); return err },
+"Incr":   func() error { _, err := client.Incr("empty"); return err },
+"Size":   func() error { _, err := client.Size(); return err },
+"Pipeline": func() error {
+p := client.Pipeline()
+p.Get("empty")
+_, err := p.Exec()
+return err
+},
+}
+for name, check := range checks {
+if err := check(); !errors.Is(err, ErrEmptyResponse) {
+t.Errorf("%s: error = %v, want ErrEmptyResponse", name, err)
+}
+}
+
+// The empty line was a complete reply, so the connection is still in sync
+client.Set("k", "v", 0)
+if value, err := client.Get("k"); err != nil || value != "v" {
+t.Errorf("Get after empty replies = %q, %v", value, err)
+}
+}
+
+func TestCommandFilters(t *testing.T) {
+store := newMemStore()
+var sent []string
+config := startFakeServer(t, func(line string) string {
+sent = append(sent, line)
+return store.handle(line)
+})
+config.DisallowedCommands = []string{"clear", "CLIENT KILL", "DELETE"}
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+if err := client.Clear(); !errors.Is(err, ErrCommandNotAllowed) {
+t.Errorf("Clear = %v, want ErrCommandNotAllowed", err)
+}
+if _, err := client.DoArgs("client", "kill", "id", 3); !errors.Is(err, ErrCommandNotAllowed) {
+t.Errorf("CLIENT KILL = %v, want ErrCommandNotAllowed", err)
+}
+if len(sent) != 0 {
+t.Errorf("rejected commands reached the server: %q", sent)
+}
+
+// Other commands, and other CLIENT subcommands, still work
+if err := client.Set("k", "v", 0); err != nil {
+t.Errorf("Set: %v", err)
+}
+if _, err := client.DoArgs("CLIENT", "LIST"); errors.Is(err, ErrCommandNotAllowed) {
+t.Errorf("CLIENT LIST rejected: %v", err)
+}
+
+// A refused pipeline sends none of its commands
+sent = nil
+p := client.Pipeline()
+p.Set("a", "1", 0)
+p.Delete("a")
+if _, err := p.Exec(); !errors.Is(err, ErrCommandNotAllowed) {
+t.Errorf("pipeline with DELETE = %v, want ErrCommandNotAllowed", err)
+}
+if len(sent) != 0 {
+t.Errorf("refused pipeline reached the server: %q", sent)
+}
+
+config.DisallowedCommands = nil
+config.AllowedCommands = []string{"GET", "SET"}
+readOnly, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer readOnly.Close()
+if _, err := readOnly.Get("k"); err != nil {
+t.Errorf("allowed Get: %v", err)
+}
+if err := readOnly.Delete("k"); !errors.Is(err, ErrCommandNotAllowed) {
+t.Errorf("Delete outside AllowedCommands = %v, want ErrCommandNotAllowed", err)
+}
+}
+
+func TestServerErrorCode(t *testing.T) {
+tests := []struct {
+reply   string
+code    string
+message string
+}{
+{"ERROR: ERR wrong number of arguments", "ERR", "wrong number of arguments"},
+{"ERROR: WRONGTYPE Operation against a key holding the wrong kind of value", "WRONGTYPE", "Operation against a key holding the wrong kind of value"},
+{"ERROR: NOSCRIPT No matching script", "NOSCRIPT", "No matching script"},
+{"ERROR: OOM_LIMIT", "OOM_LIMIT", ""},
+{"ERROR: SET requires key and value", "", "SET requires key and value"},
+{"ERROR: MULTI calls can not be nested", "", "MULTI calls can not be nested"},
+{"ERROR: CONFIG GET requires a pattern", "", "CONFIG GET requires a pattern"},
+{"ERROR: error.OutOfMemory", "", "error.OutOfMemory"},
+{"ERROR: Unknown command", "", "Unknown command"},
+{"ERROR", "", ""},
+}
+
+for _, tt := range tests {
+var serr *ServerError
+if !errors.As(parseServerError(tt.reply), &serr) {
+t.Errorf("%q: not a *ServerError", tt.reply)
+continue
+}
+if serr.Code != tt.code || serr.Message != tt.message {
+t.Errorf("%q: code %q message %q, want %q %q", tt.reply, serr.Code, serr.Message, tt.code, tt.message)
+}
+}
+
+client := connectFake(t, func(string) string { return "ERROR: WRONGTYPE not a counter" })
+_, err := client.Incr("k")
+var serr *ServerError
+if !errors.As(err, &serr) || serr.Code != "WRONGTYPE" {
+t.Fatalf("Incr error = %v, want WRONGTYPE", err)
+}
+if err.Error() != "server error: WRONGTYPE not a counter" {
+t.Errorf("Error() = %q", err.Error())
+}
+}
+
+func TestStrictReplies(t *testing.T) {
+store := newMemStore()
+config := startFakeServer(t, func(line string) string {
+// Inject a desync: one command, two replies
+if line == "SET extra \"v\"" {
+return "OK\nOK"
+}
+return store.handle(line)
+})
+config.StrictReplies = true
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+// Balanced traffic, including multi-line and pipelined replies, passes
+client.Set("a", "1", 0)
+p := client.Pipeline()
+p.Get("a")
+p.Incr("a")
+if _, err := p.Exec(); err != nil {
+t.Fatalf("pipeline: %v", err)
+}
+
+if err := client.Set("extra", "v", 0); err != nil {
+t.Fatalf("Set: %v", err)
+}
+// Let the surplus reply arrive
+time.Sleep(20 * time.Millisecond)
+
+_, err = client.Get("a")
+if !errors.Is(err, ErrUnbalanced) {
+t.Fatalf("Get after a surplus reply = %v, want ErrUnbalanced", err)
+}
+if _, err := client.Get("a"); !errors.Is(err, ErrDesynced) {
+t.Errorf("connection not marked out of sync: %v", err)
+}
+
+if err := client.Reset(); err != nil {
+t.Fatalf("Reset: %v", err)
+}
+if value, err := client.Get("a"); err != nil || value != "2" {
+t.Errorf("Get after Reset = %q, %v", value, err)
+}
+}
+
+func TestStrictRepliesUnreadReply(t *testing.T) {
+config := startFakeServer(t, newMemStore().handle)
+config.StrictReplies = true
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+// Simulate a code path that forgets to read its reply
+client.mu.Lock()
+client.writeCommand("SET k \"v\"")
+client.mu.Unlock()
+
+if err := client.Set("k", "w", 0); !errors.Is(err, ErrUnbalanced) {
+t.Errorf("Set after an unread reply = %v, want ErrUnbalanced", err)
+}
+}
+
+func TestReconnect(t *testing.T) {
+config := startConnIDServer(t)
+var reconnects int
+config.OnReconnect = func(addr string, err error) { reconnects++ }
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+if id, _ := client.Get("k"); id != "1" {
+t.Fatalf("first connection id = %s", id)
+}
+old := client.conn
+
+if err := client.Reconnect(); err != nil {
+t.Fatalf("Reconnect: %v", err)
+}
+if _, err := old.Write([]byte("GET k\n")); !errors.Is(err, net.ErrClosed) {
+t.Errorf("old connection still open: write error = %v", err)
+}
+if id, _ := client.Get("k"); id != "2" {
+t.Errorf("connection id after Reconnect = %s, want 2", id)
+}
+if reconnects != 1 {
+t.Errorf("OnReconnect called %d times, want 1", reconnects)
+}
+}
+
+func TestExistsReplyForms(t *testing.T) {
+tests := []struct {
+reply string
+want  bool
+}{
+{"1", true},
+{"0", false},
+{"true", true},
+{"false", false},
+{"TRUE", true},
+{"2", true},
+}
+
+for _, tt := range tests {
+client := connectFake(t, func(string) string { return tt.reply })
+got, err := client.Exists("k")
+if err != nil || got != tt.want {
+t.Errorf("Exists with reply %q = %v, %v; want %v", tt.reply, got, err, tt.want)
+}
+m, err := client.ExistsMap("k")
+if err != nil || m["k"] != tt.want {
+t.Errorf("ExistsMap with reply %q = %v, %v; want %v", tt.reply, m, err, tt.want)
+}
+}
+
+for _, reply := range []string{"yes", "-1", "(nil)"} {
+client := connectFake(t, func(string) string { return reply })
+if _, err := client.Exists("k"); err == nil {
+t.Errorf("Exists with reply %q should fail", reply)
+}
+}
+}
+
+func TestExistsCount(t *testing.T) {
+var sent string
+client := connectFake(t, func(line string) string {
+sent = line
+return "2"
+})
+
+n, err := client.ExistsCount([]string{"a", "b", "a"})
+if err != nil || n != 2 || sent != "EXISTS a b a" {
+t.Errorf("ExistsCount = %d, %v; sent %q", n, err, sent)
+}
+}
+
+func TestDialFunc(t *testing.T) {
+store := newMemStore()
+var dialed []string
+config := DefaultConfig()
+config.Endpoints = []string{"down.example:1", "memory.example:2"}
+config.DialFunc = func(ctx context.Context, network, addr string) (net.Conn, error) {
+dialed = append(dialed, network+" "+addr)
+if _, ok := ctx.Deadline(); !ok {
+t.Error("dial context has no deadline")
+}
+if addr == "down.example:1" {
+return nil, errors.New("connection refused")
+}
+
+client, server := net.Pipe()
+go func() {
+defer server.Close()
+reader := bufio.NewReader(server)
+for {
+line, err := reader.ReadString('\n')
+if err != nil || line == "QUIT\n" {
+return
+}
+server.Write([]byte(store.handle(strings.TrimSuffix(line, "\n")) + "\n"))
+}
+}()
+return client, nil
+}
+
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("Connect: %v", err)
+}
+defer client.Close()
+
+if err := client.Set("k", "v", 0); err != nil {
+t.Fatalf("Set: %v", err)
+}
+if value, err := client.Get("k"); err != nil || value != "v" {
+t.Errorf("Get = %q, %v", value, err)
+}
+if got := strings.Join(dialed, ","); got != "tcp down.example:1,tcp memory.example:2" {
+t.Errorf("dialed %s", got)
+}
+}
@@ -0,0 +1,113 @@
+package nubdb
+
+import (
+"fmt"
+"os"
+"time"
+)
+
+// CallOption configures a single command call
+type CallOption func(*callOptions)
+
+type callOptions struct {
+timeout  time.Duration
+deadline time.Time
+verify   bool
+dedup    bool
+
+// SET flags, for SetWith
+ttl     time.Duration
+nx      bool
+keepTTL bool
+}
+
+func newCallOptions(opts []CallOption) callOptions {
+var o callOptions
+for _, opt := range opts {
+opt(&o)
+}
+return o
+}
+
+// effectiveDeadline returns the deadline of the call, or the zero time
+func (o callOptions) effectiveDeadline() time.Time {
+deadline := o.deadline
+if o.timeout > 0 {
+if d := time.Now().Add(o.timeout); deadline.IsZero() || d.Before(deadline) {
+deadline = d
+}
+}
+return deadline
+}
+
+// checkDeadline fails with os.ErrDeadlineExceeded once deadline has passed
+func checkDeadline(deadline time.Time) error {
+if !deadline.IsZero() && !time.Now().Before(deadline) {
+return fmt.Errorf("call deadline exceeded: %w", os.ErrDeadlineExceeded)
+}
+return nil
+}
+
+// WithTimeout bounds the time one call may take, overriding any default.
+// A command that times out leaves the connection out of sync (see ErrDesynced).
+func WithTimeout(d time.Duration) CallOption {
+return func(o *callOptions) {
+o.timeout = d
+}
+}
+
+// WithDeadline bounds the total time of a call, including every command of
+// calls that send several (SetLarge, GetLarge, split pipelines) and every
+// attempt of calls that retry (Update). Each command gets the remaining time,
+// and no further attempt starts once it has passed; the call then fails with
+// an error matching os.ErrDeadlineExceeded. Combined with WithTimeout, the
+// earlier limit applies to each command.
+func WithDeadline(t time.Time) CallOption {
+return func(o *callOptions) {
+o.deadline = t
+}
+}
+
+// WithVerify makes Set read the value back in the same round trip and fail
+// with ErrVerifyMismatch unless it matches what was written. It is a
+// diagnostic for suspected lost or mangled writes; a concurrent write by
+// another client also counts as a mismatch. Other calls ignore it.
+func WithVerify() CallOption {
+return func(o *callOptions) {
+o.verify = true
+}
+}
+
+// WithDedup makes multi-key calls (MGet, DeleteMany, Unlink, ExistsCount
+// and TouchMany) send each distinct key once. Without it every key is sent as
+// listed, duplicates included. MGet returns one result per listed key
+// either way, in the order listed; ExistsCount and TouchMany then count
+// each key once.
+func WithDedup() CallOption {
+return func(o *callOptions) {
+o.dedup = true
+}
+}
+
+// WithTTL makes SetWith give the key a time to live of d, rounded up to
+// whole seconds and spread by Config.TTLJitter. Zero means no expiry.
+func WithTTL(d time.Duration) CallOption {
+return func(o *callOptions) {
+o.ttl = d
+}
+}
+
+// WithNX makes SetWith store the value only if the key does not exist yet
+func WithNX() CallOption {
+return func(o *callOptions) {
+o.nx = true
+}
+}
+
+// WithKeepTTL makes SetWith keep the key's current time to live instead of
+// clearing it. It cannot be combined with WithTTL.
+func WithKeepTTL() CallOption {
+return func(o *callOptions) {
+o.keepTTL = true
+}
+}
@@ -0,0 +1,122 @@
+package nubdb
+
+import (
+"errors"
+"net"
+"testing"
+"time"
+)
+
+func TestWithTimeout(t *testing.T) {
+store := newMemStore()
+client := connectFake(t, func(line string) string {
+if line == "GET slow" {
+time.Sleep(200 * time.Millisecond)
+}
+return store.handle(line)
+})
+
+start := time.Now()
+_, err := client.Get("slow", WithTimeout(50*time.Millisecond))
+var netErr net.Error
+if !errors.As(err, &netErr) || !netErr.Timeout() {
+t.Fatalf("Get error = %v, want timeout", err)
+}
+if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+t.Fatalf("per-call timeout not applied, took %s", elapsed)
+}
+
+// Without the option the same slow command succeeds
+if err := client.Reset(); err != nil {
+t.Fatalf("Reset: %v", err)
+}
+if _, err := client.Get("slow"); err != nil {
+t.Fatalf("Get without timeout: %v", err)
+}
+}
+
+func TestWithVerify(t *testing.T) {
+store := newMemStore()
+var lines []string
+client := connectFake(t, func(line string) string {
+lines = append(lines, line)
+if line == "GET mangled" {
+return `"something else"`
+}
+return store.handle(line)
+})
+
+if err := client.Set("good", "value", 0, WithVerify()); err != nil {
+t.Fatalf("verified Set: %v", err)
+}
+if len(lines) != 2 || lines[1] != "GET good" {
+t.Errorf("server got %q, want the SET and a GET", lines)
+}
+
+err := client.Set("mangled", "value", 0, WithVerify())
+if !errors.Is(err, ErrVerifyMismatch) {
+t.Fatalf("Set with a wrong readback = %v, want ErrVerifyMismatch", err)
+}
+
+// Without the option nothing is read back
+lines = nil
+if err := client.Set("mangled", "value", 0); err != nil || len(lines) != 1 {
+t.Errorf("plain Set = %v, sent %q", err, lines)
+}
+}
+
+func TestSetWith(t *testing.T) {
+var sent string
+reply := "OK"
+client := connectFake(t, func(line string) string {
+sent = line
+return reply
+})
+
+tests := []struct {
+opts []CallOption
+want string
+}{
+{nil, `SET k "v"`},
+{[]CallOption{WithTTL(90 * time.Second)}, `SET k "v" 90`},
+{[]CallOption{WithTTL(1500 * time.Millisecond)}, `SET k "v" 2`},
+{[]CallOption{WithTTL(0)}, `SET k "v"`},
+{[]CallOption{WithNX()}, `SET k "v" NX`},
+{[]CallOption{WithTTL(time.Minute), WithNX()}, `SET k "v" 60 NX`},
+{[]CallOption{WithKeepTTL()}, `SET k "v" KEEPTTL`},
+{[]CallOption{WithNX(), WithKeepTTL()}, `SET k "v" KEEPTTL NX`},
+}
+for _, tt := range tests {
+sent = ""
+if stored, err := client.SetWith("k", "v", tt.opts...); err != nil || !stored {
+t.Errorf("SetWith = %v, %v", stored, err)
+}
+if sent != tt.want {
+t.Errorf("SetWith sent %q, want %q", sent, tt.want)
+}
+}
+
+// Conflicting or invalid flags send nothing
+sent = ""
+if _, err := client.SetWith("k", "v", WithTTL(time.Minute), WithKeepTTL()); err == nil {
+t.Error("SetWith with WithTTL and WithKeepTTL should fail")
+}
+if _, err := client.SetWith("k", "v", WithTTL(-time.Second)); err == nil {
+t.Error("SetWith with a negative TTL should fail")
+}
+if err := client.Set("k", "v", 0, WithNX()); err == nil {
+t.Error("Set with WithNX should fail rather than ignore it")
+}
+if sent != "" {
+t.Errorf("refused calls sent %q", sent)
+}
+
+// A key that exists is not overwritten under WithNX
+reply = "(nil)"
+if stored, err := client.SetWith("k", "v", WithNX()); err != nil || stored {
+t.Errorf("SetWith NX of an existing key = %v, %v, want not stored", stored, err)
+}
+if _, err := client.SetWith("k", "v"); err == nil {
+t.Error("SetWith without WithNX should not accept (nil)")
+}
+}
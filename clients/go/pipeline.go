@@ -0,0 +1,261 @@
+package nubdb
+
+import (
+"errors"
+"fmt"
+"sort"
+"strings"
+)
+
+// Pipeline queues commands and sends them to the server in a single round
+// trip. It is not safe for concurrent use; create one per goroutine.
+type Pipeline struct {
+c       *Client
+entries []pipelineEntry
+
+// Under Config.PipelineMaxBytes, the first sent entries have been sent
+// early; responses holds their replies and err the error that failed a
+// send, which Exec returns.
+sent      int
+responses []string
+bytes     int // encoded size of the entries not yet sent
+err       error
+}
+
+type pipelineEntry struct {
+name  string // command verb, e.g. "SET"
+cmd   string // cleared once sent
+check func(response string) error
+}
+
+// PipelineError reports which commands of a pipeline failed. It is returned
+// by Exec when at least one command failed.
+type PipelineError struct {
+errs []error
+}
+
+func (e *PipelineError) Error() string {
+var failed []string
+for _, err := range e.errs {
+if err != nil {
+failed = append(failed, err.Error())
+}
+}
+return fmt.Sprintf("pipeline: %d of %d commands failed: %s", len(failed), len(e.errs), strings.Join(failed, "; "))
+}
+
+// Errors returns one entry per queued command, in order: a
+// *PipelineCommandError for a failed command, or nil if it succeeded.
+func (e *PipelineError) Errors() []error {
+return e.errs
+}
+
+// Unwrap lets errors.Is and errors.As match any of the failed commands
+func (e *PipelineError) Unwrap() []error {
+var errs []error
+for _, err := range e.errs {
+if err != nil {
+errs = append(errs, err)
+}
+}
+return errs
+}
+
+// PipelineCommandError is the failure of one command in a pipeline
+type PipelineCommandError struct {
+Index   int    // position of the command in the pipeline
+Command string // command verb, e.g. "INCR"
+Err     error
+}
+
+func (e *PipelineCommandError) Error() string {
+return fmt.Sprintf("#%d %s: %v", e.Index, e.Command, e.Err)
+}
+
+func (e *PipelineCommandError) Unwrap() error {
+return e.Err
+}
+
+// Pipeline returns an empty pipeline that sends its commands on c
+func (c *Client) Pipeline() *Pipeline {
+return &Pipeline{c: c}
+}
+
+func (p *Pipeline) add(cmd string, check func(string) error) {
+if p.err != nil {
+return
+}
+
+name, _, _ := strings.Cut(cmd, " ")
+p.entries = append(p.entries, pipelineEntry{name: name, cmd: cmd, check: check})
+p.bytes += len(cmd) + len(p.c.terminator)
+if limit := p.c.config.PipelineMaxBytes; limit > 0 && p.bytes >= limit {
+p.flush(nil)
+}
+}
+
+// flush sends the entries not sent yet, in batches of at most
+// Config.PipelineBatchSize, and keeps their responses. After a failed send
+// it does nothing.
+func (p *Pipeline) flush(opts []CallOption) {
+if p.err != nil || p.sent == len(p.entries) {
+return
+}
+
+cmds := make([]string, 0, len(p.entries)-p.sent)
+for _, entry := range p.entries[p.sent:] {
+cmds = append(cmds, entry.cmd)
+}
+batch := p.c.config.PipelineBatchSize
+if batch <= 0 {
+batch = len(cmds)
+}
+
+for start := 0; start < len(cmds); start += batch {
+end := min(start+batch, len(cmds))
+responses, err := p.c.sendCommands(cmds[start:end], opts...)
+if err != nil {
+p.err = err
+return
+}
+p.responses = append(p.responses, responses...)
+}
+for i := p.sent; i < len(p.entries); i++ {
+p.entries[i].cmd = ""
+}
+p.sent = len(p.entries)
+p.bytes = 0
+}
+
+// Commands returns the verbs of the queued commands in order, matching the
+// responses and errors Exec will return
+func (p *Pipeline) Commands() []string {
+names := make([]string, len(p.entries))
+for i, entry := range p.entries {
+names[i] = entry.name
+}
+return names
+}
+
+// Len returns the number of queued commands
+func (p *Pipeline) Len() int {
+return len(p.entries)
+}
+
+// Set queues a SET
+func (p *Pipeline) Set(key, value string, ttl int) {
+p.add(setCommand(key, value, ttl), expectOK)
+}
+
+// Get queues a GET
+func (p *Pipeline) Get(key string) {
+p.add(fmt.Sprintf("GET %s", key), nil)
+}
+
+// Delete queues a DELETE
+func (p *Pipeline) Delete(key string) {
+p.add(fmt.Sprintf("DELETE %s", key), nil)
+}
+
+// Exists queues an EXISTS
+func (p *Pipeline) Exists(key string) {
+p.add(fmt.Sprintf("EXISTS %s", key), expectExists)
+}
+
+// Incr queues an INCR
+func (p *Pipeline) Incr(key string) {
+p.add(fmt.Sprintf("INCR %s", key), expectInt)
+}
+
+// Decr queues a DECR
+func (p *Pipeline) Decr(key string) {
+p.add(fmt.Sprintf("DECR %s", key), expectInt)
+}
+
+// Exec sends the queued commands and returns their raw responses in order,
+// then empties the pipeline. If any command failed, the responses are still
+// returned along with a *PipelineError describing the failures.
+//
+// Pipelines longer than Config.PipelineBatchSize are sent in several
+// flushes, and under Config.PipelineMaxBytes some may have been sent while
+// queuing, without opts. If the connection fails part way, the batches
+// already sent have been applied and only the connection error is
+// returned; commands queued after an early send failed are dropped.
+func (p *Pipeline) Exec(opts ...CallOption) ([]string, error) {
+p.flush(opts)
+entries, responses, err := p.entries, p.responses, p.err
+*p = Pipeline{c: p.c}
+if err != nil {
+return nil, err
+}
+if len(entries) == 0 {
+return nil, nil
+}
+
+errs := make([]error, len(entries))
+failed := false
+for i, entry := range entries {
+err := parseServerError(responses[i])
+if err == nil && entry.check != nil {
+err = entry.check(responses[i])
+}
+if err != nil {
+errs[i] = &PipelineCommandError{Index: i, Command: entry.name, Err: err}
+failed = true
+}
+}
+
+if failed {
+return responses, &PipelineError{errs: errs}
+}
+
+return responses, nil
+}
+
+// SetAllOK sets every key of pairs with the given TTL in one pipeline and
+// reports whether every SET was answered with OK. When some were not, it
+// returns false and an error naming the failed keys, which wraps the
+// *PipelineError; the other keys have been set. A connection error is
+// returned as is, and may leave any number of the keys set.
+func (c *Client) SetAllOK(pairs map[string]string, ttl int, opts ...CallOption) (bool, error) {
+keys := make([]string, 0, len(pairs))
+for key := range pairs {
+keys = append(keys, key)
+}
+sort.Strings(keys)
+
+p := c.Pipeline()
+for _, key := range keys {
+p.Set(key, pairs[key], c.jitterTTL(ttl))
+}
+_, err := p.Exec(opts...)
+
+var pipeErr *PipelineError
+if !errors.As(err, &pipeErr) {
+return err == nil, err
+}
+var failed []string
+for i, cmdErr := range pipeErr.Errors() {
+if cmdErr != nil {
+failed = append(failed, keys[i])
+}
+}
+return false, fmt.Errorf("SET failed for %d of %d keys (%s): %w", len(failed), len(keys), strings.Join(failed, ", "), err)
+}
+
+func expectOK(response string) error {
+if response != "OK" {
+return fmt.Errorf("unexpected response: %s", response)
+}
+return nil
+}
+
+func expectExists(response string) error {
+_, err := parseBoolReply(response)
+return err
+}
+
+func expectInt(response string) error {
+_, err := parseIntReply(response)
+return err
+}
@@ -0,0 +1,234 @@
+package nubdb
+
+import (
+"bufio"
+"errors"
+"io"
+"strconv"
+"strings"
+"testing"
+)
+
+func TestPipelineExec(t *testing.T) {
+client := connectFake(t, newMemStore().handle)
+
+p := client.Pipeline()
+p.Set("a", "1", 0)
+p.Incr("a")
+p.Get("a")
+p.Exists("b")
+responses, err := p.Exec()
+if err != nil {
+t.Fatalf("Exec: %v", err)
+}
+
+want := []string{"OK", "2", `"2"`, "0"}
+for i := range want {
+if responses[i] != want[i] {
+t.Errorf("response %d = %q, want %q", i, responses[i], want[i])
+}
+}
+if p.Len() != 0 {
+t.Errorf("pipeline not emptied after Exec")
+}
+}
+
+func TestPipelinePartialFailure(t *testing.T) {
+store := newMemStore()
+client := connectFake(t, func(line string) string {
+if line == "INCR bad" {
+return "ERROR: value is not an integer"
+}
+return store.handle(line)
+})
+
+p := client.Pipeline()
+p.Set("a", "1", 0)
+p.Incr("bad")
+p.Get("a")
+if got := strings.Join(p.Commands(), ","); got != "SET,INCR,GET" {
+t.Errorf("Commands() = %s", got)
+}
+responses, err := p.Exec()
+
+var perr *PipelineError
+if !errors.As(err, &perr) {
+t.Fatalf("Exec error = %v, want *PipelineError", err)
+}
+errs := perr.Errors()
+if len(errs) != 3 || errs[0] != nil || errs[1] == nil || errs[2] != nil {
+t.Fatalf("Errors() = %v", errs)
+}
+
+var serr *ServerError
+if !errors.As(err, &serr) || serr.Message != "value is not an integer" {
+t.Fatalf("expected wrapped ServerError, got %v", err)
+}
+var cerr *PipelineCommandError
+if !errors.As(errs[1], &cerr) || cerr.Command != "INCR" || cerr.Index != 1 {
+t.Fatalf("failed command = %+v, want INCR at 1", cerr)
+}
+if !strings.Contains(err.Error(), "#1 INCR") {
+t.Errorf("error message should name the command: %v", err)
+}
+if responses[2] != `"1"` {
+t.Errorf("successful commands should still report responses, got %q", responses[2])
+}
+}
+
+func TestPipelineAutoSplit(t *testing.T) {
+store := newMemStore()
+config := startFakeServer(t, store.handle)
+config.PipelineBatchSize = 7
+
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+var flushes int
+client.writer = bufio.NewWriterSize(countingWriter{client.conn, &flushes}, 1<<20)
+
+const n = 50
+p := client.Pipeline()
+for i := 0; i < n; i++ {
+p.Incr("counter")
+}
+responses, err := p.Exec()
+if err != nil {
+t.Fatalf("Exec: %v", err)
+}
+
+if len(responses) != n {
+t.Fatalf("got %d responses, want %d", len(responses), n)
+}
+for i, response := range responses {
+if response != strconv.Itoa(i+1) {
+t.Fatalf("response %d = %q, want %d", i, response, i+1)
+}
+}
+if want := (n + 6) / 7; flushes != want {
+t.Errorf("flushes = %d, want %d", flushes, want)
+}
+}
+
+func TestPipelineMaxBytes(t *testing.T) {
+store := newMemStore()
+config := startFakeServer(t, store.handle)
+config.PipelineMaxBytes = 100
+
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+var flushes int
+client.writer = bufio.NewWriterSize(countingWriter{client.conn, &flushes}, 1<<20)
+
+// "INCR counter\n" is 13 bytes, so every 8th command reaches the budget
+const n = 50
+p := client.Pipeline()
+for i := 0; i < n; i++ {
+p.Incr("counter")
+store.mu.Lock()
+counter, _ := strconv.Atoi(store.data["counter"])
+store.mu.Unlock()
+if sent := i + 1 - (i+1)%8; counter != sent {
+t.Fatalf("after queuing %d commands the server has counter = %d, want %d", i+1, counter, sent)
+}
+}
+if p.Len() != n {
+t.Errorf("Len = %d, want %d including the commands sent early", p.Len(), n)
+}
+responses, err := p.Exec()
+if err != nil {
+t.Fatalf("Exec: %v", err)
+}
+
+if len(responses) != n {
+t.Fatalf("got %d responses, want %d", len(responses), n)
+}
+for i, response := range responses {
+if response != strconv.Itoa(i+1) {
+t.Fatalf("response %d = %q, want %d", i, response, i+1)
+}
+}
+if want := n/8 + 1; flushes != want {
+t.Errorf("flushes = %d, want %d", flushes, want)
+}
+}
+
+func TestPipelineMaxBytesSendFails(t *testing.T) {
+config := startFakeServer(t, newMemStore().handle)
+config.PipelineMaxBytes = 10
+
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+client.conn.Close()
+p := client.Pipeline()
+p.Incr("counter")
+p.Incr("counter")
+if responses, err := p.Exec(); err == nil || responses != nil {
+t.Errorf("Exec after a failed early send = %q, %v, want the send error", responses, err)
+}
+if p.Len() != 0 {
+t.Errorf("pipeline not emptied after Exec")
+}
+}
+
+// countingWriter counts the writes reaching the connection
+type countingWriter struct {
+io.Writer
+n *int
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+*w.n++
+return w.Writer.Write(p)
+}
+
+func TestSetAllOK(t *testing.T) {
+store := newMemStore()
+client := connectFake(t, func(line string) string {
+switch {
+case strings.HasPrefix(line, "SET readonly"):
+return "ERROR: READONLY key is protected"
+case strings.HasPrefix(line, "SET weird"):
+return "QUEUED"
+}
+return store.handle(line)
+})
+
+ok, err := client.SetAllOK(map[string]string{"a": "1", "b": "2", "c": "3"}, 60)
+if !ok || err != nil {
+t.Fatalf("SetAllOK = %v, %v", ok, err)
+}
+if len(store.data) != 3 || store.ttl["b"] != 60 {
+t.Errorf("store has %v with TTLs %v", store.data, store.ttl)
+}
+
+ok, err = client.SetAllOK(map[string]string{"d": "4", "readonly": "x", "weird": "y"}, 0)
+if ok || err == nil {
+t.Fatalf("SetAllOK with failures = %v, %v", ok, err)
+}
+if !strings.Contains(err.Error(), "2 of 3 keys (readonly, weird)") {
+t.Errorf("error does not list the failed keys: %v", err)
+}
+var pipeErr *PipelineError
+if !errors.As(err, &pipeErr) || !errors.Is(err, ErrReadOnly) {
+t.Errorf("error should wrap the pipeline failures: %v", err)
+}
+if store.data["d"] != "4" {
+t.Error("the successful SET was not applied")
+}
+
+if ok, err := client.SetAllOK(nil, 0); !ok || err != nil {
+t.Errorf("SetAllOK(nil) = %v, %v", ok, err)
+}
+}
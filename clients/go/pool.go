@@ -0,0 +1,309 @@
+package nubdb
+
+import (
+"bufio"
+"context"
+"errors"
+"fmt"
+"os"
+"sync"
+"time"
+)
+
+// ErrPoolClosed is returned by ClientPool methods after Close
+var ErrPoolClosed = errors.New("pool closed")
+
+// ClientPool hands out up to a fixed number of Clients sharing one Config.
+// Connections are opened on demand and kept for reuse once returned. It is
+// safe for concurrent use.
+type ClientPool struct {
+config  Config
+slots   chan struct{} // one token per connection that may be checked out
+dialing chan struct{} // one token per connection being opened; nil if unlimited
+
+mu       sync.Mutex
+idle     []*Client
+active   map[*Client]bool // checked out
+closed   bool
+drained  chan struct{} // closed by Put once Shutdown waits for no active clients
+nextDial time.Time     // earliest start of the next dial under PoolDialInterval
+
+// buffers holds the *connBuffers of closed connections for new ones
+buffers sync.Pool
+}
+
+// connBuffers is a connection's buffered reader and writer
+type connBuffers struct {
+reader *bufio.Reader
+writer *bufio.Writer
+}
+
+// NewPool returns a pool of at most size connections described by config.
+// No connection is opened until the first Get.
+func NewPool(config *Config, size int) (*ClientPool, error) {
+if config == nil {
+config = DefaultConfig()
+}
+if err := config.Validate(); err != nil {
+return nil, err
+}
+if size <= 0 {
+return nil, fmt.Errorf("invalid pool size %d", size)
+}
+
+pool := &ClientPool{config: *config, slots: make(chan struct{}, size), active: make(map[*Client]bool)}
+if config.PoolMaxDialing > 0 {
+pool.dialing = make(chan struct{}, config.PoolMaxDialing)
+}
+return pool, nil
+}
+
+// Get checks out a Client, dialing a new connection if no idle one is
+// available. It waits while size connections are checked out, and for its
+// turn to dial under Config.PoolMaxDialing and Config.PoolDialInterval, up
+// to the deadline set by WithTimeout or WithDeadline. Return the Client
+// with Put.
+func (p *ClientPool) Get(opts ...CallOption) (*Client, error) {
+p.mu.Lock()
+closed := p.closed
+p.mu.Unlock()
+if closed {
+return nil, ErrPoolClosed
+}
+
+if err := p.acquire(opts); err != nil {
+return nil, err
+}
+
+p.mu.Lock()
+if p.closed {
+p.mu.Unlock()
+<-p.slots
+return nil, ErrPoolClosed
+}
+if n := len(p.idle); n > 0 {
+client := p.idle[n-1]
+p.idle = p.idle[:n-1]
+p.active[client] = true
+p.mu.Unlock()
+return client, nil
+}
+p.mu.Unlock()
+
+client, err := p.dial(opts)
+if err != nil {
+<-p.slots
+return nil, err
+}
+
+p.mu.Lock()
+defer p.mu.Unlock()
+if p.closed {
+p.closeClient(client)
+<-p.slots
+return nil, ErrPoolClosed
+}
+p.active[client] = true
+return client, nil
+}
+
+// acquire takes a connection slot, waiting until the call's deadline
+func (p *ClientPool) acquire(opts []CallOption) error {
+if !waitToken(p.slots, newCallOptions(opts).effectiveDeadline()) {
+return fmt.Errorf("waiting for a pool connection: %w", os.ErrDeadlineExceeded)
+}
+return nil
+}
+
+// dial opens a connection once the dial limits allow, waiting until the
+// call's deadline
+func (p *ClientPool) dial(opts []CallOption) (*Client, error) {
+deadline := newCallOptions(opts).effectiveDeadline()
+if p.dialing != nil {
+if !waitToken(p.dialing, deadline) {
+return nil, fmt.Errorf("waiting to dial a pool connection: %w", os.ErrDeadlineExceeded)
+}
+defer func() { <-p.dialing }()
+}
+
+if interval := p.config.PoolDialInterval; interval > 0 {
+p.mu.Lock()
+start := time.Now()
+if p.nextDial.After(start) {
+start = p.nextDial
+}
+if !deadline.IsZero() && start.After(deadline) {
+p.mu.Unlock()
+return nil, fmt.Errorf("waiting to dial a pool connection: %w", os.ErrDeadlineExceeded)
+}
+p.nextDial = start.Add(interval)
+p.mu.Unlock()
+time.Sleep(time.Until(start))
+}
+
+bufs, _ := p.buffers.Get().(*connBuffers)
+client, err := connect(&p.config, bufs)
+if err != nil && bufs != nil {
+p.buffers.Put(bufs)
+}
+return client, err
+}
+
+// closeClient closes a client of the pool and keeps its buffers for the
+// next connection. Buffers grown by Config.AdaptiveBuffers are dropped, so
+// the pool only holds default-sized ones. The client is left with minimal
+// buffers of its own, so that a caller misusing it after Put cannot touch
+// another connection's.
+func (p *ClientPool) closeClient(client *Client) error {
+err := client.Close()
+
+client.internalLock()
+bufs := &connBuffers{reader: client.reader, writer: client.writer}
+client.reader = bufio.NewReaderSize(client.conn, 16)
+client.writer = bufio.NewWriterSize(client.conn, 16)
+client.internalUnlock()
+
+if bufs.reader.Size() == minAdaptiveBufferSize && bufs.writer.Size() == minAdaptiveBufferSize {
+p.buffers.Put(bufs)
+}
+return err
+}
+
+// waitToken sends a token on sem, waiting until deadline, or for as long as
+// it takes if deadline is zero. It reports whether the token was sent.
+func waitToken(sem chan struct{}, deadline time.Time) bool {
+select {
+case sem <- struct{}{}:
+return true
+default:
+}
+
+var expired <-chan time.Time
+if !deadline.IsZero() {
+timer := time.NewTimer(time.Until(deadline))
+defer timer.Stop()
+expired = timer.C
+}
+
+select {
+case sem <- struct{}{}:
+return true
+case <-expired:
+return false
+}
+}
+
+// Put returns a Client checked out with Get. A client whose connection
+// is out of sync, or left in subscribe mode by a raw SUBSCRIBE, is closed
+// instead of being reused.
+func (p *ClientPool) Put(client *Client) {
+client.internalLock()
+reusable := !client.desynced && !client.idleClosed && !client.subscribed
+client.internalUnlock()
+
+p.mu.Lock()
+delete(p.active, client)
+if len(p.active) == 0 && p.drained != nil {
+close(p.drained)
+p.drained = nil
+}
+if reusable && !p.closed {
+p.idle = append(p.idle, client)
+p.mu.Unlock()
+} else {
+p.mu.Unlock()
+p.closeClient(client)
+}
+<-p.slots
+}
+
+// Close closes the idle connections and makes further Gets fail with
+// ErrPoolClosed. Clients still checked out are closed when they are Put.
+func (p *ClientPool) Close() error {
+p.mu.Lock()
+idle := p.idle
+p.idle = nil
+p.closed = true
+p.mu.Unlock()
+
+var errs []error
+for _, client := range idle {
+if err := p.closeClient(client); err != nil {
+errs = append(errs, err)
+}
+}
+return errors.Join(errs...)
+}
+
+// Shutdown closes the pool gracefully: Gets fail with ErrPoolClosed from
+// now on, idle connections are closed at once, and checked-out clients are
+// closed as they are Put. Shutdown waits for that until ctx is done, then
+// closes the connections of the remaining clients, failing any command in
+// progress, and returns ctx.Err().
+func (p *ClientPool) Shutdown(ctx context.Context) error {
+p.mu.Lock()
+p.closed = true
+idle := p.idle
+p.idle = nil
+var drained chan struct{}
+if len(p.active) > 0 {
+if p.drained == nil {
+p.drained = make(chan struct{})
+}
+drained = p.drained
+}
+p.mu.Unlock()
+
+var errs []error
+for _, client := range idle {
+if err := p.closeClient(client); err != nil {
+errs = append(errs, err)
+}
+}
+if drained == nil {
+return errors.Join(errs...)
+}
+
+select {
+case <-drained:
+return errors.Join(errs...)
+case <-ctx.Done():
+}
+
+p.mu.Lock()
+active := make([]*Client, 0, len(p.active))
+for client := range p.active {
+active = append(active, client)
+}
+p.mu.Unlock()
+for _, client := range active {
+client.interrupt()
+}
+return ctx.Err()
+}
+
+// Session is a Client checked out of a ClientPool for a series of
+// commands, so that reads see the session's own writes on the same
+// connection. Close returns the connection to the pool instead of closing it.
+type Session struct {
+*Client
+pool *ClientPool
+once sync.Once
+}
+
+// WithSession checks out one connection and holds it until the returned
+// Session is closed
+func (p *ClientPool) WithSession(opts ...CallOption) (*Session, error) {
+client, err := p.Get(opts...)
+if err != nil {
+return nil, err
+}
+return &Session{Client: client, pool: p}, nil
+}
+
+// Close releases the session's connection back to the pool. Calling it
+// more than once has no further effect.
+func (s *Session) Close() error {
+s.once.Do(func() { s.pool.Put(s.Client) })
+return nil
+}
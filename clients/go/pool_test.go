@@ -0,0 +1,411 @@
+package nubdb
+
+import (
+"bufio"
+"context"
+"errors"
+"fmt"
+"net"
+"os"
+"sort"
+"strings"
+"sync"
+"sync/atomic"
+"testing"
+"time"
+)
+
+// startConnIDServer starts a fake server that answers every command with
+// the number of the connection it arrived on, counting from 1
+func startConnIDServer(t testing.TB) *Config {
+t.Helper()
+
+ln, err := net.Listen("tcp", "127.0.0.1:0")
+if err != nil {
+t.Fatalf("listen: %v", err)
+}
+t.Cleanup(func() { ln.Close() })
+
+var conns atomic.Int64
+go func() {
+for {
+conn, err := ln.Accept()
+if err != nil {
+return
+}
+id := conns.Add(1)
+go func() {
+defer conn.Close()
+reader := bufio.NewReader(conn)
+for {
+line, err := reader.ReadString('\n')
+if err != nil || strings.TrimSpace(line) == "QUIT" {
+return
+}
+fmt.Fprintf(conn, "\"%d\"\n", id)
+}
+}()
+}
+}()
+
+config := DefaultConfig()
+config.Host = "127.0.0.1"
+config.Port = ln.Addr().(*net.TCPAddr).Port
+return config
+}
+
+func TestPoolReusesConnections(t *testing.T) {
+pool, err := NewPool(startConnIDServer(t), 2)
+if err != nil {
+t.Fatalf("NewPool: %v", err)
+}
+defer pool.Close()
+
+first, err := pool.Get()
+if err != nil {
+t.Fatalf("Get: %v", err)
+}
+second, err := pool.Get()
+if err != nil {
+t.Fatalf("Get: %v", err)
+}
+a, _ := first.Get("k")
+b, _ := second.Get("k")
+if a == b {
+t.Fatalf("two checked-out clients share connection %s", a)
+}
+
+if _, err := pool.Get(WithTimeout(20 * time.Millisecond)); !errors.Is(err, os.ErrDeadlineExceeded) {
+t.Fatalf("Get on an exhausted pool = %v, want deadline exceeded", err)
+}
+
+pool.Put(first)
+again, err := pool.Get()
+if err != nil {
+t.Fatalf("Get after Put: %v", err)
+}
+if c, _ := again.Get("k"); c != a {
+t.Errorf("Get after Put used connection %s, want idle connection %s", c, a)
+}
+pool.Put(again)
+pool.Put(second)
+
+pool.Close()
+if _, err := pool.Get(); !errors.Is(err, ErrPoolClosed) {
+t.Errorf("Get after Close = %v, want ErrPoolClosed", err)
+}
+}
+
+func TestPoolSessionPinsConnection(t *testing.T) {
+pool, err := NewPool(startConnIDServer(t), 2)
+if err != nil {
+t.Fatalf("NewPool: %v", err)
+}
+defer pool.Close()
+
+// Leave an idle connection in the pool so that unpinned commands would
+// be free to land on either one
+warm, _ := pool.Get()
+pool.Put(warm)
+
+session, err := pool.WithSession()
+if err != nil {
+t.Fatalf("WithSession: %v", err)
+}
+
+other, _ := pool.Get()
+otherID, _ := other.Get("k")
+pool.Put(other)
+
+write, _ := session.Get("k")
+read, _ := session.Get("k")
+if write != read {
+t.Errorf("session commands ran on connections %s and %s", write, read)
+}
+if write == otherID {
+t.Errorf("session connection %s was handed out while checked out", write)
+}
+
+session.Close()
+session.Close()
+
+// Both connections are idle again
+a, _ := pool.Get(WithTimeout(time.Second))
+b, err := pool.Get(WithTimeout(time.Second))
+if err != nil {
+t.Fatalf("session connection not released: %v", err)
+}
+pool.Put(a)
+pool.Put(b)
+}
+
+func TestPoolShutdownWaitsForCheckedOut(t *testing.T) {
+pool, err := NewPool(startConnIDServer(t), 2)
+if err != nil {
+t.Fatalf("NewPool: %v", err)
+}
+
+busy, _ := pool.Get()
+idle, _ := pool.Get()
+pool.Put(idle)
+
+done := make(chan error, 1)
+go func() {
+ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+defer cancel()
+done <- pool.Shutdown(ctx)
+}()
+
+// New acquisitions are refused while shutting down
+deadline := time.Now().Add(time.Second)
+for {
+client, err := pool.Get(WithTimeout(10 * time.Millisecond))
+if errors.Is(err, ErrPoolClosed) {
+break
+}
+if err == nil {
+pool.Put(client)
+}
+if time.Now().After(deadline) {
+t.Fatalf("Get during Shutdown = %v, want ErrPoolClosed", err)
+}
+}
+
+// The checked-out client finishes its work undisturbed
+if _, err := busy.Get("k"); err != nil {
+t.Errorf("command on a checked-out client during Shutdown: %v", err)
+}
+select {
+case err := <-done:
+t.Fatalf("Shutdown returned %v before the client was returned", err)
+case <-time.After(20 * time.Millisecond):
+}
+
+pool.Put(busy)
+if err := <-done; err != nil {
+t.Errorf("Shutdown = %v", err)
+}
+if _, err := busy.Get("k"); err == nil {
+t.Error("returned client still usable after Shutdown")
+}
+}
+
+func TestPoolShutdownForcesAfterDeadline(t *testing.T) {
+release := make(chan struct{})
+defer close(release)
+pool, err := NewPool(startFakeServer(t, func(line string) string {
+if line == "GET slow" {
+<-release
+}
+return "OK"
+}), 1)
+if err != nil {
+t.Fatalf("NewPool: %v", err)
+}
+
+client, _ := pool.Get()
+stuck := make(chan error, 1)
+go func() {
+_, err := client.Get("slow")
+stuck <- err
+}()
+time.Sleep(20 * time.Millisecond)
+
+ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+defer cancel()
+if err := pool.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+t.Errorf("Shutdown = %v, want context.DeadlineExceeded", err)
+}
+
+select {
+case err := <-stuck:
+if err == nil {
+t.Error("in-flight command succeeded after forced shutdown")
+}
+case <-time.After(2 * time.Second):
+t.Fatal("in-flight command not interrupted")
+}
+pool.Put(client)
+}
+
+func TestPoolDialLimits(t *testing.T) {
+config := startConnIDServer(t)
+var dialing, maxDialing atomic.Int64
+var mu sync.Mutex
+var starts []time.Time
+config.DialFunc = func(ctx context.Context, network, addr string) (net.Conn, error) {
+n := dialing.Add(1)
+defer dialing.Add(-1)
+for {
+peak := maxDialing.Load()
+if n <= peak || maxDialing.CompareAndSwap(peak, n) {
+break
+}
+}
+mu.Lock()
+starts = append(starts, time.Now())
+mu.Unlock()
+time.Sleep(20 * time.Millisecond)
+var d net.Dialer
+return d.DialContext(ctx, network, addr)
+}
+config.PoolMaxDialing = 2
+config.PoolDialInterval = 5 * time.Millisecond
+
+pool, err := NewPool(config, 10)
+if err != nil {
+t.Fatalf("NewPool: %v", err)
+}
+defer pool.Close()
+
+var wg sync.WaitGroup
+for i := 0; i < 10; i++ {
+wg.Add(1)
+go func() {
+defer wg.Done()
+client, err := pool.Get()
+if err != nil {
+t.Errorf("Get: %v", err)
+return
+}
+pool.Put(client)
+}()
+}
+wg.Wait()
+
+if n := maxDialing.Load(); n > 2 {
+t.Errorf("%d connections opened at once, want at most 2", n)
+}
+sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+for i := 1; i < len(starts); i++ {
+if gap := starts[i].Sub(starts[i-1]); gap < 4*time.Millisecond {
+t.Errorf("dials %d and %d started %s apart, want at least the 5ms interval", i-1, i, gap)
+}
+}
+}
+
+func TestPoolDialWaitDeadline(t *testing.T) {
+config := startConnIDServer(t)
+config.PoolDialInterval = time.Hour
+pool, err := NewPool(config, 2)
+if err != nil {
+t.Fatalf("NewPool: %v", err)
+}
+defer pool.Close()
+
+first, err := pool.Get()
+if err != nil {
+t.Fatalf("first Get: %v", err)
+}
+defer pool.Put(first)
+
+start := time.Now()
+_, err = pool.Get(WithTimeout(20 * time.Millisecond))
+if !errors.Is(err, os.ErrDeadlineExceeded) || time.Since(start) > time.Second {
+t.Errorf("Get waiting an hour to dial = %v after %s, want a prompt deadline error", err, time.Since(start))
+}
+}
+
+func TestPoolReusesBuffers(t *testing.T) {
+pool, err := NewPool(startConnIDServer(t), 1)
+if err != nil {
+t.Fatalf("NewPool: %v", err)
+}
+defer pool.Close()
+
+// sync.Pool may drop items, so look for any reuse over several rounds
+seen := make(map[*bufio.Reader]bool)
+reused := false
+for i := 0; i < 20 && !reused; i++ {
+client, err := pool.Get()
+if err != nil {
+t.Fatalf("Get: %v", err)
+}
+if value, err := client.Get("k"); err != nil || value != fmt.Sprint(i+1) {
+t.Fatalf("Get on connection %d = %q, %v", i+1, value, err)
+}
+reused = seen[client.reader]
+seen[client.reader] = true
+
+client.mu.Lock()
+client.desynced = true
+client.mu.Unlock()
+pool.Put(client)
+if seen[client.reader] {
+t.Fatal("closed client kept the buffers handed to the pool")
+}
+}
+if !reused {
+t.Error("no buffers were reused across 20 connections")
+}
+}
+
+func TestPoolPutSubscribed(t *testing.T) {
+store := newMemStore()
+config := startFakeServer(t, func(line string) string {
+if strings.HasPrefix(line, "SUBSCRIBE") {
+return multiLine("subscribe", "news", "1")
+}
+return store.handle(line)
+})
+pool, err := NewPool(config, 1)
+if err != nil {
+t.Fatalf("NewPool: %v", err)
+}
+defer pool.Close()
+
+client, err := pool.Get()
+if err != nil {
+t.Fatalf("Get: %v", err)
+}
+if _, err := client.DoArgs("SUBSCRIBE", "news"); err != nil {
+t.Fatalf("raw SUBSCRIBE: %v", err)
+}
+pool.Put(client)
+
+next, err := pool.Get()
+if err != nil {
+t.Fatalf("Get after Put: %v", err)
+}
+defer pool.Put(next)
+if next == client {
+t.Fatal("a client in subscribe mode went back into the pool")
+}
+if err := next.Set("k", "v", 0); err != nil {
+t.Errorf("Set on the next client: %v", err)
+}
+}
+
+func BenchmarkPoolChurn(b *testing.B) {
+config := startConnIDServer(b)
+b.Run("pooled", func(b *testing.B) {
+pool, err := NewPool(config, 1)
+if err != nil {
+b.Fatalf("NewPool: %v", err)
+}
+defer pool.Close()
+
+b.ReportAllocs()
+for i := 0; i < b.N; i++ {
+client, err := pool.Get()
+if err != nil {
+b.Fatalf("Get: %v", err)
+}
+// Out of sync, so Put closes the connection
+client.mu.Lock()
+client.desynced = true
+client.mu.Unlock()
+pool.Put(client)
+}
+})
+b.Run("unpooled", func(b *testing.B) {
+b.ReportAllocs()
+for i := 0; i < b.N; i++ {
+client, err := Connect(config)
+if err != nil {
+b.Fatalf("Connect: %v", err)
+}
+client.Close()
+}
+})
+}
@@ -0,0 +1,354 @@
+package nubdb
+
+import (
+"bufio"
+"errors"
+"fmt"
+"net"
+"sort"
+"strconv"
+"strings"
+"sync"
+)
+
+// Message is a message received on a Subscription. Pattern is set for
+// messages matched by a pattern subscription.
+type Message struct {
+Channel string
+Pattern string
+Payload string
+}
+
+// Subscription receives published messages on a dedicated connection,
+// separate from the Client that opened it, since a subscribed connection
+// cannot run other commands. Messages are delivered in order on Messages;
+// a consumer that falls behind holds up the connection rather than losing
+// messages.
+type Subscription struct {
+conn       net.Conn
+reader     *bufio.Reader
+writer     *bufio.Writer
+terminator string
+
+messages chan Message
+done     chan struct{} // closed by Close, so deliveries stop waiting
+
+mu       sync.Mutex // guards writer, err and the subscription sets
+err      error
+closed   bool
+channels map[string]bool
+patterns map[string]bool
+
+eventsOnce sync.Once
+events     chan KeyEvent
+}
+
+// subscribeVerbs put a connection into subscribe mode, after which it only
+// accepts subscribeModeVerbs
+var (
+subscribeVerbs     = map[string]bool{"SUBSCRIBE": true, "PSUBSCRIBE": true, "SSUBSCRIBE": true}
+subscribeModeVerbs = map[string]bool{
+"SUBSCRIBE": true, "PSUBSCRIBE": true, "SSUBSCRIBE": true, "UNSUBSCRIBE": true,
+"PUNSUBSCRIBE": true, "SUNSUBSCRIBE": true, "PING": true, "QUIT": true,
+}
+)
+
+// Subscribe opens a subscription to channels
+func (c *Client) Subscribe(channels ...string) (*Subscription, error) {
+return c.subscribe("SUBSCRIBE", channels)
+}
+
+// PSubscribe opens a subscription to the channels matching the glob
+// patterns
+func (c *Client) PSubscribe(patterns ...string) (*Subscription, error) {
+return c.subscribe("PSUBSCRIBE", patterns)
+}
+
+func (c *Client) subscribe(verb string, names []string) (*Subscription, error) {
+if len(names) == 0 {
+return nil, fmt.Errorf("%s: no channels", verb)
+}
+if err := c.checkAllowed(verb); err != nil {
+return nil, err
+}
+
+c.internalLock()
+endpoint := c.endpoint
+c.internalUnlock()
+
+conn, _, err := dial(&c.config, endpoint)
+if err != nil {
+return nil, err
+}
+
+s := &Subscription{
+conn:       conn,
+reader:     bufio.NewReader(conn),
+writer:     bufio.NewWriter(conn),
+terminator: c.terminator,
+messages:   make(chan Message, 100),
+done:       make(chan struct{}),
+channels:   make(map[string]bool),
+patterns:   make(map[string]bool),
+}
+
+// Read the confirmations synchronously so a refusal is returned here
+if err := s.send(verb, names); err != nil {
+conn.Close()
+return nil, err
+}
+for range names {
+frame, err := s.readFrame()
+if err != nil {
+conn.Close()
+return nil, err
+}
+if len(frame) == 0 || !strings.EqualFold(frame[0], verb) {
+conn.Close()
+return nil, fmt.Errorf("%s: unexpected reply %q", verb, frame)
+}
+s.handleFrame(frame)
+}
+
+go s.readLoop()
+return s, nil
+}
+
+// send writes a subscription command for names
+func (s *Subscription) send(verb string, names []string) error {
+s.mu.Lock()
+defer s.mu.Unlock()
+
+if s.closed {
+return errSubscriptionClosed
+}
+s.writer.WriteString(strings.Join(append([]string{verb}, names...), " ") + s.terminator)
+if err := s.writer.Flush(); err != nil {
+return fmt.Errorf("write error: %w", err)
+}
+return nil
+}
+
+var errSubscriptionClosed = errors.New("subscription closed")
+
+// readFrame reads one pushed reply: a "*<n>" header and n lines, or an
+// error line
+func (s *Subscription) readFrame() ([]string, error) {
+header, err := s.readLine()
+if err != nil {
+return nil, err
+}
+if err := parseServerError(header); err != nil {
+return nil, err
+}
+n, err := strconv.Atoi(strings.TrimPrefix(header, "*"))
+if !strings.HasPrefix(header, "*") || err != nil || n < 0 {
+return nil, fmt.Errorf("invalid response: %s", header)
+}
+
+frame := make([]string, n)
+for i := range frame {
+if frame[i], err = s.readLine(); err != nil {
+return nil, err
+}
+}
+return frame, nil
+}
+
+func (s *Subscription) readLine() (string, error) {
+line, err := s.reader.ReadString(s.terminator[len(s.terminator)-1])
+if err != nil {
+return "", fmt.Errorf("read error: %w", err)
+}
+return strings.TrimSpace(strings.TrimSuffix(line, s.terminator)), nil
+}
+
+// readLoop delivers messages until the connection fails or is closed
+func (s *Subscription) readLoop() {
+defer close(s.messages)
+
+for {
+frame, err := s.readFrame()
+if err != nil {
+s.mu.Lock()
+if !s.closed {
+s.err = err
+}
+s.mu.Unlock()
+return
+}
+
+if msg, ok := s.handleFrame(frame); ok {
+select {
+case s.messages <- msg:
+case <-s.done:
+return
+}
+}
+}
+}
+
+// handleFrame applies a pushed frame: a message to deliver, or the
+// confirmation of a change to the subscription sets
+func (s *Subscription) handleFrame(frame []string) (Message, bool) {
+if len(frame) == 0 {
+return Message{}, false
+}
+
+switch kind := strings.ToLower(frame[0]); {
+case kind == "message" && len(frame) == 3:
+return Message{Channel: frame[1], Payload: frame[2]}, true
+case kind == "pmessage" && len(frame) == 4:
+return Message{Pattern: frame[1], Channel: frame[2], Payload: frame[3]}, true
+case len(frame) >= 2:
+s.mu.Lock()
+defer s.mu.Unlock()
+switch kind {
+case "subscribe":
+s.channels[frame[1]] = true
+case "unsubscribe":
+delete(s.channels, frame[1])
+case "psubscribe":
+s.patterns[frame[1]] = true
+case "punsubscribe":
+delete(s.patterns, frame[1])
+}
+}
+return Message{}, false
+}
+
+// Subscribe adds channels to the live subscription. Messages on them
+// arrive once the server has processed the command; Channels reflects the
+// change when its confirmation has been read.
+func (s *Subscription) Subscribe(channels ...string) error {
+if len(channels) == 0 {
+return nil
+}
+return s.send("SUBSCRIBE", channels)
+}
+
+// Unsubscribe removes channels from the subscription, or every channel if
+// none are given. Messages already in flight may still be delivered.
+func (s *Subscription) Unsubscribe(channels ...string) error {
+return s.send("UNSUBSCRIBE", channels)
+}
+
+// PSubscribe adds glob patterns to the live subscription
+func (s *Subscription) PSubscribe(patterns ...string) error {
+if len(patterns) == 0 {
+return nil
+}
+return s.send("PSUBSCRIBE", patterns)
+}
+
+// PUnsubscribe removes patterns from the subscription, or every pattern if
+// none are given
+func (s *Subscription) PUnsubscribe(patterns ...string) error {
+return s.send("PUNSUBSCRIBE", patterns)
+}
+
+// Channels returns the channels and patterns the server has confirmed
+// the subscription is listening to, sorted
+func (s *Subscription) Channels() (channels, patterns []string) {
+s.mu.Lock()
+defer s.mu.Unlock()
+
+for name := range s.channels {
+channels = append(channels, name)
+}
+for name := range s.patterns {
+patterns = append(patterns, name)
+}
+sort.Strings(channels)
+sort.Strings(patterns)
+return channels, patterns
+}
+
+// Messages returns the channel messages are delivered on. It is closed
+// when the subscription is closed or its connection fails; Err then tells
+// the two apart.
+func (s *Subscription) Messages() <-chan Message {
+return s.messages
+}
+
+// Err returns the error that ended the subscription, or nil while it is
+// running and after Close
+func (s *Subscription) Err() error {
+s.mu.Lock()
+defer s.mu.Unlock()
+return s.err
+}
+
+// Close ends the subscription and closes its connection. Messages not yet
+// received from Messages or Events are dropped, so a consumer may stop
+// reading before calling Close.
+func (s *Subscription) Close() error {
+s.mu.Lock()
+if s.closed {
+s.mu.Unlock()
+return nil
+}
+s.closed = true
+close(s.done)
+s.mu.Unlock()
+
+return s.conn.Close()
+}
+
+// KeyEvent is a change to a key reported by keyspace notifications
+type KeyEvent struct {
+Key   string
+Event string // e.g. "set", "del", "expired"
+DB    int
+}
+
+// SubscribeKeyEvents subscribes to the keyspace notifications of keys
+// matching the glob pattern in every database. Read the events from Events.
+//
+// The server only publishes notifications when enabled in its
+// configuration (notify-keyspace-events including "K" plus the event
+// classes wanted, e.g. "KA" for all of them); otherwise the subscription
+// succeeds but stays silent.
+func (c *Client) SubscribeKeyEvents(pattern string) (*Subscription, error) {
+return c.PSubscribe("__keyspace@*__:" + pattern)
+}
+
+// Events returns the keyspace notifications of a subscription opened with
+// SubscribeKeyEvents, decoded from Messages. Other messages are dropped.
+// Use either Events or Messages on one subscription, not both.
+func (s *Subscription) Events() <-chan KeyEvent {
+s.eventsOnce.Do(func() {
+s.events = make(chan KeyEvent, cap(s.messages))
+go func() {
+defer close(s.events)
+for msg := range s.messages {
+if event, ok := parseKeyEvent(msg); ok {
+select {
+case s.events <- event:
+case <-s.done:
+return
+}
+}
+}
+}()
+})
+return s.events
+}
+
+// parseKeyEvent decodes a "__keyspace@<db>__:<key>" message whose payload
+// is the event name
+func parseKeyEvent(msg Message) (KeyEvent, bool) {
+rest, ok := strings.CutPrefix(msg.Channel, "__keyspace@")
+if !ok {
+return KeyEvent{}, false
+}
+db, key, ok := strings.Cut(rest, "__:")
+if !ok {
+return KeyEvent{}, false
+}
+n, err := strconv.Atoi(db)
+if err != nil {
+return KeyEvent{}, false
+}
+return KeyEvent{Key: key, Event: msg.Payload, DB: n}, true
+}
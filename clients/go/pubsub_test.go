@@ -0,0 +1,316 @@
+package nubdb
+
+import (
+"bufio"
+"errors"
+"fmt"
+"net"
+"strings"
+"sync"
+"testing"
+"time"
+)
+
+// pubsubServer accepts subscriptions and lets the test publish to them.
+// Other commands are answered by handle, or with "OK" if it is nil.
+type pubsubServer struct {
+mu     sync.Mutex
+conns  []net.Conn
+subs   chan string // each subscription command, as received
+handle func(line string) string
+}
+
+func startPubSubServer(t *testing.T) (*pubsubServer, *Config) {
+t.Helper()
+
+ln, err := net.Listen("tcp", "127.0.0.1:0")
+if err != nil {
+t.Fatalf("listen: %v", err)
+}
+t.Cleanup(func() { ln.Close() })
+
+s := &pubsubServer{subs: make(chan string, 10)}
+go func() {
+for {
+conn, err := ln.Accept()
+if err != nil {
+return
+}
+go s.serve(conn)
+}
+}()
+
+config := DefaultConfig()
+config.Host = "127.0.0.1"
+config.Port = ln.Addr().(*net.TCPAddr).Port
+return s, config
+}
+
+func (s *pubsubServer) serve(conn net.Conn) {
+defer conn.Close()
+reader := bufio.NewReader(conn)
+for {
+line, err := reader.ReadString('\n')
+if err != nil {
+return
+}
+fields := strings.Fields(line)
+switch fields[0] {
+case "SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE":
+s.mu.Lock()
+if fields[0] == "SUBSCRIBE" || fields[0] == "PSUBSCRIBE" {
+s.conns = append(s.conns, conn)
+}
+for i, name := range fields[1:] {
+fmt.Fprintf(conn, "*3\n%s\n%s\n%d\n", strings.ToLower(fields[0]), name, i+1)
+}
+s.mu.Unlock()
+s.subs <- strings.TrimSpace(line)
+case "QUIT":
+return
+default:
+s.mu.Lock()
+if s.handle != nil {
+fmt.Fprint(conn, s.handle(strings.TrimSpace(line))+"\n")
+} else {
+fmt.Fprint(conn, "OK\n")
+}
+s.mu.Unlock()
+}
+}
+}
+
+// push writes a raw frame to every subscribed connection
+func (s *pubsubServer) push(lines ...string) {
+s.mu.Lock()
+defer s.mu.Unlock()
+for _, conn := range s.conns {
+fmt.Fprint(conn, strings.Join(lines, "\n")+"\n")
+}
+}
+
+func receive[T any](t *testing.T, ch <-chan T) T {
+t.Helper()
+select {
+case v, ok := <-ch:
+if !ok {
+t.Fatal("channel closed")
+}
+return v
+case <-time.After(2 * time.Second):
+t.Fatal("timed out waiting for a message")
+}
+panic("unreachable")
+}
+
+func TestSubscribe(t *testing.T) {
+server, config := startPubSubServer(t)
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+sub, err := client.Subscribe("news", "alerts")
+if err != nil {
+t.Fatalf("Subscribe: %v", err)
+}
+if cmd := receive(t, server.subs); cmd != "SUBSCRIBE news alerts" {
+t.Errorf("sent %q", cmd)
+}
+
+server.push("*3", "message", "alerts", "disk full")
+msg := receive(t, sub.Messages())
+if msg.Channel != "alerts" || msg.Payload != "disk full" || msg.Pattern != "" {
+t.Errorf("message = %+v", msg)
+}
+
+// The client's own connection is unaffected
+if err := client.Set("k", "v", 0); err != nil {
+t.Errorf("Set while subscribed: %v", err)
+}
+
+sub.Close()
+if _, ok := <-sub.Messages(); ok {
+t.Error("Messages not closed after Close")
+}
+if err := sub.Err(); err != nil {
+t.Errorf("Err after Close = %v, want nil", err)
+}
+}
+
+func TestSubscriptionCloseWithoutReading(t *testing.T) {
+server, config := startPubSubServer(t)
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+for _, events := range []bool{false, true} {
+sub, err := client.Subscribe("news")
+if err != nil {
+t.Fatalf("Subscribe: %v", err)
+}
+receive(t, server.subs)
+if events {
+sub.Events()
+}
+
+// Fill the buffers so delivery blocks on a consumer that never reads
+channel := "news"
+if events {
+channel = "__keyspace@0__:k"
+}
+for i := 0; i < 2*cap(sub.messages)+10; i++ {
+server.push("*3", "message", channel, fmt.Sprint(i))
+}
+for deadline := time.Now().Add(2 * time.Second); len(sub.messages) < cap(sub.messages); {
+if time.Now().After(deadline) {
+t.Fatalf("events=%v: messages never filled the buffer", events)
+}
+time.Sleep(time.Millisecond)
+}
+sub.Close()
+
+ch := sub.Messages()
+if events {
+ch = nil
+}
+timeout := time.After(2 * time.Second)
+for open := true; open; {
+select {
+case _, open = <-ch:
+case _, open = <-sub.Events():
+case <-timeout:
+t.Fatalf("events=%v: channel not closed after Close; delivery is stuck", events)
+}
+}
+}
+}
+
+func TestSubscribeKeyEvents(t *testing.T) {
+server, config := startPubSubServer(t)
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+sub, err := client.SubscribeKeyEvents("user:*")
+if err != nil {
+t.Fatalf("SubscribeKeyEvents: %v", err)
+}
+defer sub.Close()
+if cmd := receive(t, server.subs); cmd != "PSUBSCRIBE __keyspace@*__:user:*" {
+t.Errorf("sent %q", cmd)
+}
+
+events := sub.Events()
+server.push("*4", "pmessage", "__keyspace@*__:user:*", "__keyspace@0__:user:42", "set")
+server.push("*4", "pmessage", "__keyspace@*__:user:*", "__keyspace@3__:user:7", "expired")
+
+if event := receive(t, events); event != (KeyEvent{Key: "user:42", Event: "set", DB: 0}) {
+t.Errorf("first event = %+v", event)
+}
+if event := receive(t, events); event != (KeyEvent{Key: "user:7", Event: "expired", DB: 3}) {
+t.Errorf("second event = %+v", event)
+}
+}
+
+func TestSubscriptionAddRemoveChannels(t *testing.T) {
+server, config := startPubSubServer(t)
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+sub, err := client.Subscribe("a")
+if err != nil {
+t.Fatalf("Subscribe: %v", err)
+}
+defer sub.Close()
+receive(t, server.subs)
+
+// Interleave a message with the new subscription's confirmation
+server.push("*3", "message", "a", "before")
+if err := sub.Subscribe("b"); err != nil {
+t.Fatalf("Subscription.Subscribe: %v", err)
+}
+if cmd := receive(t, server.subs); cmd != "SUBSCRIBE b" {
+t.Errorf("sent %q", cmd)
+}
+server.push("*3", "message", "b", "after")
+
+if msg := receive(t, sub.Messages()); msg.Channel != "a" || msg.Payload != "before" {
+t.Errorf("first message = %+v", msg)
+}
+if msg := receive(t, sub.Messages()); msg.Channel != "b" || msg.Payload != "after" {
+t.Errorf("second message = %+v", msg)
+}
+if channels, _ := sub.Channels(); strings.Join(channels, ",") != "a,b" {
+t.Errorf("channels = %v, want [a b]", channels)
+}
+
+if err := sub.Unsubscribe("a"); err != nil {
+t.Fatalf("Unsubscribe: %v", err)
+}
+if cmd := receive(t, server.subs); cmd != "UNSUBSCRIBE a" {
+t.Errorf("sent %q", cmd)
+}
+// The confirmation is read asynchronously
+deadline := time.Now().Add(2 * time.Second)
+for {
+channels, _ := sub.Channels()
+if strings.Join(channels, ",") == "b" {
+break
+}
+if time.Now().After(deadline) {
+t.Fatalf("channels = %v after Unsubscribe, want [b]", channels)
+}
+time.Sleep(time.Millisecond)
+}
+}
+
+func TestSubscribeModeGuard(t *testing.T) {
+store := newMemStore()
+var sent []string
+client := connectFake(t, func(line string) string {
+sent = append(sent, line)
+if strings.HasPrefix(line, "SUBSCRIBE") || strings.HasPrefix(line, "UNSUBSCRIBE") {
+return multiLine(strings.ToLower(strings.Fields(line)[0]), "news", "1")
+}
+return store.handle(line)
+})
+
+if _, err := client.DoArgs("SUBSCRIBE", "news"); err != nil {
+t.Fatalf("raw SUBSCRIBE: %v", err)
+}
+
+sent = nil
+if _, err := client.Get("k"); !errors.Is(err, ErrInSubscribeMode) {
+t.Errorf("Get while subscribed = %v, want ErrInSubscribeMode", err)
+}
+if err := client.Set("k", "v", 0); !errors.Is(err, ErrInSubscribeMode) {
+t.Errorf("Set while subscribed = %v, want ErrInSubscribeMode", err)
+}
+p := client.Pipeline()
+p.Get("k")
+if _, err := p.Exec(); !errors.Is(err, ErrInSubscribeMode) {
+t.Errorf("pipeline while subscribed = %v, want ErrInSubscribeMode", err)
+}
+if len(sent) != 0 {
+t.Errorf("server got %q while subscribed", sent)
+}
+if _, err := client.DoArgs("UNSUBSCRIBE", "news"); errors.Is(err, ErrInSubscribeMode) {
+t.Errorf("UNSUBSCRIBE should be allowed while subscribed")
+}
+
+if err := client.Reset(); err != nil {
+t.Fatalf("Reset: %v", err)
+}
+if err := client.Set("k", "v", 0); err != nil {
+t.Errorf("Set after Reset: %v", err)
+}
+}
@@ -0,0 +1,88 @@
+package nubdb
+
+import (
+"encoding/json"
+"errors"
+"time"
+)
+
+// recentCommandsSize is how many entries RecentCommands and RecentErrors
+// remember
+const recentCommandsSize = 32
+
+// ring remembers the last recentCommandsSize items added to it
+type ring[T any] struct {
+items [recentCommandsSize]T
+next  int
+full  bool
+}
+
+func (r *ring[T]) add(item T) {
+r.items[r.next] = item
+r.next = (r.next + 1) % len(r.items)
+if r.next == 0 {
+r.full = true
+}
+}
+
+// list returns the remembered items, oldest first
+func (r *ring[T]) list() []T {
+if !r.full {
+return append([]T{}, r.items[:r.next]...)
+}
+return append(append([]T{}, r.items[r.next:]...), r.items[:r.next]...)
+}
+
+// RecentCommands returns the verbs of the last commands written to the
+// server, oldest first, for postmortems of a hung or dropped connection.
+// Keys and values are not recorded. It holds up to 32 entries and
+// survives Reset, so the commands leading up to a failure remain visible.
+func (c *Client) RecentCommands() []string {
+c.recentMu.Lock()
+defer c.recentMu.Unlock()
+return c.recent.list()
+}
+
+// CommandError is a failed command remembered by RecentErrors. Only the
+// command's verb is kept, never its keys or values.
+type CommandError struct {
+Time    time.Time `json:"time"`
+Command string    `json:"command"` // verb, e.g. "GET"
+Err     string    `json:"error"`
+}
+
+// recordErrors remembers the failure of cmds for RecentErrors: err if the
+// commands failed as a whole, which is recorded against the first of them,
+// otherwise each error reply among responses
+func (c *Client) recordErrors(cmds []string, responses []string, err error) {
+now := time.Now()
+c.recentMu.Lock()
+defer c.recentMu.Unlock()
+
+if err != nil {
+c.failed.add(CommandError{Time: now, Command: commandVerb(cmds[0]), Err: err.Error()})
+return
+}
+for i, response := range responses {
+var serverErr *ServerError
+if errors.As(parseServerError(response), &serverErr) {
+c.failed.add(CommandError{Time: now, Command: commandVerb(cmds[i]), Err: serverErr.Error()})
+}
+}
+}
+
+// RecentErrors returns the last failed commands, oldest first, for support
+// bundles and diagnosing intermittent failures. It holds up to 32 entries:
+// commands that failed on the connection or with an error reply, including
+// those of pipelines. Failures detected only after the reply was parsed,
+// such as an unexpected value, are not included. It survives Reset.
+func (c *Client) RecentErrors() []CommandError {
+c.recentMu.Lock()
+defer c.recentMu.Unlock()
+return c.failed.list()
+}
+
+// RecentErrorsJSON returns RecentErrors as a JSON array
+func (c *Client) RecentErrorsJSON() ([]byte, error) {
+return json.Marshal(c.RecentErrors())
+}
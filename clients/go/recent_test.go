@@ -0,0 +1,104 @@
+package nubdb
+
+import (
+"encoding/json"
+"strings"
+"testing"
+"time"
+)
+
+func TestRecentCommands(t *testing.T) {
+client := connectFake(t, newMemStore().handle)
+
+if got := client.RecentCommands(); len(got) != 0 {
+t.Fatalf("RecentCommands before any command = %v", got)
+}
+
+client.Set("secret-key", "secret-value", 0)
+client.Get("secret-key")
+if got := strings.Join(client.RecentCommands(), ","); got != "SET,GET" {
+t.Errorf("RecentCommands = %s, want SET,GET", got)
+}
+
+// Overflow the ring: it keeps the last recentCommandsSize, oldest first
+for i := 0; i < recentCommandsSize; i++ {
+client.Incr("n")
+}
+client.Delete("n")
+
+got := client.RecentCommands()
+if len(got) != recentCommandsSize {
+t.Fatalf("RecentCommands holds %d entries, want %d", len(got), recentCommandsSize)
+}
+if got[0] != "INCR" || got[len(got)-1] != "DELETE" {
+t.Errorf("RecentCommands = %v, want INCRs then DELETE", got)
+}
+for _, verb := range got {
+if strings.Contains(verb, "secret") {
+t.Errorf("RecentCommands leaks arguments: %q", verb)
+}
+}
+}
+
+func TestRecentErrors(t *testing.T) {
+store := newMemStore()
+client := connectFake(t, func(line string) string {
+if strings.HasPrefix(line, "INCR") {
+return "ERROR: value is not an integer"
+}
+return store.handle(line)
+})
+
+if got := client.RecentErrors(); len(got) != 0 {
+t.Fatalf("RecentErrors before any failure = %v", got)
+}
+if data, err := client.RecentErrorsJSON(); err != nil || string(data) != "[]" {
+t.Errorf("RecentErrorsJSON with no errors = %s, %v", data, err)
+}
+
+before := time.Now()
+client.Set("secret-key", "secret-value", 0)
+client.Incr("secret-key")
+p := client.Pipeline()
+p.Get("secret-key")
+p.Incr("secret-key")
+p.Exec()
+
+got := client.RecentErrors()
+if len(got) != 2 {
+t.Fatalf("RecentErrors = %+v, want the two failed INCRs", got)
+}
+for _, e := range got {
+if e.Command != "INCR" || e.Err != "server error: value is not an integer" || e.Time.Before(before) {
+t.Errorf("recorded %+v", e)
+}
+}
+
+// Overflow the ring: it keeps the last recentCommandsSize, oldest first
+for i := 0; i < recentCommandsSize; i++ {
+client.Incr("n")
+}
+client.DoArgs("BOGUS", "secret-value")
+got = client.RecentErrors()
+if len(got) != recentCommandsSize {
+t.Fatalf("RecentErrors holds %d entries, want %d", len(got), recentCommandsSize)
+}
+if got[0].Command != "INCR" || got[len(got)-1].Command != "BOGUS" {
+t.Errorf("RecentErrors runs from %s to %s, want INCR to BOGUS", got[0].Command, got[len(got)-1].Command)
+}
+
+data, err := client.RecentErrorsJSON()
+if err != nil {
+t.Fatalf("RecentErrorsJSON: %v", err)
+}
+var decoded []map[string]interface{}
+if err := json.Unmarshal(data, &decoded); err != nil || len(decoded) != recentCommandsSize {
+t.Fatalf("RecentErrorsJSON = %s, %v", data, err)
+}
+if decoded[len(decoded)-1]["command"] != "BOGUS" || decoded[len(decoded)-1]["error"] == nil {
+t.Errorf("last JSON entry = %v", decoded[len(decoded)-1])
+}
+if strings.Contains(string(data), "secret") {
+t.Errorf("RecentErrorsJSON leaks arguments: %s", data)
+}
+}
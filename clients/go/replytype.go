@@ -0,0 +1,139 @@
+package nubdb
+
+import (
+"fmt"
+"strconv"
+"strings"
+)
+
+// replyAny is the expectation of commands whose reply type is not checked
+const replyAny ReplyType = 1
+
+// replyConditional is the expectation of conditional writes such as SET NX:
+// a status when the write happened, or a null or integer when it did not
+const replyConditional ReplyType = 2
+
+// checkReply fails with ErrUnexpectedReplyType if response, the first line
+// of the reply to cmd, is not of type want, and otherwise returns the
+// reply's line. Error replies always pass, for the caller to report, and a
+// null passes for bulk and array replies. The integer reply to INCR or DECR
+// may also come as the single element of an array, as a server batching
+// counter replies would send it, and is returned as if it had come alone.
+// The rest of a mismatched reply is discarded so the connection stays in
+// step.
+//
+// Framed replies carry their type. Line replies do not, so their type is
+// guessed from the text and only clear mismatches are caught: an array
+// where a single value is expected, or a quoted or missing value where a
+// status or integer is; the caller's parsing rejects the rest. The caller
+// must hold c.mu.
+func (c *Client) checkReply(cmd string, want ReplyType, response string) (string, error) {
+if want == replyAny {
+return response, nil
+}
+
+got := c.framedReply.Type
+if !c.framed {
+got = lineReplyType(response)
+}
+if got == ReplyError || replyTypeMatches(want, got, c.framed) {
+return response, nil
+}
+if want == ReplyInteger && got == ReplyArray && counterVerbs[commandVerb(cmd)] {
+if elem, ok, err := c.singleInteger(response); ok || err != nil {
+return elem, err
+}
+}
+
+if err := c.discardReply(got, response); err != nil {
+return "", err
+}
+verb, _, _ := strings.Cut(cmd, " ")
+return "", fmt.Errorf("%w: %s reply to %s, want %s", ErrUnexpectedReplyType, got, verb, want)
+}
+
+// counterVerbs are the commands whose integer reply may come as a
+// one-element array
+var counterVerbs = map[string]bool{"INCR": true, "DECR": true}
+
+// singleInteger reads the element of an array reply whose header was
+// response if the array holds one integer, reporting whether it did. It
+// reads nothing for other arrays. The caller must hold c.mu.
+func (c *Client) singleInteger(response string) (string, bool, error) {
+if c.framed {
+array := c.framedReply.Array
+if len(array) != 1 || array[0].Type != ReplyInteger {
+return "", false, nil
+}
+c.framedLines = nil
+return strconv.FormatInt(array[0].Int, 10), true, nil
+}
+
+if response != "*1" {
+return "", false, nil
+}
+elem, err := c.readLine()
+if err != nil {
+return "", false, err
+}
+if lineReplyType(elem) != ReplyInteger {
+return "", false, fmt.Errorf("%w: array of %s, want integer", ErrUnexpectedReplyType, lineReplyType(elem))
+}
+return elem, true, nil
+}
+
+// replyTypeMatches reports whether a reply of type got satisfies want
+func replyTypeMatches(want, got ReplyType, framed bool) bool {
+switch {
+case want == replyConditional:
+return got == ReplyStatus || got == ReplyNull || got == ReplyInteger
+case want == ReplyBulk && !framed:
+// Values may come back unquoted
+return got != ReplyArray
+case want == ReplyStatus || want == ReplyInteger:
+// SIZE answers "N keys", which reads as a status line
+return got == want || (!framed && (got == ReplyStatus || got == ReplyInteger))
+}
+return got == want || got == ReplyNull
+}
+
+// lineReplyType guesses the type of a line reply from its first line
+func lineReplyType(response string) ReplyType {
+switch {
+case parseServerError(response) != nil:
+return ReplyError
+case isNotFound(response):
+return ReplyNull
+case strings.HasPrefix(response, `"`):
+return ReplyBulk
+case strings.HasPrefix(response, "*"):
+if _, err := strconv.Atoi(response[1:]); err == nil {
+return ReplyArray
+}
+}
+if _, err := strconv.ParseInt(response, 10, 64); err == nil {
+return ReplyInteger
+}
+return ReplyStatus
+}
+
+// discardReply reads and drops the lines left of a reply whose first line
+// was response. The caller must hold c.mu.
+func (c *Client) discardReply(typ ReplyType, response string) error {
+if c.framed {
+// The reply was read whole; only its rendered lines remain
+c.framedLines = nil
+return nil
+}
+if typ != ReplyArray {
+return nil
+}
+
+n, _ := strconv.Atoi(response[1:])
+for i := 0; i < n; i++ {
+if _, err := c.readLine(); err != nil {
+return err
+}
+}
+return nil
+}
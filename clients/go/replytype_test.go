@@ -0,0 +1,180 @@
+package nubdb
+
+import (
+"errors"
+"testing"
+)
+
+func TestUnexpectedReplyTypeFramed(t *testing.T) {
+config := startFakeServerTerm(t, "\r\n", func(line string) string {
+switch line {
+case `SET k "v"`:
+return ":1"
+case "GET k":
+return "*2\r\n$1\r\na\r\n$1\r\nb"
+case "INCR n":
+return "$1\r\n7"
+case "SIZE":
+return "+OK"
+case "GET ok":
+return "$2\r\nok"
+case `SET k "v" NX`:
+return "$1\r\nv"
+case `SET taken "v" NX`:
+return "$-1"
+}
+return "-ERR unknown command"
+})
+config.FramedReplies = true
+config.StrictReplies = true
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+checks := []struct {
+name string
+call func() error
+}{
+{"Set", func() error { return client.Set("k", "v", 0) }},
+{"SetWith", func() error { _, err := client.SetWith("k", "v"); return err }},
+{"SetWith NX", func() error { _, err := client.SetWith("k", "v", WithNX()); return err }},
+{"Get", func() error { _, err := client.Get("k"); return err }},
+{"Incr", func() error { _, err := client.Incr("n"); return err }},
+{"Size", func() error { _, err := client.Size(); return err }},
+}
+for _, check := range checks {
+if err := check.call(); !errors.Is(err, ErrUnexpectedReplyType) {
+t.Errorf("%s = %v, want ErrUnexpectedReplyType", check.name, err)
+}
+// The connection must still be in step
+if value, err := client.Get("ok"); err != nil || value != "ok" {
+t.Fatalf("Get after %s = %q, %v", check.name, value, err)
+}
+}
+
+// A null is how SET NX reports a key it left alone
+if stored, err := client.SetWith("taken", "v", WithNX()); err != nil || stored {
+t.Errorf("SetWith NX of a taken key = %v, %v", stored, err)
+}
+
+// Error replies are reported as such
+var serverErr *ServerError
+if _, err := client.Incr("other"); !errors.As(err, &serverErr) {
+t.Errorf("Incr = %v, want a *ServerError", err)
+}
+}
+
+func TestUnexpectedReplyTypeLines(t *testing.T) {
+config := startFakeServer(t, func(line string) string {
+switch line {
+case `SET k "v"`:
+return `"v"`
+case "GET k":
+return "*2\na\nb"
+case "INCR n":
+return "(nil)"
+case "SIZE":
+return "*1\n5"
+case "GET ok":
+return "ok"
+}
+return "ERROR: unknown command"
+})
+config.StrictReplies = true
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+checks := []struct {
+name string
+call func() error
+}{
+{"Set", func() error { return client.Set("k", "v", 0) }},
+{"Get", func() error { _, err := client.Get("k"); return err }},
+{"Incr", func() error { _, err := client.Incr("n"); return err }},
+{"Size", func() error { _, err := client.Size(); return err }},
+}
+for _, check := range checks {
+if err := check.call(); !errors.Is(err, ErrUnexpectedReplyType) {
+t.Errorf("%s = %v, want ErrUnexpectedReplyType", check.name, err)
+}
+// An unquoted value is still a value
+if value, err := client.Get("ok"); err != nil || value != "ok" {
+t.Fatalf("Get after %s = %q, %v", check.name, value, err)
+}
+}
+}
+
+func TestLineReplyType(t *testing.T) {
+tests := map[string]ReplyType{
+"OK":          ReplyStatus,
+"5 keys":      ReplyStatus,
+"42":          ReplyInteger,
+"-1":          ReplyInteger,
+`"v"`:         ReplyBulk,
+"(nil)":       ReplyNull,
+"(not found)": ReplyNull,
+"*3":          ReplyArray,
+"*x":          ReplyStatus,
+"ERROR: nope": ReplyError,
+}
+for response, want := range tests {
+if got := lineReplyType(response); got != want {
+t.Errorf("lineReplyType(%q) = %s, want %s", response, got, want)
+}
+}
+}
+
+func TestIncrArrayReply(t *testing.T) {
+for _, tt := range []struct {
+name    string
+term    string
+framed  bool
+replies map[string]string
+}{
+{"lines", "\n", false, map[string]string{
+"INCR scalar": "7", "INCR array": "*1\n7", "DECR array": "*1\n-3",
+"INCR text": "*1\n\"x\"", "INCR pair": "*2\n1\n2", "GET ok": `"ok"`,
+}},
+{"framed", "\r\n", true, map[string]string{
+"INCR scalar": ":7", "INCR array": "*1\r\n:7", "DECR array": "*1\r\n:-3",
+"INCR text": "*1\r\n$1\r\nx", "INCR pair": "*2\r\n:1\r\n:2", "GET ok": "$2\r\nok",
+}},
+} {
+config := startFakeServerTerm(t, tt.term, func(line string) string {
+if reply, ok := tt.replies[line]; ok {
+return reply
+}
+return "ERROR: unknown command"
+})
+config.FramedReplies = tt.framed
+config.StrictReplies = true
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("%s: connect: %v", tt.name, err)
+}
+defer client.Close()
+
+if n, err := client.Incr("scalar"); err != nil || n != 7 {
+t.Errorf("%s: Incr of a scalar reply = %d, %v", tt.name, n, err)
+}
+if n, err := client.Incr("array"); err != nil || n != 7 {
+t.Errorf("%s: Incr of a one-element array = %d, %v", tt.name, n, err)
+}
+if n, err := client.Decr("array"); err != nil || n != -3 {
+t.Errorf("%s: Decr of a one-element array = %d, %v", tt.name, n, err)
+}
+for _, key := range []string{"text", "pair"} {
+if _, err := client.Incr(key); !errors.Is(err, ErrUnexpectedReplyType) {
+t.Errorf("%s: Incr of %s = %v, want ErrUnexpectedReplyType", tt.name, key, err)
+}
+if value, err := client.Get("ok"); err != nil || value != "ok" {
+t.Fatalf("%s: Get after Incr of %s = %q, %v", tt.name, key, value, err)
+}
+}
+}
+}
@@ -0,0 +1,53 @@
+package nubdb
+
+import (
+"errors"
+"io"
+"net"
+"syscall"
+)
+
+// retryableReads are the verbs Config.RetryReads may send twice: commands
+// that only read, so repeating one after a lost reply changes nothing
+var retryableReads = map[string]bool{
+"GET": true, "EXISTS": true, "SIZE": true, "TTL": true, "PTTL": true,
+"GETRANGE": true, "TYPE": true, "SCAN": true, "INFO": true,
+"SMEMBERS": true, "SISMEMBER": true, "LRANGE": true, "LLEN": true,
+"HGET": true, "HGETALL": true, "HEXISTS": true,
+}
+
+// retryRead reports whether cmds, which just failed with err, should be
+// sent again, having reconnected for them and applied the call's deadline
+// to the new connection. That is only when Config.RetryReads is set, every
+// command is a read and err shows the connection dropped, not a server
+// error or a timeout. The caller must hold c.mu and must retry at most once.
+func (c *Client) retryRead(cmds []string, err error, opts []CallOption) bool {
+if !c.config.RetryReads || !isConnFault(err) {
+return false
+}
+for _, cmd := range cmds {
+if !retryableReads[commandVerb(cmd)] {
+return false
+}
+}
+deadline := newCallOptions(opts).effectiveDeadline()
+if checkDeadline(deadline) != nil || c.backoffRedial(c.endpoint, deadline) != nil {
+return false
+}
+if !deadline.IsZero() {
+c.conn.SetDeadline(deadline)
+}
+return true
+}
+
+// isConnFault reports whether err is the server or network dropping the
+// connection, as opposed to the server rejecting a command, a deadline
+// passing or the client itself closing the connection (net.ErrClosed)
+func isConnFault(err error) bool {
+var netErr net.Error
+if errors.As(err, &netErr) && netErr.Timeout() {
+return false
+}
+return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
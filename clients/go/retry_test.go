@@ -0,0 +1,123 @@
+package nubdb
+
+import (
+"bufio"
+"errors"
+"net"
+"strings"
+"sync/atomic"
+"testing"
+)
+
+// startDroppingServer is startFakeServer, except that the first drops
+// commands to arrive make the server close their connection unanswered
+func startDroppingServer(t *testing.T, drops int64, handle func(line string) string) (*Config, *atomic.Int64) {
+t.Helper()
+
+ln, err := net.Listen("tcp", "127.0.0.1:0")
+if err != nil {
+t.Fatalf("listen: %v", err)
+}
+t.Cleanup(func() { ln.Close() })
+
+var remaining atomic.Int64
+remaining.Store(drops)
+go func() {
+for {
+conn, err := ln.Accept()
+if err != nil {
+return
+}
+go func() {
+defer conn.Close()
+reader := bufio.NewReader(conn)
+for {
+line, err := reader.ReadString('\n')
+if err != nil {
+return
+}
+line = strings.TrimSuffix(line, "\n")
+if line == "QUIT" || remaining.Add(-1) >= 0 {
+return
+}
+conn.Write([]byte(handle(line) + "\n"))
+}
+}()
+}
+}()
+
+config := DefaultConfig()
+config.Host = "127.0.0.1"
+config.Port = ln.Addr().(*net.TCPAddr).Port
+return config, &remaining
+}
+
+func TestRetryReads(t *testing.T) {
+store := newMemStore()
+store.data["k"] = "v"
+config, drops := startDroppingServer(t, 0, func(line string) string {
+if line == "SCAN 0" {
+return "*2\n0\nk"
+}
+return store.handle(line)
+})
+config.RetryReads = true
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+drops.Store(1)
+if value, err := client.Get("k"); err != nil || value != "v" {
+t.Fatalf("Get over a dropped connection = %q, %v, want a transparent retry", value, err)
+}
+drops.Store(1)
+if keys, _, err := client.Scan(0, ScanOptions{}); err != nil || len(keys) != 1 {
+t.Fatalf("Scan over a dropped connection = %q, %v", keys, err)
+}
+drops.Store(1)
+if got, err := client.SendAll([]string{"EXISTS k", "GET k"}); err != nil || got != `"v"` {
+t.Fatalf("pipelined reads over a dropped connection = %q, %v", got, err)
+}
+
+// A retry that fails too is surfaced
+drops.Store(2)
+if _, err := client.Get("k"); err == nil {
+t.Fatal("Get should fail when the retry is dropped too")
+}
+drops.Store(0)
+client.Reset()
+
+// Writes are never repeated
+drops.Store(1)
+if err := client.Set("k", "w", 0); err == nil {
+t.Fatal("Set over a dropped connection should fail, not be retried")
+}
+if store.data["k"] != "v" {
+t.Errorf("Set was repeated, store has %q", store.data["k"])
+}
+}
+
+func TestRetryReadsOff(t *testing.T) {
+config, drops := startDroppingServer(t, 0, newMemStore().handle)
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+drops.Store(1)
+if _, err := client.Get("k"); err == nil {
+t.Fatal("Get over a dropped connection should fail without RetryReads")
+}
+if _, err := client.Get("k"); !errors.Is(err, ErrDesynced) {
+t.Errorf("next Get = %v, want ErrDesynced", err)
+}
+}
+
+func TestIsConnFault(t *testing.T) {
+if isConnFault(&ServerError{Message: "boom"}) || isConnFault(net.ErrClosed) {
+t.Error("server errors and our own close are not connection faults")
+}
+}
@@ -0,0 +1,275 @@
+package nubdb
+
+import (
+"errors"
+"fmt"
+"strconv"
+"strings"
+"time"
+)
+
+// ScanOptions narrows the keys returned by Scan and ScanAll
+type ScanOptions struct {
+// Match is a glob pattern keys must match; empty matches every key
+Match string
+// Count hints how many keys the server examines per page; 0 uses its default
+Count int64
+// Type keeps only keys holding this type of value, e.g. "string".
+// It is sent as SCAN's TYPE option; servers that reject the option, as a
+// syntax error or an unknown option, are remembered and filtered
+// client-side instead, with one pipelined TYPE lookup per page. Either way
+// pages may come back short or empty.
+Type string
+}
+
+// Scan returns one page of keys starting at cursor, along with the cursor of
+// the next page. Start with cursor 0; a returned cursor of 0 means the
+// iteration is complete.
+func (c *Client) Scan(cursor uint64, opts ScanOptions, callOpts ...CallOption) ([]string, uint64, error) {
+if opts.Type != "" && !c.scanTypeFallback.Load() {
+keys, next, err := c.scan(cursor, opts, callOpts)
+if !isOptionRejected(err) {
+return keys, next, err
+}
+c.scanTypeFallback.Store(true)
+}
+
+filter := opts.Type
+opts.Type = ""
+keys, next, err := c.scan(cursor, opts, callOpts)
+if err != nil || filter == "" || len(keys) == 0 {
+return keys, next, err
+}
+
+keys, err = c.filterType(keys, filter, callOpts)
+return keys, next, err
+}
+
+func (c *Client) scan(cursor uint64, opts ScanOptions, callOpts []CallOption) ([]string, uint64, error) {
+cmd := fmt.Sprintf("SCAN %d", cursor)
+if opts.Match != "" {
+cmd += " MATCH " + opts.Match
+}
+if opts.Count > 0 {
+cmd += fmt.Sprintf(" COUNT %d", opts.Count)
+}
+if opts.Type != "" {
+cmd += " TYPE " + opts.Type
+}
+
+lines, err := c.sendCommandLines(cmd, callOpts...)
+if err != nil {
+return nil, 0, err
+}
+if len(lines) == 0 {
+return nil, 0, fmt.Errorf("invalid response: empty SCAN reply")
+}
+
+next, err := strconv.ParseUint(lines[0], 10, 64)
+if err != nil {
+return nil, 0, fmt.Errorf("invalid response: %s", lines[0])
+}
+
+return lines[1:], next, nil
+}
+
+// isOptionRejected reports whether err is the server refusing a command's
+// arguments as a syntax error or an unknown command or option, rather than
+// failing for some other, possibly passing, reason
+func isOptionRejected(err error) bool {
+var serverErr *ServerError
+if !errors.As(err, &serverErr) {
+return false
+}
+message := strings.ToLower(serverErr.Message)
+return isUnknownCommand(err) || strings.Contains(message, "syntax") || strings.Contains(message, "unknown option")
+}
+
+// filterType keeps the keys whose TYPE is typ
+func (c *Client) filterType(keys []string, typ string, callOpts []CallOption) ([]string, error) {
+cmds := make([]string, len(keys))
+for i, key := range keys {
+cmds[i] = fmt.Sprintf("TYPE %s", key)
+}
+
+responses, err := c.sendCommands(cmds, callOpts...)
+if err != nil {
+return nil, err
+}
+
+filtered := keys[:0]
+for i, response := range responses {
+if err := parseServerError(response); err != nil {
+return nil, err
+}
+if strings.EqualFold(response, typ) {
+filtered = append(filtered, keys[i])
+}
+}
+
+return filtered, nil
+}
+
+// ScanAll iterates over every key matching opts, calling fn once per key.
+// Keys added or removed during the iteration may or may not be visited.
+// It stops at and returns the first error from the server or from fn.
+func (c *Client) ScanAll(opts ScanOptions, fn func(key string) error, callOpts ...CallOption) error {
+var cursor uint64
+for {
+keys, next, err := c.Scan(cursor, opts, callOpts...)
+if err != nil {
+return err
+}
+
+for _, key := range keys {
+if err := fn(key); err != nil {
+return err
+}
+}
+
+if next == 0 {
+return nil
+}
+cursor = next
+}
+}
+
+// ScanWithTTL iterates over every key matching the glob pattern (empty
+// matches every key), calling fn with each key and its remaining time to
+// live, NoExpiry for keys without one. The TTLs of each page are looked up
+// in one pipelined round trip. Keys that expire or are deleted between the
+// SCAN and the TTL lookup are skipped, as ScanAll may skip keys removed
+// during the iteration. It stops at and returns the first error from the
+// server or from fn.
+func (c *Client) ScanWithTTL(pattern string, fn func(key string, ttl time.Duration) error, callOpts ...CallOption) error {
+var cursor uint64
+for {
+keys, next, err := c.Scan(cursor, ScanOptions{Match: pattern}, callOpts...)
+if err != nil {
+return err
+}
+
+if len(keys) > 0 {
+cmds := make([]string, len(keys))
+for i, key := range keys {
+cmds[i] = fmt.Sprintf("TTL %s", key)
+}
+responses, err := c.sendCommands(cmds, callOpts...)
+if err != nil {
+return err
+}
+
+for i, key := range keys {
+if err := parseServerError(responses[i]); err != nil {
+return err
+}
+ttl, found, err := parseTTLReply(responses[i], time.Second)
+if err != nil {
+return err
+}
+if !found {
+continue
+}
+if err := fn(key, ttl); err != nil {
+return err
+}
+}
+}
+
+if next == 0 {
+return nil
+}
+cursor = next
+}
+}
+
+// MapAll rewrites every key matching the glob pattern (empty matches every
+// key) with fn, for migrations such as re-encoding values. Keys are
+// scanned and read a page at a time; fn gets each key's value and returns
+// the new value, or keep false to delete the key. Each page's writes are
+// pipelined in one round trip, and keys whose value fn leaves unchanged
+// are not written.
+//
+// A new value is written with CAS, so a key changed by another client
+// since it was read is left alone and reported with ErrConflict rather
+// than overwritten; deletions are not conditional. Keys removed before
+// they are read are skipped. Errors from fn or from writing a key are
+// collected per key without stopping the walk, and returned joined at the
+// end; a failed SCAN or round trip stops it at once.
+func (c *Client) MapAll(pattern string, fn func(key, value string) (newValue string, keep bool, err error), callOpts ...CallOption) error {
+var errs []error
+var cursor uint64
+for {
+keys, next, err := c.Scan(cursor, ScanOptions{Match: pattern}, callOpts...)
+if err != nil {
+return errors.Join(append(errs, err)...)
+}
+
+if len(keys) > 0 {
+results, err := c.getMany(keys, callOpts)
+if err != nil {
+return errors.Join(append(errs, err)...)
+}
+
+var cmds, cmdKeys []string
+for _, result := range results {
+if result.Err != nil {
+errs = append(errs, fmt.Errorf("%s: %w", result.Key, result.Err))
+continue
+}
+if !result.Found {
+continue
+}
+value, keep, err := fn(result.Key, result.Value)
+switch {
+case err != nil:
+errs = append(errs, fmt.Errorf("%s: %w", result.Key, err))
+continue
+case !keep:
+cmds = append(cmds, fmt.Sprintf("DELETE %s", result.Key))
+case value != result.Value:
+cmds = append(cmds, casCommand(result.Key, result.Value, value))
+default:
+continue
+}
+cmdKeys = append(cmdKeys, result.Key)
+}
+
+if len(cmds) > 0 {
+responses, err := c.sendCommands(cmds, callOpts...)
+if err != nil {
+return errors.Join(append(errs, err)...)
+}
+for i, response := range responses {
+if err := checkMapWrite(cmds[i], response); err != nil {
+errs = append(errs, fmt.Errorf("%s: %w", cmdKeys[i], err))
+}
+}
+}
+}
+
+if next == 0 {
+return errors.Join(errs...)
+}
+cursor = next
+}
+}
+
+// checkMapWrite checks the reply to a DELETE or CAS sent by MapAll
+func checkMapWrite(cmd, response string) error {
+if err := parseServerError(response); err != nil {
+return err
+}
+if strings.HasPrefix(cmd, "DELETE ") {
+if response != "OK" && !isNotFound(response) {
+return fmt.Errorf("unexpected response: %s", response)
+}
+return nil
+}
+
+swapped, err := parseCASReply(response)
+if err == nil && !swapped {
+err = ErrConflict
+}
+return err
+}
@@ -0,0 +1,252 @@
+package nubdb
+
+import (
+"errors"
+"reflect"
+"sort"
+"strconv"
+"strings"
+"sync"
+"testing"
+"time"
+)
+
+// scanStore serves SCAN over a fixed keyspace, three keys per page
+type scanStore struct {
+mu         sync.Mutex
+keys       []string
+types      map[string]string
+nativeType bool
+typeCalls  int
+}
+
+func (s *scanStore) handle(line string) string {
+s.mu.Lock()
+defer s.mu.Unlock()
+
+fields := strings.Fields(line)
+switch fields[0] {
+case "SCAN":
+cursor, _ := strconv.Atoi(fields[1])
+var match, typ string
+for i := 2; i+1 < len(fields); i += 2 {
+switch fields[i] {
+case "MATCH":
+match = fields[i+1]
+case "TYPE":
+if !s.nativeType {
+return "ERROR: syntax error"
+}
+typ = fields[i+1]
+}
+}
+
+end := cursor + 3
+next := end
+if end >= len(s.keys) {
+end, next = len(s.keys), 0
+}
+var page []string
+for _, key := range s.keys[cursor:end] {
+if match != "" && !strings.HasPrefix(key, strings.TrimSuffix(match, "*")) {
+continue
+}
+if typ != "" && s.types[key] != typ {
+continue
+}
+page = append(page, key)
+}
+return multiLine(append([]string{strconv.Itoa(next)}, page...)...)
+case "TYPE":
+s.typeCalls++
+return s.types[fields[1]]
+}
+return "ERROR: Unknown command"
+}
+
+func newScanStore(nativeType bool) *scanStore {
+s := &scanStore{types: make(map[string]string), nativeType: nativeType}
+for i := 0; i < 10; i++ {
+key := "user:" + strconv.Itoa(i)
+s.keys = append(s.keys, key)
+s.types[key] = "string"
+if i%3 == 0 {
+s.types[key] = "list"
+}
+}
+return s
+}
+
+func TestScanAll(t *testing.T) {
+client := connectFake(t, newScanStore(true).handle)
+
+var keys []string
+err := client.ScanAll(ScanOptions{Match: "user:*"}, func(key string) error {
+keys = append(keys, key)
+return nil
+})
+if err != nil {
+t.Fatalf("ScanAll: %v", err)
+}
+if len(keys) != 10 {
+t.Fatalf("scanned %d keys, want 10: %v", len(keys), keys)
+}
+}
+
+func TestScanTypeFilter(t *testing.T) {
+for _, native := range []bool{true, false} {
+store := newScanStore(native)
+client := connectFake(t, store.handle)
+
+var keys []string
+err := client.ScanAll(ScanOptions{Type: "list"}, func(key string) error {
+keys = append(keys, key)
+return nil
+})
+if err != nil {
+t.Fatalf("native %v: ScanAll: %v", native, err)
+}
+
+sort.Strings(keys)
+if got := strings.Join(keys, ","); got != "user:0,user:3,user:6,user:9" {
+t.Errorf("native %v: keys = %s", native, got)
+}
+if native && store.typeCalls != 0 {
+t.Errorf("native TYPE filter should not issue TYPE lookups, saw %d", store.typeCalls)
+}
+if !native && (store.typeCalls != 10 || !client.scanTypeFallback.Load()) {
+t.Errorf("fallback: TYPE lookups = %d, fallback = %v", store.typeCalls, client.scanTypeFallback.Load())
+}
+}
+}
+
+func TestScanTypeTransientError(t *testing.T) {
+store := newScanStore(true)
+failed := false
+client := connectFake(t, func(line string) string {
+if strings.Contains(line, " TYPE ") && !failed {
+failed = true
+return "ERROR: LOADING server is loading the dataset"
+}
+return store.handle(line)
+})
+
+if _, _, err := client.Scan(0, ScanOptions{Type: "list"}); err == nil {
+t.Fatal("Scan should report the server error")
+}
+if client.scanTypeFallback.Load() {
+t.Fatal("an unrelated server error switched SCAN TYPE off")
+}
+if _, _, err := client.Scan(0, ScanOptions{Type: "list"}); err != nil {
+t.Fatalf("Scan after the error: %v", err)
+}
+if store.typeCalls != 0 {
+t.Errorf("Scan after the error issued %d TYPE lookups, want none", store.typeCalls)
+}
+}
+
+// multiLine encodes lines as a multi-line reply
+func multiLine(lines ...string) string {
+return strings.Join(append([]string{"*" + strconv.Itoa(len(lines))}, lines...), "\n")
+}
+
+func TestScanWithTTL(t *testing.T) {
+store := newScanStore(true)
+client := connectFake(t, func(line string) string {
+key, isTTL := strings.CutPrefix(line, "TTL ")
+if !isTTL {
+return store.handle(line)
+}
+n, _ := strconv.Atoi(strings.TrimPrefix(key, "user:"))
+switch n % 3 {
+case 0:
+return "-1" // no expiry
+case 1:
+return strconv.Itoa(n * 10)
+}
+return "-2" // expired after the SCAN
+})
+
+got := make(map[string]time.Duration)
+err := client.ScanWithTTL("user:*", func(key string, ttl time.Duration) error {
+got[key] = ttl
+return nil
+})
+if err != nil {
+t.Fatalf("ScanWithTTL: %v", err)
+}
+
+want := map[string]time.Duration{
+"user:0": NoExpiry, "user:1": 10 * time.Second, "user:3": NoExpiry,
+"user:4": 40 * time.Second, "user:6": NoExpiry, "user:7": 70 * time.Second,
+"user:9": NoExpiry,
+}
+if !reflect.DeepEqual(got, want) {
+t.Errorf("ScanWithTTL reported %v, want %v", got, want)
+}
+
+stop := errors.New("stop")
+calls := 0
+err = client.ScanWithTTL("", func(string, time.Duration) error {
+calls++
+return stop
+})
+if !errors.Is(err, stop) || calls != 1 {
+t.Errorf("ScanWithTTL with a failing callback = %v after %d calls", err, calls)
+}
+}
+
+func TestMapAll(t *testing.T) {
+keys := newScanStore(true)
+store := newMemStore()
+for i := 0; i < 9; i++ { // user:9 is scanned but already gone
+store.data["user:"+strconv.Itoa(i)] = "v" + strconv.Itoa(i)
+}
+var writes []string
+client := connectFake(t, func(line string) string {
+if strings.HasPrefix(line, "SCAN ") {
+return keys.handle(line)
+}
+if !strings.HasPrefix(line, "GET ") {
+writes = append(writes, line)
+}
+return store.handle(line)
+})
+
+fail := errors.New("bad value")
+err := client.MapAll("user:*", func(key, value string) (string, bool, error) {
+switch key {
+case "user:2":
+return "", false, fail
+case "user:3":
+return "", false, nil
+case "user:4":
+return value, true, nil
+case "user:5":
+store.mu.Lock()
+store.data[key] = "changed"
+store.mu.Unlock()
+}
+return value + "!", true, nil
+})
+if !errors.Is(err, fail) || !errors.Is(err, ErrConflict) {
+t.Fatalf("MapAll = %v, want the fn failure and a conflict", err)
+}
+for _, key := range []string{"user:2", "user:5"} {
+if !strings.Contains(err.Error(), key+":") {
+t.Errorf("MapAll error %q does not name %s", err, key)
+}
+}
+
+want := map[string]string{
+"user:0": "v0!", "user:1": "v1!", "user:2": "v2", "user:4": "v4",
+"user:5": "changed", "user:6": "v6!", "user:7": "v7!", "user:8": "v8!",
+}
+if !reflect.DeepEqual(store.data, want) {
+t.Errorf("store after MapAll = %v, want %v", store.data, want)
+}
+// Unchanged values and failed keys are not written
+if len(writes) != 7 || !strings.HasPrefix(writes[2], "DELETE user:3") {
+t.Errorf("MapAll wrote %q", writes)
+}
+}
@@ -0,0 +1,88 @@
+package nubdb
+
+import (
+"crypto/sha1"
+"encoding/hex"
+"errors"
+"fmt"
+"strconv"
+"strings"
+)
+
+// ErrNoScript matches the server error for an EVALSHA of a script that is
+// not in the server's script cache
+var ErrNoScript = errors.New("script not loaded")
+
+// Eval runs script on the server with keys and args, atomically with
+// respect to other commands, and returns its raw reply. Declaring every key
+// the script touches in keys lets the server route and lock them.
+func (c *Client) Eval(script string, keys []string, args []string, opts ...CallOption) (string, error) {
+return c.sendCommand(evalCommand("EVAL", quoteArg(script), keys, args), opts...)
+}
+
+// EvalSHA runs a script previously loaded with LoadScript by its SHA1
+// digest. It fails with an error matching ErrNoScript if the server does
+// not have the script cached; Script handles that case transparently.
+func (c *Client) EvalSHA(sha string, keys []string, args []string, opts ...CallOption) (string, error) {
+return c.sendCommand(evalCommand("EVALSHA", sha, keys, args), opts...)
+}
+
+// LoadScript adds script to the server's script cache and returns its SHA1
+// digest for use with EvalSHA
+func (c *Client) LoadScript(script string, opts ...CallOption) (string, error) {
+response, err := c.sendCommand("SCRIPT LOAD "+quoteArg(script), opts...)
+if err != nil {
+return "", err
+}
+
+sha := strings.Trim(response, `"`)
+if len(sha) != 40 {
+return "", fmt.Errorf("unexpected response: %s", response)
+}
+return sha, nil
+}
+
+func evalCommand(verb, script string, keys []string, args []string) string {
+parts := make([]string, 0, 3+len(keys)+len(args))
+parts = append(parts, verb, script, strconv.Itoa(len(keys)))
+for _, key := range keys {
+parts = append(parts, quoteArg(key))
+}
+for _, arg := range args {
+parts = append(parts, quoteArg(arg))
+}
+return strings.Join(parts, " ")
+}
+
+// Script is a server-side script run by its digest. The digest is computed
+// locally, so a Script can be shared between clients and servers; a server
+// that does not have it cached is sent the full source once.
+type Script struct {
+src string
+sha string
+}
+
+// NewScript returns a Script for src
+func NewScript(src string) *Script {
+sum := sha1.Sum([]byte(src))
+return &Script{src: src, sha: hex.EncodeToString(sum[:])}
+}
+
+// SHA returns the script's SHA1 digest as used by EvalSHA
+func (s *Script) SHA() string {
+return s.sha
+}
+
+// Run runs the script on c with EvalSHA, loading it into the server's
+// script cache and retrying if the server reports it missing
+func (s *Script) Run(c *Client, keys []string, args []string, opts ...CallOption) (string, error) {
+response, err := c.EvalSHA(s.sha, keys, args, opts...)
+if !errors.Is(err, ErrNoScript) {
+return response, err
+}
+
+if _, err := c.LoadScript(s.src, opts...); err != nil {
+return "", err
+}
+return c.EvalSHA(s.sha, keys, args, opts...)
+}
@@ -0,0 +1,149 @@
+package nubdb
+
+import (
+"crypto/sha1"
+"encoding/hex"
+"errors"
+"strconv"
+"strings"
+"sync"
+"testing"
+)
+
+const swapScript = `local a = GET(KEYS[1]); SET(KEYS[1], GET(KEYS[2])); SET(KEYS[2], a); return "OK"`
+
+// scriptServer runs swapScript, the only script it knows, against a memStore
+// and keeps an EVALSHA cache like a real server would
+type scriptServer struct {
+store *memStore
+
+mu     sync.Mutex
+cached map[string]bool
+evals  []string
+}
+
+func (s *scriptServer) handle(line string) string {
+verb, rest, _ := strings.Cut(line, " ")
+s.mu.Lock()
+s.evals = append(s.evals, verb)
+s.mu.Unlock()
+
+switch verb {
+case "EVAL", "SCRIPT":
+if verb == "SCRIPT" {
+rest = strings.TrimPrefix(rest, "LOAD ")
+}
+quoted, err := strconv.QuotedPrefix(rest)
+if err != nil {
+return "ERROR: bad script"
+}
+src, _ := strconv.Unquote(quoted)
+if src != swapScript {
+return "ERROR: unsupported script"
+}
+sum := sha1.Sum([]byte(src))
+sha := hex.EncodeToString(sum[:])
+s.mu.Lock()
+s.cached[sha] = true
+s.mu.Unlock()
+if verb == "SCRIPT" {
+return `"` + sha + `"`
+}
+return s.swap(strings.Fields(rest[len(quoted):]))
+case "EVALSHA":
+fields := strings.Fields(rest)
+s.mu.Lock()
+cached := s.cached[fields[0]]
+s.mu.Unlock()
+if !cached {
+return "ERROR: NOSCRIPT No matching script"
+}
+return s.swap(fields[1:])
+}
+return s.store.handle(line)
+}
+
+// swap runs swapScript given "numkeys key..." fields
+func (s *scriptServer) swap(fields []string) string {
+if len(fields) != 3 || fields[0] != "2" {
+return "ERROR: wrong number of keys"
+}
+s.store.mu.Lock()
+defer s.store.mu.Unlock()
+a, b := fields[1], fields[2]
+s.store.data[a], s.store.data[b] = s.store.data[b], s.store.data[a]
+return `"OK"`
+}
+
+func TestEvalTwoKeys(t *testing.T) {
+server := &scriptServer{store: newMemStore(), cached: make(map[string]bool)}
+client := connectFake(t, server.handle)
+
+client.Set("left", "1", 0)
+client.Set("right", "2", 0)
+
+if _, err := client.Eval(swapScript, []string{"left", "right"}, nil); err != nil {
+t.Fatalf("Eval: %v", err)
+}
+if left, _ := client.Get("left"); left != "2" {
+t.Errorf("left = %q, want 2", left)
+}
+if right, _ := client.Get("right"); right != "1" {
+t.Errorf("right = %q, want 1", right)
+}
+}
+
+func TestEvalSHA(t *testing.T) {
+server := &scriptServer{store: newMemStore(), cached: make(map[string]bool)}
+client := connectFake(t, server.handle)
+client.Set("left", "1", 0)
+client.Set("right", "2", 0)
+
+script := NewScript(swapScript)
+if _, err := client.EvalSHA(script.SHA(), []string{"left", "right"}, nil); !errors.Is(err, ErrNoScript) {
+t.Fatalf("EvalSHA before LoadScript = %v, want ErrNoScript", err)
+}
+
+sha, err := client.LoadScript(swapScript)
+if err != nil {
+t.Fatalf("LoadScript: %v", err)
+}
+if sha != script.SHA() {
+t.Errorf("LoadScript sha = %s, want %s", sha, script.SHA())
+}
+if _, err := client.EvalSHA(sha, []string{"left", "right"}, nil); err != nil {
+t.Fatalf("EvalSHA: %v", err)
+}
+if left, _ := client.Get("left"); left != "2" {
+t.Errorf("left = %q, want 2", left)
+}
+}
+
+func TestScriptReloadsOnNoScript(t *testing.T) {
+server := &scriptServer{store: newMemStore(), cached: make(map[string]bool)}
+client := connectFake(t, server.handle)
+client.Set("left", "1", 0)
+client.Set("right", "2", 0)
+
+script := NewScript(swapScript)
+for i := 0; i < 2; i++ {
+if _, err := script.Run(client, []string{"left", "right"}, nil); err != nil {
+t.Fatalf("Run %d: %v", i, err)
+}
+}
+
+// Swapped twice: back where it started
+if left, _ := client.Get("left"); left != "1" {
+t.Errorf("left = %q, want 1", left)
+}
+
+var verbs []string
+for _, verb := range server.evals {
+if verb == "EVALSHA" || verb == "SCRIPT" {
+verbs = append(verbs, verb)
+}
+}
+if got := strings.Join(verbs, ","); got != "EVALSHA,SCRIPT,EVALSHA,EVALSHA" {
+t.Errorf("commands = %s, want one reload on the first run only", got)
+}
+}
@@ -0,0 +1,69 @@
+package nubdb
+
+import (
+"fmt"
+"strings"
+)
+
+// Set commands. Members are sent like DoArgs strings, quoted when they
+// contain spaces or quotes, and SMEMBERS replies with a "*<n>" header
+// followed by one member per line, quoted the same way. Commands on a key
+// holding another type fail with a *ServerError, typically with Code
+// "WRONGTYPE".
+
+// SAdd adds members to the set at key, creating it if needed, and returns
+// how many were not already present
+func (c *Client) SAdd(key string, members []string, opts ...CallOption) (int64, error) {
+return c.memberCount("SADD", key, members, opts)
+}
+
+// SRem removes members from the set at key and returns how many were
+// present
+func (c *Client) SRem(key string, members []string, opts ...CallOption) (int64, error) {
+return c.memberCount("SREM", key, members, opts)
+}
+
+// memberCount sends "VERB key member..." and returns its integer reply
+func (c *Client) memberCount(verb, key string, members []string, opts []CallOption) (int64, error) {
+if len(members) == 0 {
+return 0, nil
+}
+
+response, err := c.sendCommand(memberCommand(verb, key, members), opts...)
+if err != nil {
+return 0, err
+}
+return parseIntReply(response)
+}
+
+// SMembers returns the members of the set at key in no particular order.
+// A missing key is an empty set.
+func (c *Client) SMembers(key string, opts ...CallOption) ([]string, error) {
+members, err := c.sendCommandList(fmt.Sprintf("SMEMBERS %s", key), opts...)
+if err != nil {
+return nil, err
+}
+for i, member := range members {
+members[i] = unquoteArg(member)
+}
+return members, nil
+}
+
+// SIsMember reports whether member is in the set at key
+func (c *Client) SIsMember(key, member string, opts ...CallOption) (bool, error) {
+response, err := c.sendCommand(memberCommand("SISMEMBER", key, []string{member}), opts...)
+if err != nil {
+return false, err
+}
+return parseBoolReply(response)
+}
+
+// memberCommand builds "VERB key member..." with members quoted as needed
+func memberCommand(verb, key string, members []string) string {
+var b strings.Builder
+b.WriteString(verb + " " + key)
+for _, member := range members {
+b.WriteString(" " + quoteArg(member))
+}
+return b.String()
+}
@@ -0,0 +1,151 @@
+package nubdb
+
+import (
+"errors"
+"sort"
+"strconv"
+"strings"
+"sync"
+"testing"
+)
+
+// splitArgs splits a command line into words, unquoting quoted ones the
+// way quoteArg quotes them
+func splitArgs(line string) []string {
+var args []string
+for line = strings.TrimLeft(line, " "); line != ""; line = strings.TrimLeft(line, " ") {
+if quoted, err := strconv.QuotedPrefix(line); err == nil {
+arg, _ := strconv.Unquote(quoted)
+args = append(args, arg)
+line = line[len(quoted):]
+continue
+}
+word, rest, _ := strings.Cut(line, " ")
+args = append(args, word)
+line = rest
+}
+return args
+}
+
+// setStore implements the set commands, encoding replies with quoteArg;
+// "str" holds a string
+type setStore struct {
+mu   sync.Mutex
+sets map[string]map[string]bool
+}
+
+func (s *setStore) handle(line string) string {
+s.mu.Lock()
+defer s.mu.Unlock()
+
+args := splitArgs(line)
+if len(args) > 1 && args[1] == "str" {
+return "ERROR: WRONGTYPE Operation against a key holding the wrong kind of value"
+}
+set := s.sets[args[1]]
+switch args[0] {
+case "SADD":
+if set == nil {
+set = make(map[string]bool)
+s.sets[args[1]] = set
+}
+added := 0
+for _, m := range args[2:] {
+if !set[m] {
+set[m] = true
+added++
+}
+}
+return strconv.Itoa(added)
+case "SREM":
+removed := 0
+for _, m := range args[2:] {
+if set[m] {
+delete(set, m)
+removed++
+}
+}
+if len(set) == 0 {
+delete(s.sets, args[1])
+}
+return strconv.Itoa(removed)
+case "SISMEMBER":
+if set[args[2]] {
+return "1"
+}
+return "0"
+case "SMEMBERS":
+members := []string{}
+for m := range set {
+members = append(members, quoteArg(m))
+}
+sort.Strings(members)
+return multiLine(members...)
+}
+return "ERROR: Unknown command"
+}
+
+func TestSets(t *testing.T) {
+client := connectFake(t, (&setStore{sets: make(map[string]map[string]bool)}).handle)
+
+if n, err := client.SAdd("tags", []string{"go", "db", "two words", `a "b"`, `back\slash`}); err != nil || n != 5 {
+t.Fatalf("SAdd = %d, %v; want 5", n, err)
+}
+if n, _ := client.SAdd("tags", []string{"go", "new"}); n != 1 {
+t.Errorf("SAdd of one new member = %d, want 1", n)
+}
+
+members, err := client.SMembers("tags")
+if err != nil {
+t.Fatalf("SMembers: %v", err)
+}
+sort.Strings(members)
+if got := strings.Join(members, ","); got != `a "b",back\slash,db,go,new,two words` {
+t.Errorf("SMembers = %q", members)
+}
+
+if ok, _ := client.SIsMember("tags", "two words"); !ok {
+t.Error("SIsMember of a member = false")
+}
+if ok, _ := client.SIsMember("tags", "rust"); ok {
+t.Error("SIsMember of a non-member = true")
+}
+
+if n, _ := client.SRem("tags", []string{"go", "rust"}); n != 1 {
+t.Errorf("SRem = %d, want 1", n)
+}
+if ok, _ := client.SIsMember("tags", "go"); ok {
+t.Error("removed member still present")
+}
+
+// A single member still comes back as a list
+client.SAdd("one", []string{"only"})
+if members, err := client.SMembers("one"); err != nil || len(members) != 1 || members[0] != "only" {
+t.Errorf("SMembers of a one-member set = %q, %v", members, err)
+}
+}
+
+func TestSetsEmptyAndWrongType(t *testing.T) {
+client := connectFake(t, (&setStore{sets: make(map[string]map[string]bool)}).handle)
+
+members, err := client.SMembers("missing")
+if err != nil || members == nil || len(members) != 0 {
+t.Errorf("SMembers of a missing set = %#v, %v; want empty", members, err)
+}
+
+client.SAdd("s", []string{"a"})
+client.SRem("s", []string{"a"})
+if members, err := client.SMembers("s"); err != nil || len(members) != 0 {
+t.Errorf("SMembers of an emptied set = %q, %v", members, err)
+}
+
+if n, err := client.SAdd("s", nil); err != nil || n != 0 {
+t.Errorf("SAdd without members = %d, %v", n, err)
+}
+
+_, err = client.SAdd("str", []string{"x"})
+var serr *ServerError
+if !errors.As(err, &serr) || serr.Code != "WRONGTYPE" {
+t.Errorf("SAdd on a string = %v, want WRONGTYPE", err)
+}
+}
@@ -0,0 +1,65 @@
+package nubdb
+
+import (
+"sync"
+"time"
+)
+
+// flightCall is an in-flight or completed flightGroup.do call
+type flightCall struct {
+done chan struct{}
+val  string
+err  error
+}
+
+// flightGroup deduplicates concurrent calls that share a key, so only the
+// first caller runs fn and the others wait for and share its result.
+type flightGroup struct {
+mu    sync.Mutex
+calls map[string]*flightCall
+}
+
+// do runs fn, or waits for the call already running for key. The first
+// caller's fn runs under that caller's options; a waiter stops waiting at
+// its own deadline, if it has one, and leaves the shared call running.
+func (g *flightGroup) do(key string, deadline time.Time, fn func() (string, error)) (string, error) {
+g.mu.Lock()
+if g.calls == nil {
+g.calls = make(map[string]*flightCall)
+}
+if call, ok := g.calls[key]; ok {
+g.mu.Unlock()
+return call.wait(deadline)
+}
+
+call := &flightCall{done: make(chan struct{})}
+g.calls[key] = call
+g.mu.Unlock()
+
+call.val, call.err = fn()
+close(call.done)
+
+g.mu.Lock()
+delete(g.calls, key)
+g.mu.Unlock()
+
+return call.val, call.err
+}
+
+// wait returns the call's result once it completes, or a deadline error if
+// deadline passes first
+func (call *flightCall) wait(deadline time.Time) (string, error) {
+if deadline.IsZero() {
+<-call.done
+return call.val, call.err
+}
+
+timer := time.NewTimer(time.Until(deadline))
+defer timer.Stop()
+select {
+case <-call.done:
+return call.val, call.err
+case <-timer.C:
+return "", checkDeadline(deadline)
+}
+}
@@ -0,0 +1,249 @@
+package nubdb
+
+import (
+"bufio"
+"bytes"
+"errors"
+"fmt"
+"io"
+"net"
+"strings"
+"time"
+)
+
+var errStreamAbandoned = errors.New("stream abandoned by connection reset")
+
+// GetStream returns the value of key as a stream read directly from the
+// connection, without buffering the whole value in memory. It returns
+// ErrKeyNotFound if the key does not exist.
+//
+// The connection is occupied until the stream has been read to io.EOF or
+// closed; commands issued before that fail with ErrDesynced (or reset the
+// connection when Config.AutoReset is set, abandoning the stream).
+func (c *Client) GetStream(key string) (stream io.ReadCloser, err error) {
+if c.Protocol() == ProtocolFramed {
+// A framed bulk string is read whole, so there is nothing to stream
+value, found, err := c.lookupServer(key, nil)
+if err == nil && !found {
+err = ErrKeyNotFound
+}
+if err != nil {
+return nil, err
+}
+return io.NopCloser(strings.NewReader(value)), nil
+}
+
+cmd := fmt.Sprintf("GET %s", key)
+c.lock()
+defer c.unlock()
+defer func() {
+if !errors.Is(err, ErrKeyNotFound) {
+c.recordErrors([]string{cmd}, nil, err)
+}
+}()
+
+if err := c.resync(time.Time{}); err != nil {
+return nil, err
+}
+start, mark := time.Now(), c.received
+if err := c.writeCommand(cmd); err != nil {
+return nil, err
+}
+
+first, err := c.reader.Peek(1)
+if err != nil {
+c.markBroken(err)
+return nil, fmt.Errorf("read error: %w", err)
+}
+
+if first[0] != '"' {
+response, err := c.readReply()
+if err != nil {
+return nil, err
+}
+c.observeExchange(cmd, start, mark)
+if isNotFound(response) {
+return nil, ErrKeyNotFound
+}
+if err := parseServerError(response); err != nil {
+return nil, err
+}
+return nil, fmt.Errorf("unexpected response: %s", response)
+}
+
+c.reader.Discard(1)
+c.desynced = true
+return &valueStream{c: c, conn: c.conn, cmd: cmd, start: start, received: 1}, nil
+}
+
+// valueStream reads a quoted value line from the connection, holding back
+// the bytes that may turn out to be the closing quote and terminator. The
+// GET is recorded in BytesStats and LatencyStats once the line ends.
+type valueStream struct {
+c        *Client
+conn     net.Conn // connection the value is being read from
+buf      []byte   // bytes ready to be returned
+held     []byte   // tail of the last chunk, possibly part of the line ending
+done     bool
+cmd      string
+start    time.Time
+received int // bytes of the reply read so far
+}
+
+func (s *valueStream) Read(p []byte) (int, error) {
+for len(s.buf) == 0 {
+if s.done {
+return 0, io.EOF
+}
+if err := s.fill(); err != nil {
+return 0, err
+}
+}
+
+n := copy(p, s.buf)
+s.buf = s.buf[n:]
+return n, nil
+}
+
+// fill reads the next chunk of the line into buf
+func (s *valueStream) fill() error {
+s.c.lock()
+defer s.c.unlock()
+
+if s.c.conn != s.conn {
+return errStreamAbandoned
+}
+
+terminator := s.c.terminator
+chunk, err := s.c.reader.ReadSlice(terminator[len(terminator)-1])
+if err != nil && err != bufio.ErrBufferFull {
+s.c.recordErrors([]string{s.cmd}, nil, err)
+return fmt.Errorf("read error: %w", err)
+}
+s.received += len(chunk)
+
+data := append(append(s.buf[:0], s.held...), chunk...)
+if err == nil {
+// End of line: strip the terminator, a stray "\r" and the closing quote
+data = bytes.TrimSuffix(data, []byte(terminator))
+data = bytes.TrimSuffix(data, []byte("\r"))
+data = bytes.TrimSuffix(data, []byte(`"`))
+s.buf, s.held, s.done = data, nil, true
+s.c.desynced = false
+s.c.outstanding--
+s.c.received += s.received
+s.c.observeExchange(s.cmd, s.start, s.c.received-s.received)
+return nil
+}
+
+// The closing quote, a "\r" and all but the last terminator byte may
+// straddle this chunk and the next, so keep them back
+keep := len(terminator) + 1
+if keep > len(data) {
+keep = len(data)
+}
+s.held = append(s.held[:0], data[len(data)-keep:]...)
+s.buf = data[:len(data)-keep]
+return nil
+}
+
+// Close discards the rest of the value so the connection can be reused
+func (s *valueStream) Close() error {
+for !s.done {
+s.buf = s.buf[:0]
+if err := s.fill(); err != nil {
+return err
+}
+}
+s.buf = nil
+return nil
+}
+
+// Result is one value produced by GetAllStream or MGet
+type Result struct {
+Key   string
+Value string
+Found bool
+Err   error
+}
+
+// GetAllStream reads keys from the keys channel and sends one Result per
+// key, in order, on the returned channel, which is closed once keys is
+// closed and drained. Keys are fetched in pipelined windows of at most
+// Config.PipelineBatchSize (DefaultPipelineBatchSize if unset), so memory
+// stays bounded however many keys pass through. A failed window reports its
+// error in the Result of each of its keys and the stream carries on.
+//
+// The caller must keep receiving until the channel is closed.
+func (c *Client) GetAllStream(keys <-chan string, opts ...CallOption) <-chan Result {
+window := c.config.PipelineBatchSize
+if window <= 0 {
+window = DefaultPipelineBatchSize
+}
+
+results := make(chan Result, window)
+go func() {
+defer close(results)
+
+batch := make([]string, 0, window)
+for key := range keys {
+batch = append(batch[:0], key)
+// Take whatever else is ready without waiting for a full window
+fill:
+for len(batch) < window {
+select {
+case key, ok := <-keys:
+if !ok {
+break fill
+}
+batch = append(batch, key)
+default:
+break fill
+}
+}
+
+for _, result := range c.getWindow(batch, opts) {
+results <- result
+}
+}
+}()
+
+return results
+}
+
+// getWindow pipelines a GET for each key, reporting a failed round trip in
+// the Result of every key
+func (c *Client) getWindow(keys []string, opts []CallOption) []Result {
+results, err := c.getMany(keys, opts)
+if err != nil {
+results = make([]Result, len(keys))
+for i, key := range keys {
+results[i] = Result{Key: key, Err: err}
+}
+}
+return results
+}
+
+// getMany pipelines a GET for each key in one flush
+func (c *Client) getMany(keys []string, opts []CallOption) ([]Result, error) {
+cmds := make([]string, len(keys))
+for i, key := range keys {
+cmds[i] = fmt.Sprintf("GET %s", key)
+}
+
+responses, err := c.sendCommands(cmds, opts...)
+if err != nil {
+return nil, err
+}
+
+results := make([]Result, len(keys))
+for i, key := range keys {
+results[i].Key = key
+if err := parseServerError(responses[i]); err != nil {
+results[i].Err = err
+continue
+}
+results[i].Value, results[i].Found = parseGetReply(responses[i])
+}
+return results, nil
+}
@@ -0,0 +1,142 @@
+package nubdb
+
+import (
+"crypto/sha256"
+"errors"
+"io"
+"strconv"
+"strings"
+"testing"
+)
+
+func TestGetStreamLargeValue(t *testing.T) {
+for _, terminator := range []string{"\n", "\r\n"} {
+store := newMemStore()
+value := strings.Repeat("stream-me-", 300000)
+store.data["big"] = value
+store.data["small"] = "after"
+
+client, err := Connect(startFakeServerTerm(t, terminator, store.handle))
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+
+r, err := client.GetStream("big")
+if err != nil {
+t.Fatalf("GetStream: %v", err)
+}
+h := sha256.New()
+if _, err := io.Copy(h, r); err != nil {
+t.Fatalf("copy: %v", err)
+}
+if err := r.Close(); err != nil {
+t.Fatalf("close: %v", err)
+}
+
+if got, want := h.Sum(nil), sha256.Sum256([]byte(value)); string(got) != string(want[:]) {
+t.Fatalf("%q: streamed value hash mismatch", terminator)
+}
+
+if got, err := client.Get("small"); err != nil || got != "after" {
+t.Fatalf("%q: Get after stream = %q, %v", terminator, got, err)
+}
+client.Close()
+}
+}
+
+func TestGetStreamCloseEarly(t *testing.T) {
+store := newMemStore()
+store.data["big"] = strings.Repeat("x", 100000)
+store.data["small"] = "after"
+client := connectFake(t, store.handle)
+
+r, err := client.GetStream("big")
+if err != nil {
+t.Fatalf("GetStream: %v", err)
+}
+if _, err := client.Get("small"); !errors.Is(err, ErrDesynced) {
+t.Fatalf("Get during stream error = %v, want ErrDesynced", err)
+}
+
+buf := make([]byte, 10)
+if _, err := r.Read(buf); err != nil {
+t.Fatalf("read: %v", err)
+}
+if err := r.Close(); err != nil {
+t.Fatalf("close: %v", err)
+}
+
+if got, err := client.Get("small"); err != nil || got != "after" {
+t.Fatalf("Get after close = %q, %v", got, err)
+}
+}
+
+func TestGetStreamMissing(t *testing.T) {
+client := connectFake(t, newMemStore().handle)
+
+if _, err := client.GetStream("missing"); !errors.Is(err, ErrKeyNotFound) {
+t.Fatalf("GetStream(missing) error = %v, want ErrKeyNotFound", err)
+}
+}
+
+func TestGetAllStreamFromScan(t *testing.T) {
+scan := newScanStore(true)
+config := startFakeServer(t, func(line string) string {
+key, ok := strings.CutPrefix(line, "GET ")
+switch {
+case !ok:
+return scan.handle(line)
+case key == "user:5":
+return "(nil)"
+case key == "user:7":
+return "ERROR: WRONGTYPE not a string"
+}
+return `"value of ` + key + `"`
+})
+config.PipelineBatchSize = 4
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+keys := make(chan string)
+scanErr := make(chan error, 1)
+go func() {
+defer close(keys)
+scanErr <- client.ScanAll(ScanOptions{}, func(key string) error {
+keys <- key
+return nil
+})
+}()
+
+var n int
+for result := range client.GetAllStream(keys) {
+want := "user:" + strconv.Itoa(n)
+if result.Key != want {
+t.Fatalf("result %d is for %s, want %s", n, result.Key, want)
+}
+switch want {
+case "user:5":
+if result.Found || result.Err != nil {
+t.Errorf("%s: %+v, want not found", want, result)
+}
+case "user:7":
+if result.Err == nil {
+t.Errorf("%s: error reply not reported", want)
+}
+default:
+if !result.Found || result.Value != "value of "+want || result.Err != nil {
+t.Errorf("%s: %+v", want, result)
+}
+}
+n++
+}
+
+if n != 10 {
+t.Errorf("got %d results, want 10", n)
+}
+if err := <-scanErr; err != nil {
+t.Errorf("ScanAll: %v", err)
+}
+}
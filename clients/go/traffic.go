@@ -0,0 +1,54 @@
+package nubdb
+
+import (
+"strings"
+"sync"
+"sync/atomic"
+)
+
+// ByteCounts is the traffic of one command verb, terminators included
+type ByteCounts struct {
+Sent     int64
+Received int64
+}
+
+// trafficCounters keeps ByteCounts per command verb. The map only grows,
+// so after the first command of each verb recording is lock-free.
+type trafficCounters struct {
+verbs sync.Map // verb -> *verbTraffic
+}
+
+type verbTraffic struct {
+sent     atomic.Int64
+received atomic.Int64
+}
+
+func (t *trafficCounters) record(cmd string, sent, received int) {
+verb := commandVerb(cmd)
+v, ok := t.verbs.Load(verb)
+if !ok {
+v, _ = t.verbs.LoadOrStore(verb, &verbTraffic{})
+}
+counts := v.(*verbTraffic)
+counts.sent.Add(int64(sent))
+counts.received.Add(int64(received))
+}
+
+// commandVerb returns the upper-cased first word of cmd
+func commandVerb(cmd string) string {
+verb, _, _ := strings.Cut(cmd, " ")
+return strings.ToUpper(verb)
+}
+
+// BytesStats returns the bytes sent and received per command verb since
+// the client connected. Replies are counted as read off the wire, so
+// multi-line replies count every line.
+func (c *Client) BytesStats() map[string]ByteCounts {
+stats := make(map[string]ByteCounts)
+c.traffic.verbs.Range(func(key, value any) bool {
+counts := value.(*verbTraffic)
+stats[key.(string)] = ByteCounts{Sent: counts.sent.Load(), Received: counts.received.Load()}
+return true
+})
+return stats
+}
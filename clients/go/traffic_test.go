@@ -0,0 +1,83 @@
+package nubdb
+
+import (
+"io"
+"testing"
+)
+
+func TestBytesStats(t *testing.T) {
+client := connectFake(t, newMemStore().handle)
+
+client.Set("key", "hello", 0) // SET key "hello"\n -> OK\n
+client.Get("key")             // GET key\n -> "hello"\n
+client.Get("key")
+client.Get("none") // GET none\n -> (nil)\n
+
+p := client.Pipeline()
+p.Set("k2", "v", 0) // SET k2 "v"\n -> OK\n
+p.Exec()
+
+stats := client.BytesStats()
+if got, want := stats["SET"], (ByteCounts{Sent: 16 + 11, Received: 3 + 3}); got != want {
+t.Errorf("SET = %+v, want %+v", got, want)
+}
+if got, want := stats["GET"], (ByteCounts{Sent: 8 + 8 + 9, Received: 8 + 8 + 6}); got != want {
+t.Errorf("GET = %+v, want %+v", got, want)
+}
+if len(stats) != 2 {
+t.Errorf("stats = %v, want only SET and GET", stats)
+}
+}
+
+func TestStatsCoverDirectPaths(t *testing.T) {
+store := &txStore{memStore: newMemStore()}
+config := startFakeServer(t, func(line string) string {
+if line == "MULTI" && store.data["unsupported"] != "" {
+return "ERROR: Unknown command"
+}
+return store.handle(line)
+})
+config.TrackLatency = true
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+if err := client.SetManyTx(map[string]string{"a": "hello"}); err != nil {
+t.Fatalf("SetManyTx: %v", err)
+}
+stream, err := client.GetStream("a")
+if err != nil {
+t.Fatalf("GetStream: %v", err)
+}
+if value, err := io.ReadAll(stream); err != nil || string(value) != "hello" {
+t.Fatalf("GetStream read %q, %v", value, err)
+}
+stream.Close()
+
+stats := client.BytesStats()
+for verb, want := range map[string]ByteCounts{
+"MULTI": {Sent: 6, Received: 3},  // MULTI\n -> OK\n
+"SET":   {Sent: 14, Received: 7}, // SET a "hello"\n -> QUEUED\n
+"EXEC":  {Sent: 5, Received: 6},  // EXEC\n -> *1\nOK\n
+"GET":   {Sent: 6, Received: 8},  // GET a\n -> "hello"\n
+} {
+if got := stats[verb]; got != want {
+t.Errorf("BytesStats[%s] = %+v, want %+v", verb, got, want)
+}
+}
+latency := client.LatencyStats()
+for _, verb := range []string{"MULTI", "EXEC", "GET"} {
+if latency[verb].Count != 1 {
+t.Errorf("LatencyStats[%s] = %+v, want one command", verb, latency[verb])
+}
+}
+
+store.data["unsupported"] = "1"
+client.SetManyTx(map[string]string{"b": "1"})
+recent := client.RecentErrors()
+if len(recent) != 1 || recent[0].Command != "MULTI" {
+t.Errorf("RecentErrors = %+v, want the refused MULTI", recent)
+}
+}
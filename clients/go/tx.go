@@ -0,0 +1,134 @@
+package nubdb
+
+import (
+"errors"
+"fmt"
+"sort"
+"time"
+)
+
+// ErrTxUnsupported is returned by SetManyTx when the server rejects MULTI
+var ErrTxUnsupported = errors.New("server does not support transactions")
+
+// SetManyTx sets every key of pairs to its value as one transaction, so
+// either all of them are set or none is. It needs MULTI/EXEC support on the
+// server and takes three round trips: MULTI on its own, so that a server
+// without transactions fails with ErrTxUnsupported before any SET is sent,
+// then the queued SETs, then EXEC. If the server refuses to queue any SET,
+// the transaction is discarded and the refusal returned.
+func (c *Client) SetManyTx(pairs map[string]string, opts ...CallOption) (err error) {
+if len(pairs) == 0 {
+return nil
+}
+
+keys := make([]string, 0, len(pairs))
+for key := range pairs {
+keys = append(keys, key)
+}
+sort.Strings(keys)
+
+c.lock()
+defer c.unlock()
+// step is the command a failure is recorded against for RecentErrors
+step := "MULTI"
+defer func() { c.recordErrors([]string{step}, nil, err) }()
+
+deadline := newCallOptions(opts).effectiveDeadline()
+if err := c.resync(deadline); err != nil {
+return err
+}
+
+clear, err := c.setDeadline(deadline)
+if err != nil {
+return err
+}
+defer clear()
+
+if err := c.roundTrip("MULTI"); err != nil {
+var serr *ServerError
+if errors.As(err, &serr) {
+return fmt.Errorf("%w: %v", ErrTxUnsupported, err)
+}
+return err
+}
+
+step = "SET"
+cmds := make([]string, len(keys))
+for i, key := range keys {
+cmds[i] = setCommand(key, pairs[key], 0)
+}
+start := time.Now()
+if err := c.writeCommand(cmds...); err != nil {
+return err
+}
+
+var queueErr error
+for i, cmd := range cmds {
+mark := c.received
+response, err := c.readReply()
+if err != nil {
+return err
+}
+c.traffic.record(cmd, len(cmd)+len(c.terminator), c.received-mark)
+if err := parseServerError(response); err != nil && queueErr == nil {
+queueErr = fmt.Errorf("SET %s: %w", keys[i], err)
+} else if err == nil && response != "QUEUED" && queueErr == nil {
+queueErr = fmt.Errorf("SET %s: unexpected response: %s", keys[i], response)
+}
+}
+
+if c.latency != nil {
+c.latency.record(cmds, start)
+}
+
+if queueErr != nil {
+if err := c.roundTrip("DISCARD"); err != nil {
+return errors.Join(queueErr, err)
+}
+return queueErr
+}
+
+step = "EXEC"
+start, mark := time.Now(), c.received
+if err := c.writeCommand("EXEC"); err != nil {
+return err
+}
+replies, err := c.readLines(false)
+if err != nil {
+return err
+}
+c.observeExchange("EXEC", start, mark)
+if len(replies) == 1 && isNotFound(replies[0]) {
+return errors.New("transaction aborted by the server")
+}
+if len(replies) != len(keys) {
+return fmt.Errorf("EXEC returned %d replies for %d commands", len(replies), len(keys))
+}
+for i, reply := range replies {
+if reply != "OK" {
+return fmt.Errorf("SET %s: unexpected response: %s", keys[i], reply)
+}
+}
+
+return nil
+}
+
+// roundTrip sends cmd and expects "OK". The caller must hold c.mu.
+func (c *Client) roundTrip(cmd string) error {
+start, mark := time.Now(), c.received
+if err := c.writeCommand(cmd); err != nil {
+return err
+}
+response, err := c.readReply()
+if err != nil {
+return err
+}
+c.observeExchange(cmd, start, mark)
+if err := parseServerError(response); err != nil {
+return err
+}
+if response != "OK" {
+return fmt.Errorf("%s: unexpected response: %s", cmd, response)
+}
+return nil
+}
@@ -0,0 +1,96 @@
+package nubdb
+
+import (
+"errors"
+"strconv"
+"strings"
+"testing"
+)
+
+// txStore adds MULTI/EXEC/DISCARD to a memStore for a single connection.
+// SETs of keys starting with "bad" are refused when queued.
+type txStore struct {
+*memStore
+inTx   bool
+queued []string
+}
+
+func (s *txStore) handle(line string) string {
+verb, _, _ := strings.Cut(line, " ")
+switch {
+case verb == "MULTI":
+s.inTx, s.queued = true, nil
+return "OK"
+case verb == "DISCARD":
+s.inTx, s.queued = false, nil
+return "OK"
+case verb == "EXEC":
+replies := []string{strconv.Itoa(len(s.queued))}
+for _, cmd := range s.queued {
+replies = append(replies, s.memStore.handle(cmd))
+}
+s.inTx, s.queued = false, nil
+return "*" + strings.Join(replies, "\n")
+case s.inTx && strings.HasPrefix(line, "SET bad"):
+return "ERROR: ERR invalid key"
+case s.inTx:
+s.queued = append(s.queued, line)
+return "QUEUED"
+}
+return s.memStore.handle(line)
+}
+
+func TestSetManyTx(t *testing.T) {
+store := &txStore{memStore: newMemStore()}
+client := connectFake(t, store.handle)
+
+if err := client.SetManyTx(map[string]string{"a": "1", "b": "2", "c": "3"}); err != nil {
+t.Fatalf("SetManyTx: %v", err)
+}
+for key, want := range map[string]string{"a": "1", "b": "2", "c": "3"} {
+if got, _ := client.Get(key); got != want {
+t.Errorf("%s = %q, want %q", key, got, want)
+}
+}
+}
+
+func TestSetManyTxAllOrNothing(t *testing.T) {
+store := &txStore{memStore: newMemStore()}
+client := connectFake(t, store.handle)
+
+// "bad" sorts between "a" and "c", so it fails mid-batch
+err := client.SetManyTx(map[string]string{"a": "1", "bad": "2", "c": "3"})
+var serr *ServerError
+if !errors.As(err, &serr) || serr.Code != "ERR" {
+t.Fatalf("SetManyTx = %v, want the queueing error", err)
+}
+if len(store.data) != 0 {
+t.Errorf("keys set despite the failure: %v", store.data)
+}
+
+// The transaction was discarded and the connection is still usable
+if err := client.Set("after", "ok", 0); err != nil {
+t.Errorf("Set after failed transaction: %v", err)
+}
+if store.data["after"] != "ok" {
+t.Errorf("Set after failed transaction was queued, not applied")
+}
+}
+
+func TestSetManyTxUnsupported(t *testing.T) {
+store := newMemStore()
+client := connectFake(t, func(line string) string {
+if line == "MULTI" {
+return "ERROR: Unknown command"
+}
+return store.handle(line)
+})
+
+err := client.SetManyTx(map[string]string{"a": "1", "b": "2"})
+if !errors.Is(err, ErrTxUnsupported) {
+t.Fatalf("SetManyTx = %v, want ErrTxUnsupported", err)
+}
+if len(store.data) != 0 {
+t.Errorf("keys set without transaction support: %v", store.data)
+}
+}
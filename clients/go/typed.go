@@ -0,0 +1,77 @@
+package nubdb
+
+import (
+"fmt"
+"strconv"
+"time"
+)
+
+// GetBytes retrieves a value by key as raw bytes, for binary values that
+// Get would reject as invalid UTF-8. A missing key yields nil; an empty
+// value yields an empty, non-nil slice.
+func (c *Client) GetBytes(key string, opts ...CallOption) ([]byte, error) {
+value, found, err := c.lookup(key, opts)
+if err != nil || !found {
+return nil, err
+}
+return []byte(value), nil
+}
+
+// SetBool stores value as "1" or "0", the same encoding DoArgs uses for
+// bools
+func (c *Client) SetBool(key string, value bool, ttl int, opts ...CallOption) error {
+encoded := "0"
+if value {
+encoded = "1"
+}
+return c.Set(key, encoded, ttl, opts...)
+}
+
+// GetBool returns the boolean stored at key. Besides "1" and "0" it accepts
+// the other spellings of strconv.ParseBool ("true", "false", "t", "F", ...)
+// so values written by other clients decode too. It returns ErrKeyNotFound
+// if the key does not exist.
+func (c *Client) GetBool(key string, opts ...CallOption) (bool, error) {
+value, found, err := c.lookup(key, opts)
+if err != nil {
+return false, err
+}
+if !found {
+return false, ErrKeyNotFound
+}
+
+b, err := strconv.ParseBool(value)
+if err != nil {
+return false, fmt.Errorf("key %s: invalid bool %q", key, value)
+}
+return b, nil
+}
+
+// SetTime stores value in RFC 3339 format with nanoseconds and its UTC
+// offset, e.g. "2024-05-01T12:30:00.5+02:00". The monotonic clock reading
+// is dropped.
+func (c *Client) SetTime(key string, value time.Time, ttl int, opts ...CallOption) error {
+return c.Set(key, value.Format(time.RFC3339Nano), ttl, opts...)
+}
+
+// GetTime returns the time stored at key, either in RFC 3339 format (with
+// or without fractional seconds) or as integer Unix seconds, which decode
+// as UTC. It returns ErrKeyNotFound if the key does not exist.
+func (c *Client) GetTime(key string, opts ...CallOption) (time.Time, error) {
+value, found, err := c.lookup(key, opts)
+if err != nil {
+return time.Time{}, err
+}
+if !found {
+return time.Time{}, ErrKeyNotFound
+}
+
+if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+return time.Unix(seconds, 0).UTC(), nil
+}
+t, err := time.Parse(time.RFC3339Nano, value)
+if err != nil {
+return time.Time{}, fmt.Errorf("key %s: invalid time %q", key, value)
+}
+return t, nil
+}
@@ -0,0 +1,114 @@
+package nubdb
+
+import (
+"bytes"
+"errors"
+"testing"
+"time"
+)
+
+func TestBoolRoundTrip(t *testing.T) {
+store := newMemStore()
+client := connectFake(t, store.handle)
+
+for _, value := range []bool{true, false} {
+if err := client.SetBool("flag", value, 0); err != nil {
+t.Fatalf("SetBool: %v", err)
+}
+got, err := client.GetBool("flag")
+if err != nil || got != value {
+t.Errorf("GetBool = %v, %v; want %v", got, err, value)
+}
+}
+if store.data["flag"] != "0" {
+t.Errorf("stored %q, want \"0\"", store.data["flag"])
+}
+
+for stored, want := range map[string]bool{"true": true, "false": false, "1": true, "0": false} {
+store.data["other"] = stored
+if got, err := client.GetBool("other"); err != nil || got != want {
+t.Errorf("GetBool(%q) = %v, %v; want %v", stored, got, err, want)
+}
+}
+
+store.data["bad"] = "yes"
+if _, err := client.GetBool("bad"); err == nil {
+t.Error("GetBool of \"yes\" should fail")
+}
+if _, err := client.GetBool("missing"); !errors.Is(err, ErrKeyNotFound) {
+t.Errorf("GetBool of missing key = %v, want ErrKeyNotFound", err)
+}
+}
+
+func TestTimeRoundTrip(t *testing.T) {
+store := newMemStore()
+client := connectFake(t, store.handle)
+
+zone := time.FixedZone("UTC+2", 2*60*60)
+for _, value := range []time.Time{
+{},
+time.Unix(0, 0).UTC(),
+time.Date(2024, 5, 1, 12, 30, 0, 500, zone),
+time.Date(9999, 12, 31, 23, 59, 59, 999999999, time.UTC),
+time.Now(),
+} {
+if err := client.SetTime("at", value, 0); err != nil {
+t.Fatalf("SetTime: %v", err)
+}
+got, err := client.GetTime("at")
+if err != nil {
+t.Fatalf("GetTime(%v): %v", value, err)
+}
+if !got.Equal(value) {
+t.Errorf("GetTime = %v, want %v", got, value)
+}
+}
+
+store.data["unix"] = "1700000000"
+if got, err := client.GetTime("unix"); err != nil || !got.Equal(time.Unix(1700000000, 0)) {
+t.Errorf("GetTime of unix seconds = %v, %v", got, err)
+}
+
+store.data["bad"] = "yesterday"
+if _, err := client.GetTime("bad"); err == nil {
+t.Error("GetTime of \"yesterday\" should fail")
+}
+if _, err := client.GetTime("missing"); !errors.Is(err, ErrKeyNotFound) {
+t.Errorf("GetTime of missing key = %v, want ErrKeyNotFound", err)
+}
+}
+
+func TestGetUTF8(t *testing.T) {
+store := newMemStore()
+store.data["text"] = "héllo, 世界"
+store.data["binary"] = "\xff\xfe\x00\x01"
+store.data["truncated"] = "caf\xc3"
+store.data["surrogate"] = "\xed\xa0\x80"
+store.data["empty"] = ""
+client := connectFake(t, store.handle)
+
+if value, err := client.Get("text"); err != nil || value != store.data["text"] {
+t.Errorf("Get(text) = %q, %v", value, err)
+}
+for _, key := range []string{"binary", "truncated", "surrogate"} {
+value, err := client.Get(key)
+if !errors.Is(err, ErrInvalidUTF8) {
+t.Errorf("Get(%s) error = %v, want ErrInvalidUTF8", key, err)
+}
+if value != store.data[key] {
+t.Errorf("Get(%s) = %q, want the raw value %q", key, value, store.data[key])
+}
+
+b, err := client.GetBytes(key)
+if err != nil || !bytes.Equal(b, []byte(store.data[key])) {
+t.Errorf("GetBytes(%s) = %q, %v", key, b, err)
+}
+}
+
+if b, err := client.GetBytes("empty"); err != nil || b == nil || len(b) != 0 {
+t.Errorf("GetBytes(empty) = %#v, %v, want an empty slice", b, err)
+}
+if b, err := client.GetBytes("missing"); err != nil || b != nil {
+t.Errorf("GetBytes(missing) = %#v, %v, want nil", b, err)
+}
+}
@@ -0,0 +1,132 @@
+package nubdb
+
+import (
+"errors"
+"fmt"
+)
+
+// ErrConflict is returned by Update when the key kept changing underneath it
+// for more than Config.MaxUpdateRetries attempts
+var ErrConflict = errors.New("update conflict: retries exhausted")
+
+// Update atomically replaces the value at key with the result of fn, which
+// receives the current value and whether the key exists. If another writer
+// changes the key between the read and the write, Update reads it again and
+// calls fn again, up to Config.MaxUpdateRetries times or until the deadline
+// given with WithDeadline passes. An error returned by fn aborts the update
+// and is returned as is, without retrying.
+func (c *Client) Update(key string, fn func(old string, found bool) (string, error), opts ...CallOption) error {
+deadline := newCallOptions(opts).deadline
+for attempt := 0; attempt <= c.config.MaxUpdateRetries; attempt++ {
+if err := checkDeadline(deadline); err != nil {
+return err
+}
+
+old, found, err := c.lookup(key, opts)
+if err != nil {
+return err
+}
+
+value, err := fn(old, found)
+if err != nil {
+return err
+}
+
+var swapped bool
+if found {
+swapped, err = c.compareAndSwap(key, old, value, opts)
+} else {
+swapped, err = c.setNX(key, value, opts)
+}
+if err != nil || swapped {
+return err
+}
+}
+
+return ErrConflict
+}
+
+// compareAndSwap sets key to value only if it currently holds old
+func (c *Client) compareAndSwap(key, old, value string, opts []CallOption) (bool, error) {
+response, err := c.sendCommand(casCommand(key, old, value), opts...)
+if err != nil {
+return false, err
+}
+
+return parseCASReply(response)
+}
+
+func casCommand(key, old, value string) string {
+return fmt.Sprintf(`CAS %s "%s" "%s"`, key, old, value)
+}
+
+// parseCASReply reports whether a CAS swapped the value; a conflict or a
+// missing key is not an error
+func parseCASReply(response string) (bool, error) {
+switch {
+case response == "OK":
+return true, nil
+case response == "(conflict)" || isNotFound(response):
+return false, nil
+}
+
+return false, fmt.Errorf("unexpected response: %s", response)
+}
+
+// setNX sets key to value only if it does not exist yet
+func (c *Client) setNX(key, value string, opts []CallOption) (bool, error) {
+response, err := c.sendCommand(fmt.Sprintf(`SETNX %s "%s"`, key, value), opts...)
+if err != nil {
+return false, err
+}
+
+switch response {
+case "1":
+return true, nil
+case "0":
+return false, nil
+}
+
+return false, fmt.Errorf("unexpected response: %s", response)
+}
+
+// GetOrSet returns the value at key, first storing defaultValue there if
+// the key does not exist; loaded reports whether the value was already
+// there. The SETNX and the GET are pipelined in one round trip, so callers
+// racing on a missing key all get the one value that was stored. A ttl is
+// applied with an EXPIRE sent after a successful SETNX, so until it lands
+// the new key has no expiry.
+func (c *Client) GetOrSet(key, defaultValue string, ttl int, opts ...CallOption) (value string, loaded bool, err error) {
+responses, err := c.sendCommands([]string{
+fmt.Sprintf(`SETNX %s "%s"`, key, defaultValue),
+fmt.Sprintf("GET %s", key),
+}, opts...)
+if err != nil {
+return "", false, err
+}
+
+for _, response := range responses {
+if err := parseServerError(response); err != nil {
+return "", false, err
+}
+}
+switch responses[0] {
+case "1":
+loaded = false
+case "0":
+loaded = true
+default:
+return "", false, fmt.Errorf("unexpected response: %s", responses[0])
+}
+value, found := parseGetReply(responses[1])
+if !found {
+return "", false, fmt.Errorf("%w: %s removed before it could be read", ErrKeyNotFound, key)
+}
+
+if ttl = c.jitterTTL(ttl); !loaded && ttl > 0 {
+if _, err := c.sendCommand(fmt.Sprintf("EXPIRE %s %d", key, ttl), opts...); err != nil {
+return value, loaded, err
+}
+}
+return value, loaded, nil
+}
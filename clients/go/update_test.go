@@ -0,0 +1,183 @@
+package nubdb
+
+import (
+"errors"
+"os"
+"strconv"
+"strings"
+"sync"
+"sync/atomic"
+"testing"
+"time"
+)
+
+func TestUpdateConcurrent(t *testing.T) {
+store := newMemStore()
+config := startFakeServer(t, store.handle)
+config.MaxUpdateRetries = 1000
+
+const workers, increments = 8, 25
+var wg sync.WaitGroup
+for i := 0; i < workers; i++ {
+// Separate clients so updaters genuinely race on the server
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+wg.Add(1)
+go func() {
+defer wg.Done()
+for j := 0; j < increments; j++ {
+err := client.Update("counter", func(old string, found bool) (string, error) {
+n, _ := strconv.Atoi(old)
+return strconv.Itoa(n + 1), nil
+})
+if err != nil {
+t.Errorf("Update: %v", err)
+return
+}
+}
+}()
+}
+wg.Wait()
+
+if got, want := store.data["counter"], strconv.Itoa(workers*increments); got != want {
+t.Fatalf("counter = %s, want %s", got, want)
+}
+}
+
+func TestUpdateFnError(t *testing.T) {
+calls := 0
+client := connectFake(t, newMemStore().handle)
+
+boom := errors.New("boom")
+err := client.Update("k", func(string, bool) (string, error) {
+calls++
+return "", boom
+})
+if !errors.Is(err, boom) || calls != 1 {
+t.Fatalf("Update error = %v after %d calls, want boom after 1", err, calls)
+}
+}
+
+func TestUpdateConflict(t *testing.T) {
+store := newMemStore()
+store.data["k"] = "v"
+config := startFakeServer(t, func(line string) string {
+if line == `CAS k "v" "w"` {
+return "(conflict)"
+}
+return store.handle(line)
+})
+config.MaxUpdateRetries = 2
+
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+calls := 0
+err = client.Update("k", func(old string, found bool) (string, error) {
+calls++
+return "w", nil
+})
+if !errors.Is(err, ErrConflict) || calls != 3 {
+t.Fatalf("Update error = %v after %d calls, want ErrConflict after 3", err, calls)
+}
+}
+
+func TestUpdateDeadlineBudget(t *testing.T) {
+store := newMemStore()
+store.data["k"] = "v"
+config := startFakeServer(t, func(line string) string {
+if strings.HasPrefix(line, "CAS ") {
+time.Sleep(20 * time.Millisecond)
+return "(conflict)"
+}
+return store.handle(line)
+})
+config.MaxUpdateRetries = 1000
+
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+start := time.Now()
+err = client.Update("k", func(old string, found bool) (string, error) {
+return old + "!", nil
+}, WithDeadline(time.Now().Add(100*time.Millisecond)))
+elapsed := time.Since(start)
+
+if !errors.Is(err, os.ErrDeadlineExceeded) {
+t.Fatalf("Update error = %v, want deadline exceeded", err)
+}
+if elapsed > 200*time.Millisecond {
+t.Fatalf("Update took %s, budget was 100ms", elapsed)
+}
+}
+
+func TestGetOrSet(t *testing.T) {
+store := newMemStore()
+store.data["existing"] = "old"
+client := connectFake(t, store.handle)
+
+if value, loaded, err := client.GetOrSet("existing", "default", 60); err != nil || !loaded || value != "old" {
+t.Errorf("GetOrSet of an existing key = %q, %v, %v", value, loaded, err)
+}
+if _, ok := store.ttl["existing"]; ok {
+t.Error("GetOrSet set a TTL on an existing key")
+}
+
+if value, loaded, err := client.GetOrSet("new", "default", 60); err != nil || loaded || value != "default" {
+t.Errorf("GetOrSet of a missing key = %q, %v, %v", value, loaded, err)
+}
+if store.ttl["new"] != 60 {
+t.Errorf("TTL of the stored default = %d, want 60", store.ttl["new"])
+}
+}
+
+func TestGetOrSetConcurrent(t *testing.T) {
+store := newMemStore()
+config := startFakeServer(t, store.handle)
+
+const workers = 16
+values := make([]string, workers)
+var stored atomic.Int64
+var wg sync.WaitGroup
+for i := 0; i < workers; i++ {
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+wg.Add(1)
+go func() {
+defer wg.Done()
+value, loaded, err := client.GetOrSet("k", strconv.Itoa(i), 0)
+if err != nil {
+t.Errorf("GetOrSet: %v", err)
+return
+}
+if !loaded {
+stored.Add(1)
+}
+values[i] = value
+}()
+}
+wg.Wait()
+
+if n := stored.Load(); n != 1 {
+t.Errorf("%d callers stored their default, want 1", n)
+}
+for i, value := range values {
+if value != store.data["k"] {
+t.Errorf("caller %d saw %q, want the stored %q", i, value, store.data["k"])
+}
+}
+}
@@ -0,0 +1,103 @@
+package nubdb
+
+import (
+"context"
+"fmt"
+"strings"
+"time"
+)
+
+// Watch calls onChange each time the value at key changes, until ctx is
+// done, and then returns ctx.Err(). The value when Watch starts is taken as
+// the baseline and not reported; the key being created or removed counts
+// as a change, with found telling which. Reads go to the server, past the
+// Config.CacheSize cache, and do not refresh Config.SlidingTTL; a read
+// error ends the watch and is returned.
+//
+// If the server is configured to publish keyspace notifications for every
+// change ("A", or "g$x", with "K" in notify-keyspace-events), Watch reads
+// the key when notified, and polls every interval only if the subscription
+// fails. Otherwise it polls every interval. Either way, a change undone
+// before the next read goes unseen.
+func (c *Client) Watch(ctx context.Context, key string, interval time.Duration, onChange func(newValue string, found bool)) error {
+if interval <= 0 {
+return fmt.Errorf("Watch: invalid interval %s", interval)
+}
+
+var opts []CallOption
+if deadline, ok := ctx.Deadline(); ok {
+opts = append(opts, WithDeadline(deadline))
+}
+
+// Subscribe before reading the baseline so no change falls in between
+var events <-chan KeyEvent
+if c.keyspaceNotifications(opts) {
+if sub, err := c.SubscribeKeyEvents(globEscape(key)); err == nil {
+defer sub.Close()
+events = sub.Events()
+}
+}
+
+last, lastFound, err := c.lookupPlain(key, opts)
+if err != nil {
+return err
+}
+
+ticker := time.NewTicker(interval)
+defer ticker.Stop()
+for {
+select {
+case <-ctx.Done():
+return ctx.Err()
+case _, ok := <-events:
+if !ok {
+// The subscription failed; poll from now on
+events = nil
+}
+case <-ticker.C:
+if events != nil {
+continue
+}
+}
+
+value, found, err := c.lookupPlain(key, opts)
+if err != nil {
+if ctx.Err() != nil {
+return ctx.Err()
+}
+return err
+}
+if value != last || found != lastFound {
+last, lastFound = value, found
+onChange(value, found)
+}
+}
+}
+
+// keyspaceNotifications reports whether the server publishes a keyspace
+// notification for every change Watch must see: writes, deletions and
+// expiries
+func (c *Client) keyspaceNotifications(opts []CallOption) bool {
+params, err := c.ConfigGet("notify-keyspace-events", opts...)
+if err != nil {
+return false
+}
+flags := params["notify-keyspace-events"]
+if !strings.Contains(flags, "K") {
+return false
+}
+return strings.Contains(flags, "A") || (strings.Contains(flags, "g") && strings.Contains(flags, "$") && strings.Contains(flags, "x"))
+}
+
+// globEscape escapes the glob metacharacters in s so a pattern matches it
+// literally
+func globEscape(s string) string {
+var b strings.Builder
+for _, r := range s {
+if strings.ContainsRune(`*?[]\`, r) {
+b.WriteByte('\\')
+}
+b.WriteRune(r)
+}
+return b.String()
+}
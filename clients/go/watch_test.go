@@ -0,0 +1,220 @@
+package nubdb
+
+import (
+"context"
+"errors"
+"runtime"
+"strings"
+"sync"
+"testing"
+"time"
+)
+
+type watchChange struct {
+value string
+found bool
+}
+
+// startWatch runs Watch in the background, returning its changes, its
+// result and a function stopping it
+func startWatch(t *testing.T, client *Client, key string, interval time.Duration) (<-chan watchChange, <-chan error, context.CancelFunc) {
+t.Helper()
+
+ctx, cancel := context.WithCancel(context.Background())
+changes := make(chan watchChange, 10)
+done := make(chan error, 1)
+go func() {
+done <- client.Watch(ctx, key, interval, func(value string, found bool) {
+changes <- watchChange{value, found}
+})
+}()
+t.Cleanup(cancel)
+return changes, done, cancel
+}
+
+func TestWatchPolling(t *testing.T) {
+store := newMemStore()
+store.data["k"] = "v0"
+read := make(chan struct{})
+client := connectFake(t, func(line string) string {
+if line == "GET k" {
+select {
+case read <- struct{}{}:
+default:
+}
+}
+return store.handle(line)
+})
+writer := connectFake(t, store.handle)
+
+changes, done, cancel := startWatch(t, client, "k", 5*time.Millisecond)
+<-read // the baseline, or a poll after it
+
+// Unchanged polls are not reported
+<-read
+<-read
+if err := writer.Set("k", "v1", 0); err != nil {
+t.Fatalf("Set: %v", err)
+}
+if change := receive(t, changes); change != (watchChange{"v1", true}) {
+t.Errorf("first change = %+v", change)
+}
+if err := writer.Delete("k"); err != nil {
+t.Fatalf("Delete: %v", err)
+}
+if change := receive(t, changes); change != (watchChange{"", false}) {
+t.Errorf("second change = %+v", change)
+}
+
+cancel()
+if err := receive(t, done); !errors.Is(err, context.Canceled) {
+t.Errorf("Watch = %v, want context.Canceled", err)
+}
+select {
+case change := <-changes:
+t.Errorf("unexpected change %+v", change)
+default:
+}
+}
+
+func TestWatchLeavesSlidingTTL(t *testing.T) {
+store := newMemStore()
+store.data["k"] = "v"
+var mu sync.Mutex
+var expires int
+read := make(chan struct{})
+config := startFakeServer(t, func(line string) string {
+if strings.HasPrefix(line, "EXPIRE") {
+mu.Lock()
+expires++
+mu.Unlock()
+}
+if line == "GET k" {
+select {
+case read <- struct{}{}:
+default:
+}
+}
+return store.handle(line)
+})
+config.SlidingTTL = time.Minute
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+_, done, cancel := startWatch(t, client, "k", time.Millisecond)
+for i := 0; i < 5; i++ {
+<-read
+}
+cancel()
+receive(t, done)
+
+mu.Lock()
+defer mu.Unlock()
+if expires != 0 {
+t.Errorf("Watch sent %d EXPIREs, want none", expires)
+}
+}
+
+func TestWatchKeyspaceNotifications(t *testing.T) {
+server, config := startPubSubServer(t)
+var mu sync.Mutex
+value := `"v0"`
+read := make(chan struct{}, 10)
+server.mu.Lock()
+server.handle = func(line string) string {
+switch line {
+case "CONFIG GET notify-keyspace-events":
+return multiLine("notify-keyspace-events", "KA")
+case "GET a*b":
+read <- struct{}{}
+mu.Lock()
+defer mu.Unlock()
+return value
+}
+return "ERROR: unknown command"
+}
+server.mu.Unlock()
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+// Polling every hour, so only notifications can drive reads
+changes, _, _ := startWatch(t, client, "a*b", time.Hour)
+if cmd := receive(t, server.subs); cmd != `PSUBSCRIBE __keyspace@*__:a\*b` {
+t.Errorf("sent %q", cmd)
+}
+receive(t, read)
+
+for _, next := range []string{"v1", "v2"} {
+mu.Lock()
+value = `"` + next + `"`
+mu.Unlock()
+server.push("*4", "pmessage", `__keyspace@*__:a\*b`, "__keyspace@0__:a*b", "set")
+if change := receive(t, changes); change != (watchChange{next, true}) {
+t.Errorf("change = %+v, want %s", change, next)
+}
+}
+}
+
+func TestWatchStopsSubscription(t *testing.T) {
+server, config := startPubSubServer(t)
+release := make(chan struct{})
+reads := make(chan struct{}, 10)
+server.mu.Lock()
+server.handle = func(line string) string {
+switch line {
+case "CONFIG GET notify-keyspace-events":
+return multiLine("notify-keyspace-events", "KA")
+case "GET k":
+reads <- struct{}{}
+if len(reads) > 1 {
+<-release
+}
+return `"v"`
+}
+return "ERROR: unknown command"
+}
+server.mu.Unlock()
+client, err := Connect(config)
+if err != nil {
+t.Fatalf("connect: %v", err)
+}
+defer client.Close()
+
+before := runtime.NumGoroutine()
+_, done, cancel := startWatch(t, client, "k", time.Hour)
+receive(t, server.subs)
+receive(t, reads)
+
+// Flood notifications while Watch is stuck reading, so they back up
+// past every buffer, then stop Watch without reading them
+server.push("*4", "pmessage", "__keyspace@*__:k", "__keyspace@0__:k", "set")
+receive(t, reads)
+for i := 0; i < 300; i++ {
+server.push("*4", "pmessage", "__keyspace@*__:k", "__keyspace@0__:k", "set")
+}
+cancel()
+close(release)
+if err := receive(t, done); !errors.Is(err, context.Canceled) {
+t.Fatalf("Watch = %v, want context.Canceled", err)
+}
+
+for deadline := time.Now().Add(2 * time.Second); runtime.NumGoroutine() > before; {
+if time.Now().After(deadline) {
+t.Fatalf("%d goroutines left after Watch returned, want %d", runtime.NumGoroutine(), before)
+}
+time.Sleep(5 * time.Millisecond)
+}
+}
+
+func TestWatchInvalidInterval(t *testing.T) {
+client := connectFake(t, newMemStore().handle)
+if err := client.Watch(context.Background(), "k", 0, func(string, bool) {}); err == nil {
+t.Error("Watch with a zero interval should fail")
+}
+}